@@ -3,50 +3,265 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"runtime"
+	"strings"
 
 	"github.com/Solifugus/mbl/pkg/lexer"
 	"github.com/Solifugus/mbl/pkg/placer"
 	"github.com/Solifugus/mbl/pkg/runner"
 )
 
+// Version is the interpreter version reported by --version. Release builds set
+// it with -ldflags "-X main.Version=v1.2.3".
+var Version = "dev"
+
+// Exit codes tell scripts at which stage the interpreter failed.
+const (
+	exitOK      = 0
+	exitIO      = 1 // the program could not be read, or the arguments were wrong
+	exitLex     = 2 // the source could not be tokenized
+	exitPlace   = 3 // the tokens could not be placed into a tree
+	exitRuntime = 4 // the program failed while running
+)
+
 func main() {
-	// Check if a file path is provided as a command-line argument
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: mblinterpreter <file_path>")
-		os.Exit(1)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the interpreter with the given arguments and streams and
+// returns the process exit code. With no file argument, or with -i, it starts
+// an interactive session instead of running a file. A file path of "-"
+// reads the whole program from stdin, and -e takes the program from the
+// command line instead of from a file. Several files run one after another
+// with the same runner, so a library given first can be used by a script
+// given after it; the first file to fail stops the run.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("mblinterpreter", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mblinterpreter [--version] [-i] [--tokens | --tree] [-e <program> | - | <file_path>...]")
+		flags.PrintDefaults()
+		fmt.Fprintln(stderr, "Exit codes: 0 success, 1 I/O or usage error, 2 lex error, 3 place error, 4 runtime error")
+	}
+
+	version := flags.Bool("version", false, "print the interpreter and Go versions and exit")
+	interactive := flags.Bool("i", false, "start an interactive session")
+	inline := flags.String("e", "", "run the given program instead of a file")
+	var dumpTokens bool
+	flags.BoolVar(&dumpTokens, "tokens", false, "print the lexed tokens instead of running")
+	flags.BoolVar(&dumpTokens, "t", false, "shorthand for --tokens")
+	var dumpTree bool
+	flags.BoolVar(&dumpTree, "tree", false, "print the placed node tree instead of running")
+	flags.BoolVar(&dumpTree, "ast", false, "alias for --tree")
+
+	if err := flags.Parse(args); err != nil {
+		return exitIO
+	}
+
+	if *version {
+		fmt.Fprintf(stdout, "mblinterpreter %s (%s)\n", Version, runtime.Version())
+		return exitOK
+	}
+
+	hasInline := false
+	flags.Visit(func(f *flag.Flag) {
+		hasInline = hasInline || f.Name == "e"
+	})
+
+	if hasInline && flags.NArg() > 0 {
+		fmt.Fprintln(stderr, "cannot use -e together with a file argument")
+		flags.Usage()
+		return exitIO
+	}
+
+	if *interactive || (flags.NArg() == 0 && !hasInline) {
+		return repl(stdin, stdout, stderr)
 	}
 
-	// Read the MBL source code from the file
-	filePath := os.Args[1]
-	sourceCode, err := ioutil.ReadFile(filePath)
+	// Take the MBL source code from -e, or read it from each file, or from stdin for "-".
+	// Every file is read before any runs, so a missing file stops the run before it starts.
+	var sources []sourceFile
+	if hasInline {
+		sources = []sourceFile{{name: "-e", code: *inline}}
+	} else {
+		for _, path := range flags.Args() {
+			code, err := readSource(path, stdin)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitIO
+			}
+			sources = append(sources, sourceFile{name: path, code: string(code)})
+		}
+	}
+
+	// With several files, an error says which one it came from.
+	fail := func(source sourceFile, err error) int {
+		if len(sources) > 1 {
+			fmt.Fprintf(stderr, "%s: %v\n", source.name, err)
+		} else {
+			fmt.Fprintln(stderr, err)
+		}
+		return exitCode(err)
+	}
+
+	if dumpTokens || dumpTree {
+		for _, source := range sources {
+			var err error
+			if dumpTokens {
+				err = printTokens(source.code, stdout)
+			} else {
+				err = printTree(source.code, stdout)
+			}
+			if err != nil {
+				return fail(source, err)
+			}
+		}
+		return exitOK
+	}
+
+	// Create a runner and execute functions at specified places in storage
+	r := runner.NewRunner()
+	r.SetOutput(stdout)
+	for _, source := range sources {
+		_, err := evaluate(r, lexer.NewLexer(source.code))
+		if err != nil {
+			return fail(source, err)
+		}
+	}
+
+	// A one-liner's output is often piped elsewhere, so it gets no banner.
+	if !hasInline {
+		fmt.Fprintln(stdout, "MBL program executed successfully!")
+	}
+	return exitOK
+}
+
+// exitCode returns the exit code for the stage that produced err. Errors that
+// are neither lex nor place errors come from running the program.
+func exitCode(err error) int {
+	var lexErr *lexer.LexError
+	var placeErr *placer.PlaceError
+	switch {
+	case errors.As(err, &lexErr):
+		return exitLex
+	case errors.As(err, &placeErr):
+		return exitPlace
+	default:
+		return exitRuntime
+	}
+}
+
+// tokenEscaper makes new lines and tabs visible in token dumps.
+var tokenEscaper = strings.NewReplacer("\n", `\n`, "\t", `\t`, "\r", `\r`)
+
+// sourceFile is a program to run and the name to report its errors under.
+type sourceFile struct {
+	name string
+	code string
+}
+
+// printTokens lexes source code and prints each token as "TYPE: value", one per line.
+func printTokens(source string, stdout io.Writer) error {
+	tokens, err := lexer.Tokenize(source)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		fmt.Fprintf(stdout, "%s: %s\n", token.Type, tokenEscaper.Replace(token.Value))
+	}
+	return nil
+}
+
+// repl reads one line at a time, evaluates it and prints its result. Variables
+// persist from line to line. Errors are reported and the session carries on
+// until the input ends or the user types exit.
+func repl(stdin io.Reader, stdout, stderr io.Writer) int {
+	r := runner.NewRunner()
+	r.SetOutput(stdout)
+	l := lexer.NewLexer("")
+
+	scanner := bufio.NewScanner(stdin)
+	for {
+		fmt.Fprint(stdout, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(stdout)
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "exit" {
+			break
+		}
+
+		l.Reset(line)
+		value, err := evaluate(r, l)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			continue
+		}
+		if value.Kind != runner.Null {
+			fmt.Fprintln(stdout, value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitIO
+	}
+	return exitOK
+}
+
+// readSource reads a whole program from the named file, or from stdin when the name is "-".
+func readSource(path string, stdin io.Reader) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// printTree places source code and prints the resulting node tree as an indented outline.
+func printTree(source string, stdout io.Writer) error {
+	root, err := place(lexer.NewLexer(source))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	// Create a lexer and tokenize the source code
-	lexer := lexer.NewLexer(string(sourceCode))
-	tokens, err := lexer.Lex()
+	fmt.Fprint(stdout, root.Tree())
+	return nil
+}
+
+// evaluate lexes, places and runs the lexer's input with the given runner.
+func evaluate(r *runner.Runner, l *lexer.Lexer) (runner.Value, error) {
+	root, err := place(l)
 	if err != nil {
-		log.Fatal(err)
+		return runner.Value{}, err
 	}
 
-	// Create a placer and place tokens in the hierarchical data structure
-	placer := placer.NewPlacer()
-	err = placer.PlaceTokens(tokens)
+	return r.Eval(root)
+}
+
+// place tokenizes the lexer's input and places the tokens in brace mode.
+func place(l *lexer.Lexer) (*placer.Node, error) {
+	tokens, err := l.Lex()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	// Create a runner and execute functions at specified places in storage
-	runner := runner.NewRunner()
-	err = runner.Run(tokens)
+	// Create a placer and place tokens in the hierarchical data structure
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err = p.PlaceTokens(tokens)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	fmt.Println("MBL program executed successfully!")
+	return p.Root(), nil
 }
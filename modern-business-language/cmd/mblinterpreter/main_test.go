@@ -0,0 +1,306 @@
+// cmd/mblinterpreter/main_test.go
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	input := strings.Join([]string{
+		"x := 40",
+		"x + 2",
+		"y := undefined",
+		`print "still running"`,
+		"x * 2",
+		"exit",
+		"x",
+	}, "\n")
+
+	var stdout, stderr bytes.Buffer
+	code := run(nil, strings.NewReader(input), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	expected := "> > 42\n> > still running\n> 80\n> "
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, stdout.String())
+	}
+
+	if !strings.Contains(stderr.String(), `runtime error at line 1 col 6: undefined variable "undefined"`) {
+		t.Errorf("expected the runtime error to be reported, got %q", stderr.String())
+	}
+}
+
+func TestREPLEndOfInput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-i"}, strings.NewReader("1 +\n3 * 3\n"), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	if expected := "> > 9\n> \n"; stdout.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, stdout.String())
+	}
+
+	if stderr.Len() == 0 {
+		t.Errorf("expected the incomplete expression to be reported")
+	}
+}
+
+func TestRunFromStdin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		program  string
+		code     int
+		expected string
+	}{
+		{
+			name:     "valid program",
+			program:  "total := 2 + 3\nprint \"total: \" + total\n",
+			code:     0,
+			expected: "total: 5\nMBL program executed successfully!\n",
+		},
+		{
+			name:     "runtime error",
+			program:  "print missing\n",
+			code:     4,
+			expected: "",
+		},
+		{
+			name:     "place error before the last statement",
+			program:  "f := fn(a) -> a\nf(1 2)\nprint 3\n",
+			code:     3,
+			expected: "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run([]string{"-"}, strings.NewReader(testCase.program), &stdout, &stderr)
+			if code != testCase.code {
+				t.Fatalf("expected exit code %d, got %d (stderr %q)", testCase.code, code, stderr.String())
+			}
+
+			if stdout.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, stdout.String())
+			}
+		})
+	}
+}
+
+func TestDumpTokens(t *testing.T) {
+	program := "if x >= 10 {\n\tprint \"big\"\n}\n"
+	expected := strings.Join([]string{
+		"Keyword: if",
+		"Alphanumeric: x",
+		"Symbol: >=",
+		"Numeric: 10",
+		"Symbol: {",
+		`NewLine: \n`,
+		`Tab: \t`,
+		"Alphanumeric: print",
+		"Text: big",
+		`NewLine: \n`,
+		"Symbol: }",
+		`NewLine: \n`,
+		"EOF: ",
+	}, "\n") + "\n"
+
+	for _, flag := range []string{"--tokens", "-t"} {
+		t.Run(flag, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run([]string{flag, "-"}, strings.NewReader(program), &stdout, &stderr)
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+			}
+
+			if stdout.String() != expected {
+				t.Errorf("expected output %q, got %q", expected, stdout.String())
+			}
+		})
+	}
+}
+
+func TestDumpTree(t *testing.T) {
+	program := "while x < 3 {\n\tif x == 1 {\n\t\tprint x\n\t}\n}\n"
+	expected := strings.Join([]string{
+		"Root",
+		"  Statement",
+		`    Leaf "while"`,
+		`    Leaf "x"`,
+		`    Leaf "<"`,
+		`    Leaf "3"`,
+		"    Block",
+		"      Statement",
+		`        Leaf "if"`,
+		`        Leaf "x"`,
+		`        Leaf "=="`,
+		`        Leaf "1"`,
+		"        Block",
+		"          Statement",
+		`            Leaf "print"`,
+		`            Leaf "x"`,
+	}, "\n") + "\n"
+
+	for _, flag := range []string{"--tree", "--ast"} {
+		t.Run(flag, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run([]string{flag, "-"}, strings.NewReader(program), &stdout, &stderr)
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+			}
+
+			if stdout.String() != expected {
+				t.Errorf("expected output:\n%s\ngot:\n%s", expected, stdout.String())
+			}
+		})
+	}
+}
+
+func TestInlineProgram(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-e", "total := 1 + 2\nprint \"total: \" + total"}, strings.NewReader(""), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+	}
+
+	if expected := "total: 3\n"; stdout.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, stdout.String())
+	}
+
+	stdout.Reset()
+	code = run([]string{"--tokens", "-e", "x"}, strings.NewReader(""), &stdout, &stderr)
+	if code != 0 || stdout.String() != "Alphanumeric: x\nEOF: \n" {
+		t.Errorf("expected -e to feed the token dump, got code %d and %q", code, stdout.String())
+	}
+}
+
+func TestInlineProgramWithFile(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-e", "print 1", "program.mbl"}, strings.NewReader(""), &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+
+	if !strings.Contains(stderr.String(), "cannot use -e together with a file argument") {
+		t.Errorf("expected the conflict to be reported, got %q", stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected nothing to run, got %q", stdout.String())
+	}
+}
+
+func TestVersion(t *testing.T) {
+	defer func(original string) { Version = original }(Version)
+	Version = "v1.2.3"
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"--version", "program.mbl"}, strings.NewReader(""), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+	}
+
+	expected := "mblinterpreter v1.2.3 (" + runtime.Version() + ")\n"
+	if stdout.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, stdout.String())
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.mbl")
+
+	testCases := []struct {
+		name    string
+		args    []string
+		program string
+		code    int
+		message string
+	}{
+		{name: "unreadable file", args: []string{missing}, code: 1, message: "missing.mbl"},
+		{name: "unreadable second file", args: []string{"-", missing}, program: "print 1\n", code: 1, message: "missing.mbl"},
+		{name: "unknown flag", args: []string{"--bogus", "-"}, code: 1, message: "bogus"},
+		{name: "lex error", args: []string{"-"}, program: "x := \"unclosed\n", code: 2, message: "lex error"},
+		{name: "lex error in token dump", args: []string{"--tokens", "-"}, program: "x := 'ab'\n", code: 2, message: "lex error"},
+		{name: "place error", args: []string{"-"}, program: "x := (1 + 2\n", code: 3, message: "place error"},
+		{name: "place error in tree dump", args: []string{"--tree", "-"}, program: "}\n", code: 3, message: "place error"},
+		{name: "runtime error", args: []string{"-"}, program: "x := 1 / 0\n", code: 4, message: "runtime error"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(testCase.args, strings.NewReader(testCase.program), &stdout, &stderr)
+			if code != testCase.code {
+				t.Fatalf("expected exit code %d, got %d (stderr %q)", testCase.code, code, stderr.String())
+			}
+
+			if !strings.Contains(stderr.String(), testCase.message) {
+				t.Errorf("expected stderr to mention %q, got %q", testCase.message, stderr.String())
+			}
+		})
+	}
+}
+
+// writeProgram saves an MBL program in a temporary directory and returns its path.
+func writeProgram(t *testing.T, dir, name, program string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(program), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func TestMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	library := writeProgram(t, dir, "library.mbl", "rate := 3\nfunction scale(n) {\n\treturn n * rate\n}\n")
+	script := writeProgram(t, dir, "script.mbl", "print scale(14)\n")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{library, script}, strings.NewReader(""), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr %q)", code, stderr.String())
+	}
+
+	if expected := "42\nMBL program executed successfully!\n"; stdout.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, stdout.String())
+	}
+}
+
+func TestMultipleFilesStopAtFirstError(t *testing.T) {
+	dir := t.TempDir()
+	first := writeProgram(t, dir, "first.mbl", "print \"first\"\n")
+	broken := writeProgram(t, dir, "broken.mbl", "x := 1 / 0\n")
+	last := writeProgram(t, dir, "last.mbl", "print \"last\"\n")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{first, broken, last}, strings.NewReader(""), &stdout, &stderr)
+	if code != 4 {
+		t.Fatalf("expected exit code 4, got %d (stderr %q)", code, stderr.String())
+	}
+
+	if expected := "first\n"; stdout.String() != expected {
+		t.Errorf("expected only the first file to run, got %q", stdout.String())
+	}
+	if expected := broken + ": runtime error at line 1 col 8: division by zero\n"; stderr.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stderr.String())
+	}
+}
+
+func TestUsageDocumentsExitCodes(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	run([]string{"-h"}, strings.NewReader(""), &stdout, &stderr)
+
+	if !strings.Contains(stderr.String(), "4 runtime error") {
+		t.Errorf("expected the help text to list the exit codes, got %q", stderr.String())
+	}
+}
@@ -0,0 +1,45 @@
+// lexer/error.go
+
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LexError describes a failure to tokenize the source code at a specific position.
+type LexError struct {
+	Message string
+	Line    int
+	Column  int
+	Offset  int
+}
+
+// Error renders the error with its line and column.
+func (e *LexError) Error() string {
+	return fmt.Sprintf("lex error at line %d col %d: %s", e.Line, e.Column, e.Message)
+}
+
+// SourceLine returns the given 1-based line of input without its line break,
+// or an empty string when the line does not exist.
+func SourceLine(input string, line int) string {
+	if line < 1 {
+		return ""
+	}
+
+	lines := strings.Split(normalizeLineBreaks(input), "\n")
+	if line > len(lines) {
+		return ""
+	}
+	return lines[line-1]
+}
+
+// Helper function to build a LexError positioned at the given line, column and byte offset.
+func (l *Lexer) errorAt(line, column, offset int, format string, args ...interface{}) error {
+	return &LexError{
+		Message: fmt.Sprintf(format, args...),
+		Line:    line,
+		Column:  column,
+		Offset:  offset,
+	}
+}
@@ -0,0 +1,101 @@
+// lexer/interpolation.go
+
+package lexer
+
+// Segment is one piece of an Interpolation token: either literal text or an
+// expression written in braces.
+type Segment struct {
+	// Text is the literal text of the segment, with its escapes decoded.
+	Text string
+
+	// Tokens holds the tokens of an expression segment, without the EOF,
+	// positioned where they appear in the source. It is nil for literal text.
+	Tokens []Token
+}
+
+// Segments splits the value of an Interpolation token into its literal text and
+// embedded expressions, in order. Braces nest, so an expression may hold a
+// record literal; a literal brace is written \{ or \}. An expression cannot
+// hold the quote of the text around it, since that ends the text.
+func (t Token) Segments() ([]Segment, error) {
+	l := NewLexer(t.Value)
+	l.line, l.column = t.Line, t.Column+1 // Skip the opening quote
+	return l.segments()
+}
+
+// Helper function to split the input of a lexer holding the inside of an
+// interpolated text into segments.
+func (l *Lexer) segments() ([]Segment, error) {
+	var segments []Segment
+	var text []byte
+
+	for l.pos < len(l.input) {
+		switch c := l.input[l.pos]; c {
+		case '\\':
+			l.advance() // Skip the backslash
+			text = append(text, escapes[l.input[l.pos]])
+			l.advance()
+		case '{':
+			expression, err := l.consumeInterpolation()
+			if err != nil {
+				return nil, err
+			}
+			if len(text) > 0 {
+				segments = append(segments, Segment{Text: string(text)})
+				text = nil
+			}
+			segments = append(segments, expression)
+		case '}':
+			return nil, l.errorAt(l.line, l.column, l.pos, "unmatched '}' in text")
+		default:
+			start := l.pos
+			l.advance()
+			text = append(text, l.input[start:l.pos]...)
+		}
+	}
+
+	if len(text) > 0 {
+		segments = append(segments, Segment{Text: string(text)})
+	}
+	return segments, nil
+}
+
+// Helper function to consume an expression in braces within interpolated text
+// and lex it where it stands.
+func (l *Lexer) consumeInterpolation() (Segment, error) {
+	line, column, offset := l.line, l.column, l.pos
+	l.advance() // Skip the '{'
+
+	exprLine, exprColumn, start := l.line, l.column, l.pos
+	for depth := 1; ; {
+		if l.pos == len(l.input) {
+			return Segment{}, l.errorAt(line, column, offset, "unclosed '{' in text")
+		}
+
+		switch l.input[l.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+		l.advance()
+	}
+
+	expression := NewLexer(l.input[start:l.pos])
+	expression.line, expression.column = exprLine, exprColumn
+	l.advance() // Skip the '}'
+
+	tokens, err := expression.Lex()
+	if err != nil {
+		return Segment{}, err
+	}
+
+	tokens = tokens[:len(tokens)-1] // Drop the EOF
+	if len(tokens) == 0 {
+		return Segment{}, l.errorAt(line, column, offset, "empty interpolation in text")
+	}
+	return Segment{Tokens: tokens}, nil
+}
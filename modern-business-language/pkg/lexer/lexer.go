@@ -5,7 +5,9 @@ package lexer
 import (
 	"fmt"
 	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 // TokenType represents the type of a token.
@@ -18,146 +20,871 @@ const (
 	NewLine
 	Tab
 	Symbol
+	Comment
+	Keyword
+	Boolean
+	Null
+	Currency
+	Date
+	Whitespace
+	Char
+	Directive
+	Interpolation
+	EOF
 )
 
+var tokenTypeNames = map[TokenType]string{
+	Text:          "Text",
+	Numeric:       "Numeric",
+	Alphanumeric:  "Alphanumeric",
+	NewLine:       "NewLine",
+	Tab:           "Tab",
+	Symbol:        "Symbol",
+	Comment:       "Comment",
+	Keyword:       "Keyword",
+	Boolean:       "Boolean",
+	Null:          "Null",
+	Currency:      "Currency",
+	Date:          "Date",
+	Whitespace:    "Whitespace",
+	Char:          "Char",
+	Directive:     "Directive",
+	Interpolation: "Interpolation",
+	EOF:           "EOF",
+}
+
+// String returns the name of the token type.
+func (t TokenType) String() string {
+	if name, ok := tokenTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
+// DefaultKeywords lists the words a new Lexer classifies as Keyword tokens.
+var DefaultKeywords = []string{"if", "else", "while", "for", "in", "break", "continue", "function", "return", "switch", "case", "default", "const", "fn"}
+
+// escapes maps the character following a backslash in quoted text to the character it stands for.
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\'': '\'',
+	'\\': '\\',
+	'{':  '{',
+	'}':  '}',
+}
+
+// bytesPerToken is roughly how much source typical MBL code spends on each
+// token. Lex uses it to size the token slice up front instead of regrowing it.
+const bytesPerToken = 3
+
 // Token represents a token in the source code.
 type Token struct {
-	Type  TokenType
-	Value string
+	Type   TokenType
+	Value  string
+	Line   int
+	Column int
+
+	// Raw is the token's exact source text when the lexer's PreserveWhitespace
+	// option is set and that text differs from Value, as it does for quoted
+	// text, comments and dates; otherwise it is empty. See Lexeme.
+	Raw string
+}
+
+// NewToken creates a Token positioned at the given line and column.
+func NewToken(tokenType TokenType, value string, line, column int) Token {
+	return Token{Type: tokenType, Value: value, Line: line, Column: column}
+}
+
+// Lexeme returns the source text the token was lexed from when it is known,
+// that is Raw when set and Value otherwise. With PreserveWhitespace, joining
+// the lexemes of every token rebuilds the input exactly, unless StripComments
+// drops some of it.
+func (t Token) Lexeme() string {
+	if t.Raw != "" {
+		return t.Raw
+	}
+	return t.Value
+}
+
+// String renders the token as Type("value")@line:column, for example
+// Numeric("42")@3:10. It is meant for people reading logs and test
+// failures, not for parsing back into a Token.
+func (t Token) String() string {
+	return fmt.Sprintf("%s(%q)@%d:%d", t.Type, t.Value, t.Line, t.Column)
 }
 
 // Lexer is responsible for tokenizing the source code.
 type Lexer struct {
-	input  string
-	tokens []Token
-	pos    int
+	input    string
+	tokens   []Token
+	pos      int
+	line     int
+	column   int
+	keywords map[string]bool
+	pending  Token
+	ready    bool
+	counts   [EOF]int // Tokens handed out per type; EOF is the last type and is not counted
+
+	// TabWidth is the number of columns a tab character advances.
+	TabWidth int
+
+	// StripComments drops comment tokens instead of emitting them.
+	StripComments bool
+
+	// CaseInsensitiveLiterals accepts true, false and null in any letter case.
+	CaseInsensitiveLiterals bool
+
+	// CurrencyLiterals lexes amounts such as $1,234.56 as Currency tokens.
+	CurrencyLiterals bool
+
+	// PreserveWhitespace emits runs of spaces and other insignificant
+	// whitespace as Whitespace tokens instead of discarding them, and records
+	// each token's source text in Raw where it differs from Value.
+	PreserveWhitespace bool
+
+	// TextQuotes lists the characters that open and close Text tokens, with
+	// the same escapes whichever quote is used. It defaults to a double quote;
+	// add a single quote to accept 'text' as well. A single quote still opens
+	// a Char when exactly one character or escape sequence and a closing quote
+	// follow it, so 'A' stays a Char while 'AB' and '' become Text.
+	TextQuotes string
 }
 
 // NewLexer creates a new Lexer instance.
 func NewLexer(input string) *Lexer {
-	return &Lexer{
-		input:  input,
-		tokens: make([]Token, 0),
-		pos:    0,
+	l := &Lexer{
+		input:      input,
+		pos:        0,
+		line:       1,
+		column:     1,
+		keywords:   make(map[string]bool),
+		TabWidth:   1,
+		TextQuotes: `"`,
+	}
+	l.AddKeywords(DefaultKeywords...)
+	return l
+}
+
+// Tokenize lexes the whole input with a new Lexer using the default options.
+// It is shorthand for NewLexer(input).Lex(); create the Lexer directly to
+// change its options or to read tokens one at a time with NextToken.
+func Tokenize(input string) ([]Token, error) {
+	return NewLexer(input).Lex()
+}
+
+// Reset prepares the Lexer to tokenize new input from the start, keeping its
+// keywords and options and clearing its Stats. The token slice is reused, so
+// tokens returned by an earlier call to Lex must be copied if they are needed
+// after Reset.
+func (l *Lexer) Reset(input string) {
+	l.input = input
+	l.tokens = l.tokens[:0]
+	l.pos = 0
+	l.line = 1
+	l.column = 1
+	l.pending = Token{}
+	l.ready = false
+	l.counts = [EOF]int{}
+}
+
+// AddKeywords registers additional words to be lexed as Keyword tokens.
+func (l *Lexer) AddKeywords(words ...string) {
+	for _, word := range words {
+		l.keywords[word] = true
 	}
 }
 
 // LexTokenizes the source code and returns a slice of tokens.
+// The slice always ends with exactly one EOF token carrying the final line and column;
+// no tokens ever follow it.
 func (l *Lexer) Lex() ([]Token, error) {
-	for l.pos < len(l.input) {
-		r := l.input[l.pos]
-
-		switch {
-		case unicode.IsSpace(r):
-			l.consumeWhitespace()
-		case r == '"':
-			err := l.consumeText()
-			if err != nil {
-				return nil, err
-			}
-		case unicode.IsDigit(r) || (r == '-' && unicode.IsDigit(l.peek())):
-			l.consumeNumeric()
-		case unicode.IsLetter(r):
-			l.consumeAlphanumeric()
-		case r == '\n':
-			l.consumeNewLine()
-		case r == '\t':
-			l.consumeTab()
-		default:
-			l.consumeSymbol()
+	if cap(l.tokens) == 0 {
+		l.tokens = make([]Token, 0, len(l.input)/bytesPerToken+1)
+	}
+
+	for {
+		token, err := l.NextToken()
+		if err != nil {
+			return nil, err
+		}
+
+		l.tokens = append(l.tokens, token)
+		if token.Type == EOF {
+			return l.tokens, nil
+		}
+	}
+}
+
+// NextToken returns the next token in the source code.
+// Once the input is exhausted it returns an EOF token on every call.
+func (l *Lexer) NextToken() (Token, error) {
+	for !l.ready {
+		if l.pos >= len(l.input) {
+			return NewToken(EOF, "", l.line, l.column), nil
+		}
+
+		err := l.scan()
+		if err != nil {
+			return Token{}, err
+		}
+	}
+
+	l.ready = false
+	l.counts[l.pending.Type]++
+	return l.pending, nil
+}
+
+// Helper function to consume the next lexeme, which may or may not produce a token.
+func (l *Lexer) scan() error {
+	start := l.pos
+	err := l.scanLexeme()
+	if err == nil && l.ready && l.PreserveWhitespace {
+		if raw := l.input[start:l.pos]; raw != l.pending.Value {
+			l.pending.Raw = raw
+		}
+	}
+	return err
+}
+
+// Helper function to consume the next lexeme for scan, dispatching on its
+// first character.
+func (l *Lexer) scanLexeme() error {
+	r := l.current()
+
+	switch {
+	case isLineBreak(r):
+		l.consumeNewLine()
+	case r == '\t':
+		l.consumeTab()
+	case r == '\\' && isLineBreak(l.peek()):
+		l.consumeContinuation()
+	case unicode.IsSpace(r):
+		l.consumeWhitespace()
+	case r == '#' && l.isDateStart():
+		return l.consumeDate()
+	case r == '#':
+		l.consumeComment()
+	case r == '/' && l.peek() == '*':
+		return l.consumeBlockComment()
+	case r == '`':
+		return l.consumeRawText()
+	case r == '\'' && (!strings.ContainsRune(l.TextQuotes, r) || l.isCharLiteral()):
+		return l.consumeChar()
+	case strings.ContainsRune(l.TextQuotes, r):
+		return l.consumeText(r)
+	case r == '@' && unicode.IsLetter(l.peek()):
+		l.consumeDirective()
+	case isDigit(r):
+		return l.consumeNumeric()
+	case l.CurrencyLiterals && strings.ContainsRune(currencySymbols, r) && isDigit(l.peek()):
+		return l.consumeCurrency()
+	case unicode.IsLetter(r):
+		l.consumeAlphanumeric()
+	default:
+		l.consumeSymbol()
+	}
+
+	return nil
+}
+
+// Helper function to decode the character at the current position.
+// Positions are tracked in bytes while characters are handled as runes.
+func (l *Lexer) current() rune {
+	r, _ := utf8.DecodeRuneInString(l.input[l.pos:])
+	return r
+}
+
+// Helper function to advance one character, keeping line and column current.
+func (l *Lexer) advance() {
+	r, width := utf8.DecodeRuneInString(l.input[l.pos:])
+	switch r {
+	case '\n':
+		l.line++
+		l.column = 1
+	case '\r':
+		// In a "\r\n" pair the '\n' ends the line; a lone '\r' ends it itself.
+		if l.pos+1 < len(l.input) && l.input[l.pos+1] == '\n' {
+			break
 		}
+		l.line++
+		l.column = 1
+	case '\t':
+		l.column += l.TabWidth
+	default:
+		l.column++
 	}
+	l.pos += width
+}
 
-	return l.tokens, nil
+// Helper function to hand out a token starting at the given line and column.
+func (l *Lexer) emit(tokenType TokenType, value string, line, column int) {
+	l.pending = NewToken(tokenType, value, line, column)
+	l.ready = true
 }
 
 // Helper function to consume consecutive whitespace characters.
+// New lines and tabs are significant and left for their own tokens.
 func (l *Lexer) consumeWhitespace() {
-	for l.pos < len(l.input) && unicode.IsSpace(l.input[l.pos]) {
-		l.pos++
+	line, column := l.line, l.column
+	start := l.pos
+
+	for l.pos < len(l.input) && isInsignificantSpace(l.current()) {
+		l.advance()
+	}
+
+	if l.PreserveWhitespace {
+		l.emit(Whitespace, l.input[start:l.pos], line, column)
 	}
 }
 
-// Helper function to consume text within quotes.
-func (l *Lexer) consumeText() error {
-	l.pos++ // Skip the opening quote
+// Helper function to report whether a character is whitespace that carries no meaning.
+func isInsignificantSpace(r rune) bool {
+	return unicode.IsSpace(r) && !isLineBreak(r) && r != '\t'
+}
+
+// Helper function to report whether a character ends a line. Besides '\n',
+// a '\r' counts, whether alone as on old Macs or as the start of "\r\n".
+func isLineBreak(r rune) bool {
+	return r == '\n' || r == '\r'
+}
+
+// Helper function to consume a comment running from '#' to the end of the line.
+func (l *Lexer) consumeComment() {
+	line, column := l.line, l.column
+	l.advance() // Skip the '#'
 
 	start := l.pos
-	for l.pos < len(l.input) && l.input[l.pos] != '"' {
-		l.pos++
+	for l.pos < len(l.input) && !isLineBreak(rune(l.input[l.pos])) {
+		l.advance()
 	}
 
-	if l.pos == len(l.input) {
-		return fmt.Errorf("unclosed quote")
+	if !l.StripComments {
+		l.emit(Comment, l.input[start:l.pos], line, column)
+	}
+}
+
+// Helper function to report whether the '#' at the current position opens a
+// date literal rather than a comment. It must be followed by a four-digit year
+// and two more runs of digits, joined by '-', and then a closing '#' on the
+// same line, so "#2024-1-5#" is a date gone wrong. Without the closing '#',
+// only a whole YYYY-MM-DD with nothing after it on the line is taken as a date
+// missing its end. Anything else, such as "#3-4" or "#2024-12-25 release day",
+// is a comment.
+func (l *Lexer) isDateStart() bool {
+	rest := l.input[l.pos+1:]
+
+	end := 0
+	for end < len(rest) && (isDigit(rune(rest[end])) || rest[end] == '-') {
+		end++
+	}
+
+	parts := strings.Split(rest[:end], "-")
+	if len(parts) != 3 || len(parts[0]) != 4 || parts[1] == "" || parts[2] == "" {
+		return false
+	}
+	if end < len(rest) && rest[end] == '#' {
+		return true
+	}
+	lineEnds := end == len(rest) || isLineBreak(rune(rest[end]))
+	return lineEnds && len(parts[1]) == 2 && len(parts[2]) == 2
+}
+
+// Helper function to consume a date literal such as #2024-01-31#.
+// The date must have the YYYY-MM-DD shape and name a real day.
+func (l *Lexer) consumeDate() error {
+	line, column, offset := l.line, l.column, l.pos
+	l.advance() // Skip the opening '#'
+
+	start := l.pos
+	for l.pos < len(l.input) && l.input[l.pos] != '#' && !isLineBreak(rune(l.input[l.pos])) {
+		l.advance()
+	}
+
+	if l.pos == len(l.input) || l.input[l.pos] != '#' {
+		return l.errorAt(line, column, offset, "unclosed date literal")
 	}
 
 	text := l.input[start:l.pos]
-	l.tokens = append(l.tokens, Token{Type: Text, Value: text})
+	date, err := time.Parse("2006-01-02", text)
+	if err != nil || len(text) != len("2006-01-02") {
+		return l.errorAt(line, column, offset, "invalid date %q", text)
+	}
+
+	l.emit(Date, date.Format("2006-01-02"), line, column)
 
-	l.pos++ // Skip the closing quote
+	l.advance() // Skip the closing '#'
+	return nil
+}
+
+// Helper function to consume a comment between '/*' and '*/'.
+// Block comments do not nest: "/* /* */" ends at the first "*/".
+func (l *Lexer) consumeBlockComment() error {
+	line, column, offset := l.line, l.column, l.pos
+	l.advance() // Skip the '/'
+	l.advance() // Skip the '*'
+
+	start := l.pos
+	for l.pos < len(l.input) && !strings.HasPrefix(l.input[l.pos:], "*/") {
+		l.advance()
+	}
+
+	if l.pos == len(l.input) {
+		return l.errorAt(line, column, offset, "unclosed block comment")
+	}
+
+	if !l.StripComments {
+		l.emit(Comment, l.input[start:l.pos], line, column)
+	}
+
+	l.advance() // Skip the '*'
+	l.advance() // Skip the '/'
+	return nil
+}
+
+// Helper function to consume text within the given quotes.
+// Text holding an unescaped brace is an Interpolation token instead, whose
+// value is the text as written, escapes and all; see Token.Segments.
+func (l *Lexer) consumeText(quote rune) error {
+	line, column, offset := l.line, l.column, l.pos
+	l.advance() // Skip the opening quote
+
+	// Text without escapes is sliced straight from the input; the builder is
+	// only used from the first backslash on.
+	start := l.pos
+	escaped, interpolated := false, false
+	var text strings.Builder
+	for l.pos < len(l.input) && l.current() != quote {
+		if l.input[l.pos] != '\\' {
+			if l.input[l.pos] == '{' || l.input[l.pos] == '}' {
+				interpolated = true
+			}
+			if escaped {
+				text.WriteRune(l.current())
+			}
+			l.advance()
+			continue
+		}
+
+		if !escaped {
+			text.WriteString(l.input[start:l.pos])
+			escaped = true
+		}
+
+		escapeLine, escapeColumn, escapeOffset := l.line, l.column, l.pos
+		l.advance() // Skip the backslash
+		if l.pos == len(l.input) {
+			break
+		}
+
+		decoded, ok := escapes[l.input[l.pos]]
+		if !ok {
+			return l.errorAt(escapeLine, escapeColumn, escapeOffset, "unknown escape sequence \\%c", l.current())
+		}
+		text.WriteByte(decoded)
+		l.advance()
+	}
+
+	if l.pos == len(l.input) {
+		return l.errorAt(line, column, offset, "unclosed quote")
+	}
+
+	tokenType, value := Text, l.input[start:l.pos]
+	switch {
+	case interpolated:
+		tokenType = Interpolation
+		if _, err := NewToken(tokenType, value, line, column).Segments(); err != nil {
+			return err
+		}
+	case escaped:
+		value = text.String()
+	}
+	l.emit(tokenType, value, line, column)
+
+	l.advance() // Skip the closing quote
+	return nil
+}
+
+// Helper function to consume raw text between backticks.
+// No escapes are processed and new lines are kept as written.
+func (l *Lexer) consumeRawText() error {
+	line, column, offset := l.line, l.column, l.pos
+	l.advance() // Skip the opening backtick
+
+	start := l.pos
+	for l.pos < len(l.input) && l.input[l.pos] != '`' {
+		l.advance()
+	}
+
+	if l.pos == len(l.input) {
+		return l.errorAt(line, column, offset, "unclosed raw string")
+	}
+
+	l.emit(Text, l.input[start:l.pos], line, column)
+
+	l.advance() // Skip the closing backtick
+	return nil
+}
+
+// Helper function to report whether the single quote at the current position
+// opens a character literal: one character or one escape sequence followed by
+// the closing quote.
+func (l *Lexer) isCharLiteral() bool {
+	rest := l.input[l.pos+1:]
+	if strings.HasPrefix(rest, "\\") && len(rest) > 1 {
+		rest = rest[2:]
+	} else {
+		r, width := utf8.DecodeRuneInString(rest)
+		if width == 0 || r == '\'' {
+			return false
+		}
+		rest = rest[width:]
+	}
+	return strings.HasPrefix(rest, "'")
+}
+
+// Helper function to consume a character literal such as 'A' or '\n'.
+// Exactly one character or one escape sequence must appear between the quotes.
+func (l *Lexer) consumeChar() error {
+	line, column, offset := l.line, l.column, l.pos
+	l.advance() // Skip the opening quote
+
+	if l.pos == len(l.input) || isLineBreak(rune(l.input[l.pos])) {
+		return l.errorAt(line, column, offset, "unclosed character literal")
+	}
+	if l.input[l.pos] == '\'' {
+		return l.errorAt(line, column, offset, "empty character literal")
+	}
+
+	value := string(l.current())
+	if l.input[l.pos] == '\\' {
+		escapeLine, escapeColumn, escapeOffset := l.line, l.column, l.pos
+		l.advance() // Skip the backslash
+		if l.pos == len(l.input) {
+			return l.errorAt(line, column, offset, "unclosed character literal")
+		}
+
+		decoded, ok := escapes[l.input[l.pos]]
+		if !ok {
+			return l.errorAt(escapeLine, escapeColumn, escapeOffset, "unknown escape sequence \\%c", l.current())
+		}
+		value = string(decoded)
+	}
+	l.advance()
+
+	if l.pos == len(l.input) || isLineBreak(rune(l.input[l.pos])) {
+		return l.errorAt(line, column, offset, "unclosed character literal")
+	}
+	if l.input[l.pos] != '\'' {
+		return l.errorAt(line, column, offset, "character literal holds more than one character")
+	}
+
+	l.emit(Char, value, line, column)
+
+	l.advance() // Skip the closing quote
 	return nil
 }
 
 // Helper function to consume numeric literals.
-func (l *Lexer) consumeNumeric() {
+// A decimal literal may end in one of the scale suffixes k, M or %, which stays
+// part of the token's value, as in "1k" or "50%". The suffix ends the literal,
+// so "1kg" is the Numeric "1k" followed by the Alphanumeric "g".
+func (l *Lexer) consumeNumeric() error {
+	line, column := l.line, l.column
 	start := l.pos
 
-	for l.pos < len(l.input) && (unicode.IsDigit(l.input[l.pos]) || l.input[l.pos] == '_' || l.input[l.pos] == '.') {
-		l.pos++
+	if strings.HasPrefix(l.input[l.pos:], "0x") || strings.HasPrefix(l.input[l.pos:], "0X") {
+		l.advance() // Skip the '0'
+		l.advance() // Skip the 'x'
+
+		digits := l.pos
+		for l.pos < len(l.input) && isHexDigit(l.input[l.pos]) {
+			l.advance()
+		}
+
+		if l.pos == digits {
+			return l.errorAt(line, column, start, "hexadecimal literal without digits")
+		}
+
+		l.emit(Numeric, l.input[start:l.pos], line, column)
+		return nil
+	}
+
+	seenDot := false
+	for l.pos < len(l.input) && (isDigit(l.current()) || l.input[l.pos] == '_' || l.input[l.pos] == '.') {
+		if l.input[l.pos] == '.' {
+			if seenDot {
+				return l.errorAt(l.line, l.column, l.pos, "numeric literal has a second decimal point")
+			}
+			seenDot = true
+		}
+		l.advance()
+	}
+
+	if l.input[l.pos-1] == '_' {
+		return l.errorAt(line, column, start, "numeric literal ends with an underscore")
+	}
+
+	if l.pos < len(l.input) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+		l.advance() // Skip the 'e'
+		if l.pos < len(l.input) && (l.input[l.pos] == '+' || l.input[l.pos] == '-') {
+			l.advance()
+		}
+
+		digits := l.pos
+		for l.pos < len(l.input) && isDigit(l.current()) {
+			l.advance()
+		}
+
+		if l.pos == digits {
+			return l.errorAt(line, column, start, "exponent without digits")
+		}
+	}
+
+	if l.isNumericSuffix() {
+		l.advance()
 	}
 
 	numeric := l.input[start:l.pos]
-	l.tokens = append(l.tokens, Token{Type: Numeric, Value: numeric})
+	l.emit(Numeric, numeric, line, column)
+	return nil
 }
 
-// Helper function to consume alphanumeric tokens.
+// Helper function to report whether the current character is a scale suffix
+// ending a numeric literal. A '%' is a suffix only where an operand could end:
+// before a space, a closing bracket, ',', ';', a line break or the end of
+// input. Anywhere else it is the modulo operator, so "10%3" means 10 % 3 and
+// "10%-3" means 10 % -3.
+func (l *Lexer) isNumericSuffix() bool {
+	switch l.current() {
+	case 'k', 'M':
+		return true
+	case '%':
+		next := l.peek()
+		return next == 0 || unicode.IsSpace(next) || strings.ContainsRune(")]},;", next)
+	}
+	return false
+}
+
+// currencySymbols lists the characters that may open a Currency literal.
+const currencySymbols = "$€£"
+
+// Helper function to consume a currency amount such as $1,234.56.
+// The token value keeps the symbol and drops the group separators, so
+// $1,234.56 becomes "$1234.56". When commas are used, the first group holds
+// one to three digits and every later group exactly three.
+func (l *Lexer) consumeCurrency() error {
+	line, column, offset := l.line, l.column, l.pos
+	symbol := l.current()
+	l.advance() // Skip the currency symbol
+
+	var amount strings.Builder
+	amount.WriteRune(symbol)
+
+	group := 0
+	grouped := false
+	for l.pos < len(l.input) {
+		if isDigit(l.current()) {
+			amount.WriteByte(l.input[l.pos])
+			group++
+			l.advance()
+			continue
+		}
+
+		if l.input[l.pos] != ',' || !isDigit(l.peek()) {
+			break
+		}
+		if group > 3 || (grouped && group != 3) {
+			return l.errorAt(line, column, offset, "malformed digit grouping in currency literal")
+		}
+		grouped = true
+		group = 0
+		l.advance() // Skip the ','
+	}
+
+	if grouped && group != 3 {
+		return l.errorAt(line, column, offset, "malformed digit grouping in currency literal")
+	}
+
+	if l.pos < len(l.input) && l.input[l.pos] == '.' && isDigit(l.peek()) {
+		amount.WriteByte('.')
+		l.advance() // Skip the '.'
+		for l.pos < len(l.input) && isDigit(l.current()) {
+			amount.WriteByte(l.input[l.pos])
+			l.advance()
+		}
+	}
+
+	l.emit(Currency, amount.String(), line, column)
+	return nil
+}
+
+// Helper function to report whether a character is an ASCII decimal digit.
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// Helper function to report whether a rune may continue an identifier.
+func isIdentifierPart(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+// Helper function to report whether a byte is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// Helper function to consume alphanumeric tokens. An identifier starts with a
+// Unicode letter and continues with letters, digits, underscores and
+// combining marks, so both "número" and its decomposed form stay one token.
+// Snake case names such as unit_price are single identifiers, but a leading
+// underscore is not part of one: _total lexes as the symbol "_" followed by
+// total, which keeps a bare "_" free to mean something of its own.
 func (l *Lexer) consumeAlphanumeric() {
+	line, column := l.line, l.column
 	start := l.pos
 
-	for l.pos < len(l.input) && (unicode.IsLetter(l.input[l.pos]) || unicode.IsDigit(l.input[l.pos])) {
-		l.pos++
+	for l.pos < len(l.input) && isIdentifierPart(l.current()) {
+		l.advance()
 	}
 
 	alphanumeric := l.input[start:l.pos]
-	l.tokens = append(l.tokens, Token{Type: Alphanumeric, Value: alphanumeric})
+
+	literal := alphanumeric
+	if l.CaseInsensitiveLiterals {
+		literal = strings.ToLower(literal)
+	}
+	switch literal {
+	case "true", "false":
+		l.emit(Boolean, literal, line, column)
+		return
+	case "null":
+		l.emit(Null, literal, line, column)
+		return
+	}
+
+	if l.keywords[alphanumeric] {
+		l.emit(Keyword, alphanumeric, line, column)
+		return
+	}
+	l.emit(Alphanumeric, alphanumeric, line, column)
+}
+
+// Helper function to consume a directive such as @strict, emitting the name
+// after the '@'. Any name is accepted here; which ones mean something is left
+// to later stages.
+func (l *Lexer) consumeDirective() {
+	line, column := l.line, l.column
+	l.advance() // Skip the '@'
+
+	start := l.pos
+	for l.pos < len(l.input) && isIdentifierPart(l.current()) {
+		l.advance()
+	}
+
+	l.emit(Directive, l.input[start:l.pos], line, column)
 }
 
-// Helper function to consume consecutive new line characters.
+// Helper function to consume consecutive line breaks.
+// Each "\r\n" pair or lone '\r' is normalized to a single '\n' in the token,
+// so the value holds one '\n' per line ended. With PreserveWhitespace the
+// original characters are kept, so the tokens still rebuild the input.
 func (l *Lexer) consumeNewLine() {
-	count := 0
+	line, column := l.line, l.column
+	start := l.pos
+
+	for l.pos < len(l.input) && isLineBreak(rune(l.input[l.pos])) {
+		l.advance()
+	}
 
-	for l.pos < len(l.input) && l.input[l.pos] == '\n' {
-		l.pos++
-		count++
+	value := l.input[start:l.pos]
+	if !l.PreserveWhitespace {
+		value = normalizeLineBreaks(value)
 	}
+	l.emit(NewLine, value, line, column)
+}
 
-	l.tokens = append(l.tokens, Token{Type: NewLine, Value: strings.Repeat("\n", count)})
+// Helper function to consume a '\\' that ends a line, joining the next line
+// to this one. The line break and the spaces and tabs that indent the next
+// line produce no tokens, so the statement simply carries on; with
+// PreserveWhitespace they are kept as one Whitespace token instead.
+func (l *Lexer) consumeContinuation() {
+	line, column := l.line, l.column
+	start := l.pos
+
+	l.advance() // Skip the '\\'
+	if l.input[l.pos] == '\r' && l.peek() == '\n' {
+		l.advance()
+	}
+	l.advance() // Skip the line break
+
+	for l.pos < len(l.input) && (l.input[l.pos] == '\t' || isInsignificantSpace(l.current())) {
+		l.advance()
+	}
+
+	if l.PreserveWhitespace {
+		l.emit(Whitespace, l.input[start:l.pos], line, column)
+	}
+}
+
+// Helper function to replace each "\r\n" pair and lone '\r' with '\n'.
+func normalizeLineBreaks(s string) string {
+	if strings.IndexByte(s, '\r') < 0 {
+		return s
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
 }
 
 // Helper function to consume consecutive tab characters.
 func (l *Lexer) consumeTab() {
-	count := 0
+	line, column := l.line, l.column
+	start := l.pos
 
 	for l.pos < len(l.input) && l.input[l.pos] == '\t' {
-		l.pos++
-		count++
+		l.advance()
 	}
 
-	l.tokens = append(l.tokens, Token{Type: Tab, Value: strings.Repeat("\t", count)})
+	l.emit(Tab, l.input[start:l.pos], line, column)
 }
 
+// operators lists the multi-character symbols recognized as a single token, longest first.
+var operators = []string{"==", "!=", "<=", ">=", ":=", "->", "&&", "||", "//"}
+
 // Helper function to consume symbol tokens.
+// Multi-character operators are matched greedily before falling back to a single character.
+// An operator only matches when all of it is in the remaining input, so a
+// trailing '=' or ':' at the end of the input is a symbol of its own.
 func (l *Lexer) consumeSymbol() {
-	symbol := string(l.input[l.pos])
-	l.tokens = append(l.tokens, Token{Type: Symbol, Value: symbol})
-	l.pos++
+	line, column := l.line, l.column
+
+	for _, operator := range operators {
+		if strings.HasPrefix(l.input[l.pos:], operator) {
+			for range operator {
+				l.advance()
+			}
+			l.emit(Symbol, operator, line, column)
+			return
+		}
+	}
+
+	start := l.pos
+	l.advance()
+	l.emit(Symbol, l.input[start:l.pos], line, column)
 }
 
 // Helper function to peek at the next character without consuming it.
 func (l *Lexer) peek() rune {
-	if l.pos+1 < len(l.input) {
-		return rune(l.input[l.pos+1])
+	if l.pos >= len(l.input) {
+		return 0
+	}
+
+	_, width := utf8.DecodeRuneInString(l.input[l.pos:])
+	if l.pos+width < len(l.input) {
+		r, _ := utf8.DecodeRuneInString(l.input[l.pos+width:])
+		return r
 	}
 	return 0
 }
-
@@ -0,0 +1,129 @@
+// lexer/operator.go
+
+package lexer
+
+import "fmt"
+
+// Operator classifies the symbol held by a Symbol token, so code reading the
+// tokens can switch on its kind rather than compare strings.
+type Operator int
+
+const (
+	// NotOperator is the kind of every token that is not a Symbol.
+	NotOperator Operator = iota
+	// OtherSymbol is the kind of a symbol with no meaning of its own, such as '@' or '$'.
+	OtherSymbol
+	Plus
+	Minus
+	Star
+	Slash
+	DoubleSlash
+	Percent
+	Equal
+	NotEqual
+	Less
+	LessEqual
+	Greater
+	GreaterEqual
+	Assign
+	Arrow
+	And
+	Or
+	Not
+	Question
+	Colon
+	Comma
+	Dot
+	Semicolon
+	LeftParen
+	RightParen
+	LeftBracket
+	RightBracket
+	LeftBrace
+	RightBrace
+)
+
+// operatorKinds maps each recognized symbol to its kind.
+var operatorKinds = map[string]Operator{
+	"+":  Plus,
+	"-":  Minus,
+	"*":  Star,
+	"/":  Slash,
+	"//": DoubleSlash,
+	"%":  Percent,
+	"==": Equal,
+	"!=": NotEqual,
+	"<":  Less,
+	"<=": LessEqual,
+	">":  Greater,
+	">=": GreaterEqual,
+	":=": Assign,
+	"->": Arrow,
+	"&&": And,
+	"||": Or,
+	"!":  Not,
+	"?":  Question,
+	":":  Colon,
+	",":  Comma,
+	".":  Dot,
+	";":  Semicolon,
+	"(":  LeftParen,
+	")":  RightParen,
+	"[":  LeftBracket,
+	"]":  RightBracket,
+	"{":  LeftBrace,
+	"}":  RightBrace,
+}
+
+var operatorNames = map[Operator]string{
+	NotOperator:  "NotOperator",
+	OtherSymbol:  "OtherSymbol",
+	Plus:         "Plus",
+	Minus:        "Minus",
+	Star:         "Star",
+	Slash:        "Slash",
+	DoubleSlash:  "DoubleSlash",
+	Percent:      "Percent",
+	Equal:        "Equal",
+	NotEqual:     "NotEqual",
+	Less:         "Less",
+	LessEqual:    "LessEqual",
+	Greater:      "Greater",
+	GreaterEqual: "GreaterEqual",
+	Assign:       "Assign",
+	Arrow:        "Arrow",
+	And:          "And",
+	Or:           "Or",
+	Not:          "Not",
+	Question:     "Question",
+	Colon:        "Colon",
+	Comma:        "Comma",
+	Dot:          "Dot",
+	Semicolon:    "Semicolon",
+	LeftParen:    "LeftParen",
+	RightParen:   "RightParen",
+	LeftBracket:  "LeftBracket",
+	RightBracket: "RightBracket",
+	LeftBrace:    "LeftBrace",
+	RightBrace:   "RightBrace",
+}
+
+// String returns the name of the operator kind.
+func (o Operator) String() string {
+	if name, ok := operatorNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("Operator(%d)", int(o))
+}
+
+// Operator returns the kind of symbol a Symbol token holds: OtherSymbol for a
+// symbol that is not recognized, and NotOperator for any other type of token.
+func (t Token) Operator() Operator {
+	if t.Type != Symbol {
+		return NotOperator
+	}
+	if kind, ok := operatorKinds[t.Value]; ok {
+		return kind
+	}
+	return OtherSymbol
+}
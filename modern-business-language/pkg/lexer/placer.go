@@ -1,34 +0,0 @@
-// placer/placer.go
-
-package placer
-
-import (
-	"fmt"
-	"strings"
-)
-
-// Placer is responsible for placing tokens in a hierarchical data structure.
-type Placer struct {
-	// Add any necessary fields for maintaining the hierarchical structure.
-}
-
-// NewPlacer creates a new Placer instance.
-func NewPlacer() *Placer {
-	return &Placer{}
-}
-
-// PlaceTokens places tokens in the hierarchical data structure.
-func (p *Placer) PlaceTokens(tokens []Token) error {
-	// Implement the logic to place tokens in the hierarchical structure.
-	// You may need to iterate through the tokens and use the hierarchy information to determine the placement.
-
-	for _, token := range tokens {
-		// Extract information from the token and determine its placement in the hierarchy.
-		// Update the hierarchical data structure accordingly.
-	}
-
-	return nil
-}
-
-// Add any additional helper functions or methods as needed for placing tokens in the hierarchy.
-
@@ -0,0 +1,35 @@
+// lexer/stats.go
+
+package lexer
+
+// Stats summarizes the input a Lexer has processed since it was created or
+// last Reset, for profiling large scripts.
+type Stats struct {
+	// Counts holds the number of tokens of each type handed out, leaving out
+	// the EOF. Types that never occurred are absent.
+	Counts map[TokenType]int
+
+	// Tokens is the total of Counts.
+	Tokens int
+
+	// Bytes is the number of bytes of input consumed, and Lines the number of
+	// lines they span; a final line break does not start another line.
+	Bytes int
+	Lines int
+}
+
+// Stats returns the counts gathered while lexing. They are kept up to date as
+// each token is handed out, so calling Stats costs no pass over the input.
+func (l *Lexer) Stats() Stats {
+	stats := Stats{Counts: make(map[TokenType]int), Bytes: l.pos, Lines: l.line}
+	for tokenType, count := range l.counts {
+		if count > 0 {
+			stats.Counts[TokenType(tokenType)] = count
+			stats.Tokens += count
+		}
+	}
+	if l.column == 1 {
+		stats.Lines--
+	}
+	return stats
+}
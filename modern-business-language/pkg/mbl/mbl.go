@@ -0,0 +1,7 @@
+// mbl/mbl.go
+
+// Package mbl is the home of the Modern Business Language. The language is
+// implemented in three stages that share the lexer's Token type: package
+// lexer turns source code into tokens, package placer arranges the tokens
+// into a tree of nodes, and package runner executes that tree.
+package mbl
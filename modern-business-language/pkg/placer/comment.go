@@ -0,0 +1,90 @@
+// placer/comment.go
+
+package placer
+
+import "github.com/Solifugus/mbl/pkg/lexer"
+
+// Helper function to attach the comments kept by the lexer to the statements
+// held by a Root or Block node and by every block below it. Comments alone on
+// the lines just above a statement, with no blank line between, become its
+// LeadingComments; comments after the last of its code, on the same line,
+// become its TrailingComments.
+func associateComments(block *Node) {
+	var pending []lexer.Token
+	for _, statement := range block.Children {
+		for _, child := range statement.Children {
+			if child.Type == Block {
+				associateComments(child)
+			}
+		}
+
+		if comments := commentLine(statement); comments != nil {
+			if len(pending) > 0 && commentEnd(pending[len(pending)-1])+1 < comments[0].Line {
+				pending = nil
+			}
+			pending = append(pending, comments...)
+			continue
+		}
+		if statement.Type != Statement {
+			pending = nil
+			continue
+		}
+
+		if len(pending) > 0 && commentEnd(pending[len(pending)-1])+1 == statement.Start.Line {
+			statement.LeadingComments = pending
+		}
+		pending = nil
+		statement.TrailingComments = trailingComments(statement)
+	}
+}
+
+// Helper function to return the comments of a statement that holds nothing
+// but comments, or nil for any other node.
+func commentLine(statement *Node) []lexer.Token {
+	if statement.Type != Statement {
+		return nil
+	}
+
+	var comments []lexer.Token
+	for _, child := range statement.Children {
+		if !isCommentNode(child) {
+			return nil
+		}
+		comments = append(comments, child.Token)
+	}
+	return comments
+}
+
+// Helper function to return the comments ending a statement on the same line
+// as the last of its code, in source order.
+func trailingComments(statement *Node) []lexer.Token {
+	start := len(statement.Children)
+	for start > 0 && isCommentNode(statement.Children[start-1]) {
+		start--
+	}
+	if start == 0 || start == len(statement.Children) {
+		return nil
+	}
+
+	line := statement.Children[start-1].End.Line
+	var comments []lexer.Token
+	for _, child := range statement.Children[start:] {
+		if child.Token.Line != line {
+			break
+		}
+		comments = append(comments, child.Token)
+		line = commentEnd(child.Token)
+	}
+	return comments
+}
+
+// Helper function to return the line a comment token ends on, which is later
+// than the one it starts on for a block comment spanning several lines.
+func commentEnd(comment lexer.Token) int {
+	return comment.Line + lineBreaks(comment.Value)
+}
+
+// Helper function to report whether a node is a leaf holding a comment.
+func isCommentNode(node *Node) bool {
+	return node.Type == Leaf && node.Token.Type == lexer.Comment
+}
@@ -0,0 +1,65 @@
+// placer/directive.go
+
+package placer
+
+import "github.com/Solifugus/mbl/pkg/lexer"
+
+// DefaultDirectives lists the directive names a new Placer accepts.
+var DefaultDirectives = []string{"strict", "version"}
+
+// Directive is a compiler directive such as "@version 2", written on a line of
+// its own outside any block. Directives are validated and collected by the
+// Placer rather than placed in the tree, so they never reach execution.
+type Directive struct {
+	// Name is the name after the '@', and Token the Directive token holding it.
+	Name  string
+	Token lexer.Token
+
+	// Args holds the tokens after the name on its line, without tabs,
+	// whitespace or comments.
+	Args []lexer.Token
+}
+
+// AddDirectives registers additional directive names the Placer accepts.
+func (p *Placer) AddDirectives(names ...string) {
+	for _, name := range names {
+		p.knownDirectives[name] = true
+	}
+}
+
+// Directives returns the directives found by the last call to PlaceTokens, in
+// the order they were written.
+func (p *Placer) Directives() []Directive {
+	return append([]Directive(nil), p.directives...)
+}
+
+// Helper function to check and record the directive whose line is held by
+// tokens, which start at the Directive token and stop before the line's end.
+func (p *Placer) placeDirective(tokens []lexer.Token) error {
+	token := tokens[0]
+
+	// In IndentMode a line at the left margin closes the blocks above it.
+	if p.Mode != BraceMode && p.lineStart {
+		if err := p.indent(0, noIndent, token); err != nil {
+			return err
+		}
+	}
+
+	if len(p.blocks) > 1 || len(p.delimiters) > 0 || p.blocks[0].statement != nil {
+		return errorAt(token, "directive @%s must start a line outside any block", token.Value)
+	}
+	if !p.knownDirectives[token.Value] {
+		return errorAt(token, "unknown directive @%s", token.Value)
+	}
+
+	directive := Directive{Name: token.Value, Token: token}
+	for _, arg := range tokens[1:] {
+		switch arg.Type {
+		case lexer.Tab, lexer.Whitespace, lexer.Comment:
+			continue
+		}
+		directive.Args = append(directive.Args, arg)
+	}
+	p.directives = append(p.directives, directive)
+	return nil
+}
@@ -0,0 +1,30 @@
+// placer/error.go
+
+package placer
+
+import (
+	"fmt"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+)
+
+// PlaceError describes a structural problem found while placing tokens.
+type PlaceError struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+// Error renders the error with its line and column.
+func (e *PlaceError) Error() string {
+	return fmt.Sprintf("place error at line %d col %d: %s", e.Line, e.Column, e.Message)
+}
+
+// Helper function to build a PlaceError positioned at the given token.
+func errorAt(token lexer.Token, format string, args ...interface{}) error {
+	return &PlaceError{
+		Message: fmt.Sprintf(format, args...),
+		Line:    token.Line,
+		Column:  token.Column,
+	}
+}
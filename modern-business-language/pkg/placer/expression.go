@@ -0,0 +1,466 @@
+// placer/expression.go
+
+package placer
+
+import (
+	"github.com/Solifugus/mbl/pkg/lexer"
+)
+
+// binaryPrecedence gives the binding strength of each binary operator; higher binds tighter.
+var binaryPrecedence = map[lexer.Operator]int{
+	lexer.Or:           1,
+	lexer.And:          2,
+	lexer.Equal:        3,
+	lexer.NotEqual:     3,
+	lexer.Less:         4,
+	lexer.Greater:      4,
+	lexer.LessEqual:    4,
+	lexer.GreaterEqual: 4,
+	lexer.Plus:         5,
+	lexer.Minus:        5,
+	lexer.Star:         6,
+	lexer.Slash:        6,
+	lexer.DoubleSlash:  6,
+	lexer.Percent:      6,
+}
+
+// ParseExpression builds an expression tree from the nodes of a statement.
+// Binary operators become BinaryExpr nodes whose children are the left and
+// right operands, and a leading '-' or '!' becomes a UnaryExpr. A call such as
+// f(a, b) becomes a CallExpr whose first child is the callee, followed by the
+// arguments. A list literal [a, b] becomes a ListExpr holding its items, and
+// list[i] an IndexExpr holding the list and the index. A Block in expression
+// position is a record literal { key: value, ... } and becomes a RecordExpr
+// whose children alternate between key leaves and values; rec.key becomes a
+// MemberExpr named after the key, holding the record. A conditional
+// cond ? a : b binds more loosely than any binary operator and becomes a
+// ConditionalExpr holding the condition and both branches. A lambda
+// fn(a, b) -> a + b becomes a LambdaExpr holding a Parameters node and the
+// expression it returns, which reaches as far as a conditional would.
+// Parentheses otherwise group without producing a node of their own.
+// The nodes themselves are left as placed: the tree shares them, but their
+// Parent still points into the statement they came from. Expressions nested
+// more than DefaultMaxDepth deep, by brackets or by prefix operators, are an
+// error rather than a risk to the stack.
+func ParseExpression(nodes []*Node) (*Node, error) {
+	ep := &expressionParser{nodes: nodes}
+
+	expression, err := ep.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+
+	if ep.pos < len(ep.nodes) {
+		return nil, errorAt(ep.nodes[ep.pos].Token, "unexpected %q in expression", ep.nodes[ep.pos].Value)
+	}
+	return expression, nil
+}
+
+// expressionParser walks a slice of nodes while building an expression tree.
+type expressionParser struct {
+	nodes []*Node
+	pos   int
+	depth int
+}
+
+// Helper function to enter one more level of nesting at node, failing once
+// expressions nest more than DefaultMaxDepth deep. Each successful call must
+// be paired with a call to leave.
+func (ep *expressionParser) enter(node *Node) error {
+	if ep.depth >= DefaultMaxDepth {
+		return errorAt(node.Token, "expression nested more than %d deep", DefaultMaxDepth)
+	}
+	ep.depth++
+	return nil
+}
+
+// Helper function to leave a level of nesting entered with enter.
+func (ep *expressionParser) leave() {
+	ep.depth--
+}
+
+// Helper function to return the next node without consuming it, or nil at the end.
+func (ep *expressionParser) peek() *Node {
+	if ep.pos < len(ep.nodes) {
+		return ep.nodes[ep.pos]
+	}
+	return nil
+}
+
+// Helper function to report an error at the next node, or after the last one.
+func (ep *expressionParser) errorHere(format string, args ...interface{}) error {
+	var token lexer.Token
+	switch {
+	case ep.pos < len(ep.nodes):
+		token = ep.nodes[ep.pos].Token
+	case len(ep.nodes) > 0:
+		token = ep.nodes[len(ep.nodes)-1].Token
+	}
+	return errorAt(token, format, args...)
+}
+
+// Helper function to parse a conditional expression, or a binary expression
+// when no '?' follows. Conditionals nest to the right, so a ? b : c ? d : e
+// reads as a ? b : (c ? d : e).
+func (ep *expressionParser) parseConditional() (*Node, error) {
+	if next := ep.peek(); next != nil {
+		if err := ep.enter(next); err != nil {
+			return nil, err
+		}
+		defer ep.leave()
+	}
+
+	condition, err := ep.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+
+	question := ep.peek()
+	if question == nil || !isSymbol(question.Token, "?") {
+		return condition, nil
+	}
+	ep.pos++
+
+	then, err := ep.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+
+	colon := ep.peek()
+	if colon == nil || !isSymbol(colon.Token, ":") {
+		return nil, errorAt(question.Token, "missing ':' for '?'")
+	}
+	ep.pos++
+
+	otherwise, err := ep.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+
+	conditional := &Node{Type: ConditionalExpr, Value: "?", Token: question.Token, Start: condition.Start, End: otherwise.End}
+	adopt(conditional, condition)
+	adopt(conditional, then)
+	adopt(conditional, otherwise)
+	return conditional, nil
+}
+
+// Helper function to parse binary operators binding at least as tightly as minPrecedence.
+// Operators of equal precedence associate to the left.
+func (ep *expressionParser) parseBinary(minPrecedence int) (*Node, error) {
+	left, err := ep.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := ep.peek()
+		if operator == nil || operator.Token.Type != lexer.Symbol {
+			return left, nil
+		}
+
+		precedence, ok := binaryPrecedence[operator.Token.Operator()]
+		if !ok || precedence < minPrecedence {
+			return left, nil
+		}
+		ep.pos++
+
+		right, err := ep.parseBinary(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		binary := &Node{Type: BinaryExpr, Value: operator.Value, Token: operator.Token, Start: left.Start, End: right.End}
+		adopt(binary, left)
+		adopt(binary, right)
+		left = binary
+	}
+}
+
+// Helper function to parse a prefix operator followed by its operand.
+func (ep *expressionParser) parseUnary() (*Node, error) {
+	operator := ep.peek()
+	if operator != nil && (isSymbol(operator.Token, "-") || isSymbol(operator.Token, "!")) {
+		ep.pos++
+
+		if err := ep.enter(operator); err != nil {
+			return nil, err
+		}
+		operand, err := ep.parseUnary()
+		ep.leave()
+		if err != nil {
+			return nil, err
+		}
+
+		unary := &Node{Type: UnaryExpr, Value: operator.Value, Token: operator.Token, Start: operator.Token, End: operand.End}
+		adopt(unary, operand)
+		return unary, nil
+	}
+
+	return ep.parsePostfix()
+}
+
+// Helper function to parse an operand followed by any number of call argument
+// lists, indexes and member accesses.
+func (ep *expressionParser) parsePostfix() (*Node, error) {
+	node, err := ep.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		open := ep.peek()
+		switch {
+		case open != nil && isSymbol(open.Token, "("):
+			ep.pos++
+
+			call := &Node{Type: CallExpr, Value: node.Value, Token: open.Token, Start: node.Start}
+			adopt(call, node)
+
+			err := ep.parseItems(call, open, ")")
+			if err != nil {
+				return nil, err
+			}
+			node = call
+		case open != nil && isSymbol(open.Token, "["):
+			ep.pos++
+
+			index, err := ep.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+
+			closing := ep.peek()
+			if closing == nil || !isSymbol(closing.Token, "]") {
+				return nil, errorAt(open.Token, "missing ']' for '['")
+			}
+			ep.pos++
+
+			indexed := &Node{Type: IndexExpr, Value: node.Value, Token: open.Token, Start: node.Start, End: closing.Token}
+			adopt(indexed, node)
+			adopt(indexed, index)
+			node = indexed
+		case open != nil && isSymbol(open.Token, "."):
+			ep.pos++
+
+			key := ep.peek()
+			if key == nil || key.Type != Leaf || key.Token.Type != lexer.Alphanumeric {
+				return nil, errorAt(open.Token, "expected a field name after '.'")
+			}
+			ep.pos++
+
+			member := &Node{Type: MemberExpr, Value: key.Value, Token: key.Token, Start: node.Start, End: key.Token}
+			adopt(member, node)
+			node = member
+		default:
+			return node, nil
+		}
+	}
+}
+
+// Helper function to parse comma separated expressions into parent, up to the
+// closing symbol that matches open.
+func (ep *expressionParser) parseItems(parent *Node, open *Node, closing string) error {
+	if next := ep.peek(); next != nil && isSymbol(next.Token, closing) {
+		ep.pos++
+		parent.End = next.Token
+		return nil
+	}
+
+	for {
+		item, err := ep.parseConditional()
+		if err != nil {
+			return err
+		}
+		adopt(parent, item)
+
+		next := ep.peek()
+		switch {
+		case next != nil && isSymbol(next.Token, ","):
+			ep.pos++
+		case next != nil && isSymbol(next.Token, closing):
+			ep.pos++
+			parent.End = next.Token
+			return nil
+		default:
+			return errorAt(open.Token, "missing '%s' for '%s'", closing, open.Value)
+		}
+	}
+}
+
+// Helper function to parse an operand, a list literal or a parenthesized expression.
+func (ep *expressionParser) parsePrimary() (*Node, error) {
+	node := ep.peek()
+	if node == nil {
+		return nil, ep.errorHere("expected an expression")
+	}
+
+	if node.Type == Block {
+		ep.pos++
+		return parseRecord(node, ep.depth)
+	}
+
+	if isKeywordNode(node, "fn") {
+		ep.pos++
+		return ep.parseLambda(node)
+	}
+
+	if isSymbol(node.Token, "[") {
+		ep.pos++
+
+		list := &Node{Type: ListExpr, Token: node.Token, Start: node.Token}
+		err := ep.parseItems(list, node, "]")
+		if err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+
+	if isSymbol(node.Token, "(") {
+		ep.pos++
+
+		inner, err := ep.parseConditional()
+		if err != nil {
+			return nil, err
+		}
+
+		closing := ep.peek()
+		if closing == nil || !isSymbol(closing.Token, ")") {
+			return nil, errorAt(node.Token, "missing ')' for '('")
+		}
+		ep.pos++
+
+		// Parentheses widen the span of the expression they group, but not of a
+		// lone leaf, which is shared with the statement it was placed in.
+		if inner.Type != Leaf {
+			inner.Start, inner.End = node.Token, closing.Token
+		}
+		return inner, nil
+	}
+
+	if node.Type != Leaf || !isOperand(node.Token) {
+		return nil, ep.errorHere("unexpected %q in expression", node.Value)
+	}
+	ep.pos++
+	return node, nil
+}
+
+// Helper function to parse the parameters and body of a lambda, following the
+// fn keyword.
+func (ep *expressionParser) parseLambda(keyword *Node) (*Node, error) {
+	open := ep.peek()
+	if open == nil || !isSymbol(open.Token, "(") {
+		return nil, errorAt(keyword.Token, "expected '(' after fn")
+	}
+	ep.pos++
+
+	params := &Node{Type: Parameters, Token: open.Token, Start: open.Token}
+	for {
+		next := ep.peek()
+		if next != nil && isSymbol(next.Token, ")") && len(params.Children) == 0 {
+			break
+		}
+		if next == nil || !isNameNode(next) {
+			return nil, ep.errorHere("expected a parameter name in fn")
+		}
+		adopt(params, next)
+		ep.pos++
+
+		if separator := ep.peek(); separator == nil || !isSymbol(separator.Token, ",") {
+			break
+		}
+		ep.pos++
+	}
+
+	closing := ep.peek()
+	if closing == nil || !isSymbol(closing.Token, ")") {
+		return nil, errorAt(open.Token, "missing ')' for '('")
+	}
+	params.End = closing.Token
+	ep.pos++
+
+	arrow := ep.peek()
+	if arrow == nil || !isSymbol(arrow.Token, "->") {
+		return nil, ep.errorHere("expected '->' after the parameters of fn")
+	}
+	ep.pos++
+
+	body, err := ep.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+
+	lambda := &Node{Type: LambdaExpr, Value: keyword.Value, Token: keyword.Token, Start: keyword.Token, End: body.End}
+	adopt(lambda, params)
+	adopt(lambda, body)
+	return lambda, nil
+}
+
+// Helper function to parse a block in expression position as a record literal.
+// Entries are "key: value" pairs separated by commas or new lines, where a key
+// is a name or quoted text. The record sits depth levels deep in the
+// expression holding it.
+func parseRecord(block *Node, depth int) (*Node, error) {
+	record := &Node{Type: RecordExpr, Token: block.Token, Start: block.Start, End: block.End}
+
+	for _, statement := range block.Children {
+		var nodes []*Node
+		for _, child := range statement.Children {
+			if child.Type != Leaf || child.Token.Type != lexer.Comment {
+				nodes = append(nodes, child)
+			}
+		}
+		ep := &expressionParser{nodes: nodes, depth: depth}
+
+		for ep.peek() != nil {
+			key := ep.peek()
+			if key.Type != Leaf || (key.Token.Type != lexer.Alphanumeric && key.Token.Type != lexer.Text) {
+				return nil, ep.errorHere("expected a record key, got %q", key.Value)
+			}
+			ep.pos++
+
+			colon := ep.peek()
+			if colon == nil || !isSymbol(colon.Token, ":") {
+				return nil, ep.errorHere("expected ':' after record key %q", key.Value)
+			}
+			ep.pos++
+
+			value, err := ep.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+			adopt(record, key)
+			adopt(record, value)
+
+			separator := ep.peek()
+			if separator == nil {
+				break
+			}
+			if !isSymbol(separator.Token, ",") {
+				return nil, ep.errorHere("expected ',' between record entries")
+			}
+			ep.pos++
+		}
+	}
+
+	return record, nil
+}
+
+// Helper function to append child to an expression node. Only a node the
+// expression parser built itself is linked back to parent: a node the placer
+// placed keeps the statement it belongs to as its Parent, since the same
+// statement may be parsed again each time it runs.
+func adopt(parent, child *Node) {
+	if child.Parent == nil {
+		child.Parent = parent
+	}
+	parent.Children = append(parent.Children, child)
+}
+
+// Helper function to report whether a token can stand on its own as an operand.
+func isOperand(token lexer.Token) bool {
+	switch token.Type {
+	case lexer.Text, lexer.Interpolation, lexer.Numeric, lexer.Alphanumeric, lexer.Boolean, lexer.Null, lexer.Currency, lexer.Date, lexer.Char:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// placer/function.go
+
+package placer
+
+import "github.com/Solifugus/mbl/pkg/lexer"
+
+// Helper function to replace each statement of the form
+// "function name(a, b) { ... }" held anywhere below node with a FunctionDef
+// node. Its children are the name as a Leaf, a Parameters node holding one
+// Leaf per parameter and the body Block. Comments between the parts are
+// dropped, though the definition keeps the comments attached to the statement.
+// Statements that only resemble a definition are left as they are, so
+// whatever executes them can report what is wrong.
+func defineFunctions(node *Node) {
+	for i, child := range node.Children {
+		defineFunctions(child)
+
+		if definition := functionDefinition(child); definition != nil {
+			definition.Parent = node
+			node.Children[i] = definition
+		}
+	}
+}
+
+// Helper function to build the FunctionDef node for a statement, or return nil
+// when the statement is not a well-formed function definition.
+func functionDefinition(statement *Node) *Node {
+	if statement.Type != Statement {
+		return nil
+	}
+
+	parts := make([]*Node, 0, len(statement.Children))
+	for _, child := range statement.Children {
+		if child.Type != Leaf || child.Token.Type != lexer.Comment {
+			parts = append(parts, child)
+		}
+	}
+
+	if len(parts) < 5 || !isKeywordNode(parts[0], "function") || !isNameNode(parts[1]) {
+		return nil
+	}
+	opening, closing, body := parts[2], parts[len(parts)-2], parts[len(parts)-1]
+	if !isSymbol(opening.Token, "(") || !isSymbol(closing.Token, ")") || body.Type != Block {
+		return nil
+	}
+
+	params := &Node{Type: Parameters, Token: opening.Token, Start: opening.Token, End: closing.Token}
+	names := parts[3 : len(parts)-2]
+	for i, name := range names {
+		if i%2 == 1 {
+			if !isSymbol(name.Token, ",") || i == len(names)-1 {
+				return nil
+			}
+			continue
+		}
+		if !isNameNode(name) {
+			return nil
+		}
+		params.AddChild(name)
+	}
+
+	definition := &Node{
+		Type:  FunctionDef,
+		Value: parts[1].Value,
+		Token: parts[0].Token,
+		Start: statement.Start,
+		End:   statement.End,
+
+		LeadingComments:  statement.LeadingComments,
+		TrailingComments: statement.TrailingComments,
+	}
+	definition.AddChild(parts[1])
+	definition.AddChild(params)
+	definition.AddChild(body)
+	return definition
+}
+
+// Helper function to report whether a node is a leaf holding the given keyword.
+func isKeywordNode(node *Node, keyword string) bool {
+	return node.Type == Leaf && node.Token.Type == lexer.Keyword && node.Value == keyword
+}
+
+// Helper function to report whether a node is a leaf holding a name.
+func isNameNode(node *Node) bool {
+	return node.Type == Leaf && node.Token.Type == lexer.Alphanumeric
+}
@@ -0,0 +1,33 @@
+// placer/json.go
+
+package placer
+
+import (
+	"encoding/json"
+)
+
+// jsonNode is the serialized shape of a Node. Parent links are left out so
+// that the output stays acyclic.
+type jsonNode struct {
+	Type     string  `json:"type"`
+	Value    string  `json:"value,omitempty"`
+	Line     int     `json:"line,omitempty"`
+	Column   int     `json:"column,omitempty"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+// MarshalJSON encodes the node and its descendants with readable type names.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonNode{
+		Type:     n.Type.String(),
+		Value:    n.Value,
+		Line:     n.Token.Line,
+		Column:   n.Token.Column,
+		Children: n.Children,
+	})
+}
+
+// ToJSON returns the indented JSON encoding of the tree built by PlaceTokens.
+func (p *Placer) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(p.root, "", "  ")
+}
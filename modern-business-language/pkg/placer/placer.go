@@ -0,0 +1,630 @@
+// placer/placer.go
+
+package placer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+)
+
+// NodeType represents the type of a node in the hierarchy.
+type NodeType int
+
+const (
+	Root NodeType = iota
+	Leaf
+	Block
+	Statement
+	BinaryExpr
+	UnaryExpr
+	CallExpr
+	ListExpr
+	IndexExpr
+	RecordExpr
+	MemberExpr
+	ConditionalExpr
+	BlankLines
+	FunctionDef
+	Parameters
+	LambdaExpr
+)
+
+var nodeTypeNames = map[NodeType]string{
+	Root:            "Root",
+	Leaf:            "Leaf",
+	Block:           "Block",
+	Statement:       "Statement",
+	BinaryExpr:      "BinaryExpr",
+	UnaryExpr:       "UnaryExpr",
+	CallExpr:        "CallExpr",
+	ListExpr:        "ListExpr",
+	IndexExpr:       "IndexExpr",
+	RecordExpr:      "RecordExpr",
+	MemberExpr:      "MemberExpr",
+	ConditionalExpr: "ConditionalExpr",
+	BlankLines:      "BlankLines",
+	FunctionDef:     "FunctionDef",
+	Parameters:      "Parameters",
+	LambdaExpr:      "LambdaExpr",
+}
+
+// String returns the name of the node type.
+func (t NodeType) String() string {
+	if name, ok := nodeTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("NodeType(%d)", int(t))
+}
+
+// Node is an element of the hierarchical data structure.
+// Leaf nodes wrap a single token; other nodes group their children.
+type Node struct {
+	Type     NodeType
+	Value    string
+	Token    lexer.Token
+	Children []*Node
+	Parent   *Node
+
+	// Start and End are the first and last tokens of the source the node was
+	// built from, so a BinaryExpr spans its left operand through its right.
+	Start lexer.Token
+	End   lexer.Token
+
+	// LeadingComments holds the comments on the lines just above a statement
+	// or function definition, such as a doc comment, and TrailingComments the
+	// ones ending its last line. Both are empty when the lexer strips comments.
+	// The comment leaves themselves stay in the tree where they were written.
+	LeadingComments  []lexer.Token
+	TrailingComments []lexer.Token
+}
+
+// AddChild appends a child node and links it back to its parent.
+func (n *Node) AddChild(child *Node) {
+	child.Parent = n
+	n.Children = append(n.Children, child)
+}
+
+// Mode selects how the Placer recognizes blocks.
+type Mode int
+
+const (
+	// IndentMode opens and closes blocks from the leading tabs of each line.
+	IndentMode Mode = iota
+	// BraceMode opens a block at each '{' and closes it at the matching '}'.
+	BraceMode
+)
+
+// DefaultMaxDepth is the number of levels blocks may nest before the Placer
+// reports an error. ParseExpression allows expressions to nest as deeply.
+const DefaultMaxDepth = 256
+
+// Placer is responsible for placing tokens in a hierarchical data structure.
+type Placer struct {
+	// Mode selects indentation or brace delimited blocks. Defaults to IndentMode.
+	Mode Mode
+
+	// SpacesPerIndent, when positive, makes IndentMode count that many leading
+	// spaces as one level of indentation, the same as one tab. Spaces only
+	// reach the placer when the lexer's PreserveWhitespace option is set.
+	// Zero or less ignores spaces, so only tabs indent.
+	SpacesPerIndent int
+
+	// RejectMixedIndent makes IndentMode report an error for a line indented
+	// with both tabs and spaces, or with spaces in a block indented with tabs
+	// and the other way around.
+	RejectMixedIndent bool
+
+	// PreserveBlankLines records each run of empty lines as a BlankLines node
+	// in the block it appears in, with the number of lines as its Value, so a
+	// formatter can reproduce them. Execution skips these nodes. By default
+	// blank lines leave no trace in the tree.
+	PreserveBlankLines bool
+
+	// CollectErrors makes PlaceTokens carry on past an error instead of
+	// stopping at the first one, so a single pass reports every problem it
+	// can find; Errors returns them. In BraceMode the offending delimiter is
+	// dropped; in IndentMode the rest of the badly indented line is skipped.
+	CollectErrors bool
+
+	// MaxDepth bounds how deeply blocks may nest, and in BraceMode how deeply
+	// (, [ and { may nest in all, guarding programs that place untrusted input
+	// against trees too deep to walk. Exceeding it stops
+	// placing even with CollectErrors, since nothing nested below the limit
+	// could be placed where it belongs. Zero or less removes the limit.
+	MaxDepth int
+
+	root       *Node
+	blocks     []openBlock
+	delimiters []lexer.Token
+	lineStart  bool
+	errors     []error
+	tooDeep    bool
+
+	knownDirectives map[string]bool
+	directives      []Directive
+}
+
+// openBlock tracks a block being filled, the indentation level that opened it
+// and the statement currently collecting its tokens.
+type openBlock struct {
+	level     int
+	style     indentStyle
+	node      *Node
+	statement *Node
+}
+
+// indentStyle records which characters a line was indented with.
+type indentStyle int
+
+const (
+	noIndent indentStyle = iota
+	tabIndent
+	spaceIndent
+	mixedIndent
+)
+
+var indentStyleNames = map[indentStyle]string{
+	noIndent:    "nothing",
+	tabIndent:   "tabs",
+	spaceIndent: "spaces",
+	mixedIndent: "tabs and spaces",
+}
+
+// NewPlacer creates a new Placer instance.
+func NewPlacer() *Placer {
+	p := &Placer{MaxDepth: DefaultMaxDepth, root: &Node{Type: Root}, knownDirectives: make(map[string]bool)}
+	p.AddDirectives(DefaultDirectives...)
+	return p
+}
+
+// Root returns the root of the hierarchy built by the last call to PlaceTokens.
+func (p *Placer) Root() *Node {
+	return p.root
+}
+
+// Errors returns the errors found by the last call to PlaceTokens, in the order
+// they were found. Without CollectErrors it holds at most the one that stopped it.
+func (p *Placer) Errors() []error {
+	return append([]error(nil), p.errors...)
+}
+
+// PlaceTokens places tokens in the hierarchical data structure.
+// Tokens are grouped into Statement nodes, one per line, and blocks hang off
+// the statement that introduces them. A ';' also ends a statement, so several
+// can share a line; separators with nothing between them add no statements.
+// A function definition becomes a FunctionDef node instead of a Statement.
+// A directive line such as "@strict" is checked and collected for Directives
+// instead of being placed.
+// Comments are attached to the statements they describe; see LeadingComments.
+// With CollectErrors, every error found is returned, joined into one.
+func (p *Placer) PlaceTokens(tokens []lexer.Token) error {
+	p.root = &Node{Type: Root}
+	p.blocks = []openBlock{{level: 0, node: p.root}}
+	p.delimiters = nil
+	p.lineStart = true
+	p.errors = nil
+	p.tooDeep = false
+	p.directives = nil
+
+	var end *lexer.Token
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.Type == lexer.EOF {
+			end = &tokens[i]
+			break
+		}
+		if token.Type == lexer.Whitespace && p.Mode == BraceMode {
+			continue
+		}
+		if token.Type == lexer.Directive {
+			end := endOfLine(tokens, i)
+			if err := p.placeDirective(tokens[i:end]); err != nil && p.record(err) {
+				return err
+			}
+			i = end - 1
+			continue
+		}
+
+		var err error
+		switch p.Mode {
+		case BraceMode:
+			err = p.placeBraced(token)
+		default:
+			err = p.placeIndented(tokens[i:])
+		}
+		if err != nil {
+			if p.record(err) {
+				return err
+			}
+			if p.tooDeep {
+				return p.result()
+			}
+			if p.Mode != BraceMode {
+				i = endOfLine(tokens, i) - 1
+			}
+			continue
+		}
+
+		if p.PreserveBlankLines && token.Type == lexer.NewLine {
+			p.addBlankLines(tokens[:i+1])
+		}
+	}
+
+	if len(p.delimiters) > 0 {
+		open := p.delimiters[len(p.delimiters)-1]
+		if end == nil {
+			end = &open
+		}
+		err := errorAt(*end, "expected '%s' to close '%s' at line %d col %d, found end of input",
+			closers[open.Value], open.Value, open.Line, open.Column)
+		if p.record(err) {
+			return err
+		}
+	}
+
+	associateComments(p.root)
+	defineFunctions(p.root)
+	return p.result()
+}
+
+// Helper function to return the errors recorded so far as a single error, or
+// nil when there are none.
+func (p *Placer) result() error {
+	switch len(p.errors) {
+	case 0:
+		return nil
+	case 1:
+		return p.errors[0]
+	default:
+		return errors.Join(p.errors...)
+	}
+}
+
+// Helper function to check that opening a block or bracket at the given token,
+// with depth levels already open, stays within MaxDepth.
+func (p *Placer) checkDepth(token lexer.Token, depth int) error {
+	if p.MaxDepth <= 0 || depth < p.MaxDepth {
+		return nil
+	}
+	p.tooDeep = true
+	if isSymbol(token, "(") || isSymbol(token, "[") {
+		return errorAt(token, "brackets nested more than %d deep", p.MaxDepth)
+	}
+	return errorAt(token, "blocks nested more than %d deep", p.MaxDepth)
+}
+
+// Helper function to record an error, reporting whether placing stops at it.
+func (p *Placer) record(err error) bool {
+	p.errors = append(p.errors, err)
+	return !p.CollectErrors
+}
+
+// Helper function to return the position of the NewLine or EOF token ending
+// the line that holds tokens[i].
+func endOfLine(tokens []lexer.Token, i int) int {
+	for i < len(tokens) && tokens[i].Type != lexer.NewLine && tokens[i].Type != lexer.EOF {
+		i++
+	}
+	return i
+}
+
+// Helper function to place the first of the remaining tokens in indentation mode.
+// Leading tabs on a line set its indentation level: a deeper line opens a
+// Block node, and a shallower line closes blocks back to a matching level.
+func (p *Placer) placeIndented(tokens []lexer.Token) error {
+	token := tokens[0]
+
+	line := tokens
+	if p.lineStart && isCommentLine(line) {
+		line = nextCodeLine(line)
+	}
+	if p.lineStart && line != nil && !isBlankLine(line) {
+		level, style, err := p.measureIndent(line)
+		if err != nil {
+			return err
+		}
+
+		err = p.indent(level, style, line[0])
+		if err != nil {
+			return err
+		}
+	}
+	p.lineStart = token.Type == lexer.NewLine
+
+	switch {
+	case token.Type == lexer.Tab, token.Type == lexer.Whitespace:
+	case token.Type == lexer.NewLine, isSymbol(token, ";"):
+		p.endStatement()
+	default:
+		p.addLeaf(token)
+	}
+	return nil
+}
+
+// closers pairs each opening delimiter with the one that closes it.
+var closers = map[string]string{"(": ")", "[": "]", "{": "}"}
+
+// openers pairs each closing delimiter with the one that opens it.
+var openers = map[string]string{")": "(", "]": "[", "}": "{"}
+
+// Helper function to place a token in brace mode, where tabs carry no structure.
+// Every (, [ and { must be closed by its own partner, innermost first.
+func (p *Placer) placeBraced(token lexer.Token) error {
+	if token.Type == lexer.Symbol && closers[token.Value] != "" {
+		if err := p.checkDepth(token, len(p.delimiters)); err != nil {
+			return err
+		}
+		p.delimiters = append(p.delimiters, token)
+	}
+	if token.Type == lexer.Symbol && openers[token.Value] != "" {
+		err := p.closeDelimiter(token)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case token.Type == lexer.Tab:
+		return nil
+	case token.Type == lexer.NewLine, isSymbol(token, ";"):
+		p.endStatement()
+		return nil
+	case isSymbol(token, "{"):
+		block := &Node{Type: Block, Token: token}
+		p.statement(token).AddChild(block)
+		cover(block, token)
+		p.blocks = append(p.blocks, openBlock{node: block})
+		return nil
+	case isSymbol(token, "}"):
+		cover(p.blocks[len(p.blocks)-1].node, token)
+		p.blocks = p.blocks[:len(p.blocks)-1]
+		return nil
+	}
+
+	p.addLeaf(token)
+	return nil
+}
+
+// Helper function to match a closing delimiter against the innermost open one.
+func (p *Placer) closeDelimiter(token lexer.Token) error {
+	if len(p.delimiters) == 0 {
+		return errorAt(token, "found '%s' with no open '%s'", token.Value, openers[token.Value])
+	}
+
+	open := p.delimiters[len(p.delimiters)-1]
+	if closers[open.Value] != token.Value {
+		return errorAt(token, "expected '%s' to close '%s' at line %d col %d, found '%s'",
+			closers[open.Value], open.Value, open.Line, open.Column, token.Value)
+	}
+
+	p.delimiters = p.delimiters[:len(p.delimiters)-1]
+	return nil
+}
+
+// Helper function to return the statement being built in the innermost block,
+// starting a new one at the given token when none is open.
+func (p *Placer) statement(token lexer.Token) *Node {
+	top := &p.blocks[len(p.blocks)-1]
+	if top.statement == nil {
+		top.statement = &Node{Type: Statement, Token: token}
+		top.node.AddChild(top.statement)
+	}
+	return top.statement
+}
+
+// Helper function to finish the statement being built in the innermost block.
+func (p *Placer) endStatement() {
+	p.blocks[len(p.blocks)-1].statement = nil
+}
+
+// Helper function to add a token to the current statement.
+func (p *Placer) addLeaf(token lexer.Token) {
+	statement := p.statement(token)
+	statement.AddChild(newLeaf(token))
+	cover(statement, token)
+}
+
+// Helper function to widen the span of a node and of each of its ancestors so
+// that it takes in the given token, which follows everything placed so far.
+func cover(node *Node, token lexer.Token) {
+	for ; node != nil; node = node.Parent {
+		if node.Start.Line == 0 {
+			node.Start = token
+		}
+		node.End = token
+	}
+}
+
+// Helper function to measure the indentation at the start of a line, returning
+// its level and the characters it was made of.
+func (p *Placer) measureIndent(tokens []lexer.Token) (int, indentStyle, error) {
+	tabs, spaces := 0, 0
+	for _, token := range tokens {
+		if token.Type == lexer.Tab {
+			tabs += len(token.Value)
+		} else if token.Type == lexer.Whitespace {
+			spaces += strings.Count(token.Value, " ")
+		} else {
+			break
+		}
+	}
+
+	style := noIndent
+	switch {
+	case tabs > 0 && spaces > 0:
+		style = mixedIndent
+	case tabs > 0:
+		style = tabIndent
+	case spaces > 0:
+		style = spaceIndent
+	}
+
+	if p.RejectMixedIndent && style == mixedIndent {
+		return 0, style, errorAt(tokens[0], "indentation mixes tabs and spaces")
+	}
+
+	level := tabs
+	if p.SpacesPerIndent > 0 {
+		if spaces%p.SpacesPerIndent != 0 {
+			return 0, style, errorAt(tokens[0], "indentation of %d spaces is not a multiple of %d", spaces, p.SpacesPerIndent)
+		}
+		level += spaces / p.SpacesPerIndent
+	}
+	return level, style, nil
+}
+
+// Helper function to open or close blocks so the given indentation level is current.
+func (p *Placer) indent(level int, style indentStyle, token lexer.Token) error {
+	top := p.blocks[len(p.blocks)-1]
+
+	if level > top.level {
+		// A block hangs off the statement before it, so an indented line with
+		// no such statement, such as the first line of a file, is a mistake.
+		at := lastStatement(top.node)
+		if at < 0 || top.node.Children[at].Type != Statement {
+			return errorAt(token, "unexpected indent")
+		}
+		if err := p.checkDepth(token, len(p.blocks)-1); err != nil {
+			return err
+		}
+
+		block := &Node{Type: Block, Token: token}
+		top.node.Children[at].AddChild(block)
+
+		// Blank lines between the header and the indented line belong to the block.
+		for _, blank := range top.node.Children[at+1:] {
+			block.AddChild(blank)
+		}
+		top.node.Children = top.node.Children[:at+1]
+		p.blocks = append(p.blocks, openBlock{level: level, style: style, node: block})
+		return nil
+	}
+
+	for len(p.blocks) > 1 && p.blocks[len(p.blocks)-1].level > level {
+		p.blocks = p.blocks[:len(p.blocks)-1]
+	}
+
+	current := p.blocks[len(p.blocks)-1]
+	if current.level != level {
+		return errorAt(token, "inconsistent dedent to level %d", level)
+	}
+	if p.RejectMixedIndent && level > 0 && style != current.style {
+		return errorAt(token, "line indented with %s in a block indented with %s",
+			indentStyleNames[style], indentStyleNames[current.style])
+	}
+	return nil
+}
+
+// Helper function to report whether the line starting at tokens[0] holds nothing but tabs and whitespace.
+func isBlankLine(tokens []lexer.Token) bool {
+	for _, token := range tokens {
+		switch token.Type {
+		case lexer.Tab, lexer.Whitespace:
+			continue
+		case lexer.NewLine, lexer.EOF:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// Helper function to report whether the line starting at tokens[0] holds only
+// comments, tabs and whitespace. Its own indentation is not measured, so a
+// comment at column 0 cannot close the block around it; it takes the
+// indentation of the code line after it instead.
+func isCommentLine(tokens []lexer.Token) bool {
+	comment := false
+	for _, token := range tokens {
+		switch token.Type {
+		case lexer.Tab, lexer.Whitespace:
+			continue
+		case lexer.Comment:
+			comment = true
+			continue
+		case lexer.NewLine, lexer.EOF:
+			return comment
+		}
+		return false
+	}
+	return comment
+}
+
+// Helper function to return the tokens from the start of the first line after
+// the one starting at tokens[0] that is neither blank nor only comments, or nil
+// when no such line follows.
+func nextCodeLine(tokens []lexer.Token) []lexer.Token {
+	for i, token := range tokens {
+		if token.Type != lexer.NewLine {
+			continue
+		}
+		line := tokens[i+1:]
+		if !isBlankLine(line) && !isCommentLine(line) {
+			return line
+		}
+	}
+	return nil
+}
+
+// Helper function to return the position of the last child of a node that is
+// not a BlankLines node, or -1 when it has none.
+func lastStatement(node *Node) int {
+	for i := len(node.Children) - 1; i >= 0; i-- {
+		if node.Children[i].Type != BlankLines {
+			return i
+		}
+	}
+	return -1
+}
+
+// Helper function to add a BlankLines node to the innermost block for the empty
+// lines ended by the last of the tokens, a NewLine. Its first line break ends
+// the line before it unless that line held nothing but tabs and whitespace.
+func (p *Placer) addBlankLines(tokens []lexer.Token) {
+	newLine := tokens[len(tokens)-1]
+	count := lineBreaks(newLine.Value)
+	if lineHasContent(tokens[:len(tokens)-1]) {
+		count--
+	}
+	if count == 0 {
+		return
+	}
+
+	block := p.blocks[len(p.blocks)-1].node
+	block.AddChild(&Node{Type: BlankLines, Value: strconv.Itoa(count), Token: newLine, Start: newLine, End: newLine})
+}
+
+// Helper function to count the lines a NewLine token ends. The lexer keeps a
+// "\r\n" pair or a lone '\r' in the token when it preserves whitespace.
+func lineBreaks(value string) int {
+	return strings.Count(value, "\n") + strings.Count(value, "\r") - strings.Count(value, "\r\n")
+}
+
+// Helper function to report whether the tokens since the last NewLine hold
+// anything besides tabs and whitespace.
+func lineHasContent(tokens []lexer.Token) bool {
+	for i := len(tokens) - 1; i >= 0; i-- {
+		switch tokens[i].Type {
+		case lexer.Tab, lexer.Whitespace:
+			continue
+		case lexer.NewLine:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// Helper function to report whether a token is the given symbol.
+func isSymbol(token lexer.Token, symbol string) bool {
+	return token.Type == lexer.Symbol && token.Value == symbol
+}
+
+// Helper function to wrap a token in a leaf node.
+func newLeaf(token lexer.Token) *Node {
+	return &Node{Type: Leaf, Value: token.Value, Token: token, Start: token, End: token}
+}
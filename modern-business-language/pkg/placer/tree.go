@@ -0,0 +1,31 @@
+// placer/tree.go
+
+package placer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tree renders the node and its descendants as an indented outline, one node
+// per line and two spaces per level of depth. Values are shown quoted, so
+// new lines and tabs stay visible.
+func (n *Node) Tree() string {
+	var tree strings.Builder
+	n.writeTree(&tree, 0)
+	return tree.String()
+}
+
+// Helper function to write a node and its descendants at the given depth.
+func (n *Node) writeTree(tree *strings.Builder, depth int) {
+	tree.WriteString(strings.Repeat("  ", depth))
+	tree.WriteString(n.Type.String())
+	if n.Value != "" {
+		fmt.Fprintf(tree, " %q", n.Value)
+	}
+	tree.WriteString("\n")
+
+	for _, child := range n.Children {
+		child.writeTree(tree, depth+1)
+	}
+}
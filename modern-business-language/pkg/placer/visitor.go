@@ -0,0 +1,40 @@
+// placer/visitor.go
+
+package placer
+
+// Visitor is implemented by anything that inspects a placed tree, such as a
+// linter or a code generator, without depending on how the Placer builds it.
+type Visitor interface {
+	// VisitNode is called once for each node. Returning an error stops the walk.
+	VisitNode(node *Node) error
+}
+
+// VisitorFunc adapts an ordinary function to the Visitor interface.
+type VisitorFunc func(node *Node) error
+
+// VisitNode calls f(node).
+func (f VisitorFunc) VisitNode(node *Node) error {
+	return f(node)
+}
+
+// Walk visits root and then each of its descendants depth first, parents
+// before children and children in order. It stops at the first error a visit
+// returns and passes that error back to the caller.
+func Walk(root *Node, v Visitor) error {
+	if root == nil {
+		return nil
+	}
+
+	err := v.VisitNode(root)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range root.Children {
+		err := Walk(child, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
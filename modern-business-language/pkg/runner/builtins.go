@@ -0,0 +1,123 @@
+// runner/builtins.go
+
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Builtin is a function implemented in Go and callable from MBL code. It
+// receives the evaluated arguments; an error it returns becomes the Cause of a
+// RuntimeError positioned at the call.
+type Builtin func(args []Value) (Value, error)
+
+// defaultBuiltins returns the built-in functions every new Runner starts with.
+func defaultBuiltins() map[string]Builtin {
+	builtins := map[string]Builtin{
+		"length": builtinLength,
+		"assert": builtinAssert,
+		"typeof": builtinTypeof,
+	}
+	for name, builtin := range stringBuiltins() {
+		builtins[name] = builtin
+	}
+	for name, builtin := range mathBuiltins() {
+		builtins[name] = builtin
+	}
+	for name, builtin := range moneyBuiltins() {
+		builtins[name] = builtin
+	}
+	for name, builtin := range convertBuiltins() {
+		builtins[name] = builtin
+	}
+	return builtins
+}
+
+// Register makes a built-in function callable from MBL code under the given
+// name, replacing any built-in already registered with that name. Variables
+// and functions defined by the program take precedence over built-ins.
+func (r *Runner) Register(name string, builtin Builtin) {
+	r.builtins[name] = builtin
+}
+
+// Builtins returns the names of the registered built-in functions in sorted order.
+func (r *Runner) Builtins() []string {
+	names := make([]string, 0, len(r.builtins))
+	for name := range r.builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupBuiltin returns a built-in function as a callable Value.
+func (r *Runner) lookupBuiltin(name string) (Value, bool) {
+	builtin, ok := r.builtins[name]
+	if !ok {
+		return Value{}, false
+	}
+	return Value{Kind: Function, Func: &FunctionValue{Name: name, Builtin: builtin}}, true
+}
+
+// expectArgs checks that a built-in received the number of arguments it takes.
+func expectArgs(name string, args []Value, count int) error {
+	if len(args) != count {
+		return fmt.Errorf("%s expects %d arguments, got %d", name, count, len(args))
+	}
+	return nil
+}
+
+// builtinLength returns the number of items in a list or characters in a string.
+func builtinLength(args []Value) (Value, error) {
+	if err := expectArgs("length", args, 1); err != nil {
+		return Value{}, err
+	}
+
+	switch args[0].Kind {
+	case List:
+		return IntegerValue(int64(len(args[0].List.Items))), nil
+	case String:
+		return IntegerValue(int64(utf8.RuneCountInString(args[0].Str))), nil
+	default:
+		return Value{}, fmt.Errorf("length expects a list or string, got %v", args[0].Kind)
+	}
+}
+
+// builtinTypeof returns the name of its argument's kind, the same name error
+// messages use: "integer", "number" or "decimal" for the three kinds of
+// numbers, and "string", "boolean", "function", "list", "record", "date" or
+// "null". A currency amount is a "decimal".
+func builtinTypeof(args []Value) (Value, error) {
+	if err := expectArgs("typeof", args, 1); err != nil {
+		return Value{}, err
+	}
+	return StringValue(args[0].Kind.String()), nil
+}
+
+// builtinAssert does nothing when its condition is true and fails with an
+// AssertionError carrying the message when it is false, as in
+// assert(total >= 0, "total cannot be negative"). The message may be left out.
+func builtinAssert(args []Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{}, fmt.Errorf("assert expects 1 or 2 arguments, got %d", len(args))
+	}
+	if args[0].Kind != Boolean {
+		return Value{}, fmt.Errorf("assert expects a boolean condition, got %v", args[0].Kind)
+	}
+
+	message := ""
+	if len(args) == 2 {
+		var err error
+		message, err = expectString("assert", args[1])
+		if err != nil {
+			return Value{}, err
+		}
+	}
+
+	if !args[0].Bool {
+		return Value{}, &AssertionError{Message: message}
+	}
+	return Value{}, nil
+}
@@ -0,0 +1,129 @@
+// runner/compare.go
+
+package runner
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/Solifugus/mbl/pkg/placer"
+)
+
+// comparisons lists the operators handled by evaluateComparison.
+var comparisons = map[string]bool{
+	"==": true,
+	"!=": true,
+	"<":  true,
+	">":  true,
+	"<=": true,
+	">=": true,
+}
+
+// evaluateComparison applies a comparison operator and produces a boolean.
+// Integers, numbers and decimals compare by value, strings compare
+// lexicographically and dates in calendar order.
+// A string compared with a number is read as a number when it holds one, so
+// "42" == 42 is true; otherwise mixing kinds is an error. Any value may be
+// tested for equality with null, but null has no ordering.
+func evaluateComparison(node *placer.Node, left, right Value) (Value, error) {
+	left, right, ok := coerceOperands(left, right)
+	if !ok {
+		return Value{}, errorAt(node.Token, "cannot compare %v and %v", left.Kind, right.Kind)
+	}
+
+	switch node.Value {
+	case "==":
+		return BooleanValue(left.Equal(right)), nil
+	case "!=":
+		return BooleanValue(!left.Equal(right)), nil
+	}
+
+	order, ok := compareOrder(left, right)
+	if !ok {
+		return Value{}, errorAt(node.Token, "cannot apply %s to %v and %v", node.Value, left.Kind, right.Kind)
+	}
+
+	switch node.Value {
+	case "<":
+		return BooleanValue(order < 0), nil
+	case ">":
+		return BooleanValue(order > 0), nil
+	case "<=":
+		return BooleanValue(order <= 0), nil
+	default:
+		return BooleanValue(order >= 0), nil
+	}
+}
+
+// coerceOperands brings two operands to comparable kinds, reading a string as
+// a number when the other side is numeric. It reports false when the kinds
+// cannot be compared at all.
+func coerceOperands(left, right Value) (Value, Value, bool) {
+	switch {
+	case left.Kind == right.Kind, isNumeric(left) && isNumeric(right):
+		return left, right, true
+	case left.Kind == Null || right.Kind == Null:
+		return left, right, true
+	case left.Kind == String && isNumeric(right):
+		number, ok := parseText(left.Str)
+		if !ok {
+			return left, right, false
+		}
+		return number, right, true
+	case isNumeric(left) && right.Kind == String:
+		number, ok := parseText(right.Str)
+		if !ok {
+			return left, right, false
+		}
+		return left, number, true
+	default:
+		return left, right, false
+	}
+}
+
+// compareOrder returns -1, 0 or 1 as left sorts before, with or after right.
+// It reports false for kinds without an ordering.
+func compareOrder(left, right Value) (int, bool) {
+	switch {
+	case left.Kind == Integer && right.Kind == Integer:
+		switch {
+		case left.Int < right.Int:
+			return -1, true
+		case left.Int > right.Int:
+			return 1, true
+		default:
+			return 0, true
+		}
+	case left.Kind == Number && right.Kind == Number:
+		switch {
+		case left.Num < right.Num:
+			return -1, true
+		case left.Num > right.Num:
+			return 1, true
+		default:
+			return 0, true
+		}
+	case isNumeric(left) && isNumeric(right):
+		return toRat(left).Cmp(toRat(right)), true
+	case left.Kind == String && right.Kind == String:
+		return strings.Compare(left.Str, right.Str), true
+	case left.Kind == Date && right.Kind == Date:
+		return left.Date.Compare(right.Date), true
+	default:
+		return 0, false
+	}
+}
+
+// parseText reads a string holding a plain decimal number, such as " 12.50 ".
+func parseText(text string) (Value, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.ContainsAny(text, "/_") {
+		return Value{}, false
+	}
+
+	d, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return Value{}, false
+	}
+	return Value{Kind: Decimal, Dec: d}, true
+}
@@ -0,0 +1,377 @@
+// runner/control.go
+
+package runner
+
+import (
+	"errors"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+	"github.com/Solifugus/mbl/pkg/placer"
+)
+
+// executeIf runs "if cond { ... }", optionally followed by "else { ... }" or
+// "else if cond { ... }", executing the first branch whose condition holds.
+func (r *Runner) executeIf(nodes []*placer.Node) error {
+	keyword := nodes[0]
+
+	blockAt := indexOfBlock(nodes)
+	if blockAt < 0 {
+		return errorAt(keyword.Token, "expected a block after if")
+	}
+
+	rest := nodes[blockAt+1:]
+	if len(rest) > 0 && !isKeyword(rest[0], "else") {
+		return errorAt(rest[0].Token, "unexpected %q after if block", rest[0].Value)
+	}
+
+	condition, err := r.evaluateCondition(keyword, nodes[1:blockAt])
+	if err != nil {
+		return err
+	}
+
+	if condition {
+		return r.executeStatements(nodes[blockAt])
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+
+	elseKeyword := rest[0]
+	switch {
+	case len(rest) > 1 && isKeyword(rest[1], "if"):
+		return r.executeIf(rest[1:])
+	case len(rest) == 2 && rest[1].Type == placer.Block:
+		return r.executeStatements(rest[1])
+	default:
+		return errorAt(elseKeyword.Token, "expected a block after else")
+	}
+}
+
+// executeWhile runs "while cond { ... }", checking the condition before every
+// iteration and failing once the loop repeats more than MaxIterations times.
+func (r *Runner) executeWhile(nodes []*placer.Node) error {
+	keyword := nodes[0]
+
+	blockAt := indexOfBlock(nodes)
+	if blockAt < 0 {
+		return errorAt(keyword.Token, "expected a block after while")
+	}
+	if blockAt != len(nodes)-1 {
+		extra := nodes[blockAt+1]
+		return errorAt(extra.Token, "unexpected %q after while block", extra.Value)
+	}
+
+	r.loops++
+	defer func() { r.loops-- }()
+
+	for iterations := 0; ; iterations++ {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+
+		condition, err := r.evaluateCondition(keyword, nodes[1:blockAt])
+		if err != nil {
+			return err
+		}
+		if !condition {
+			return nil
+		}
+
+		if r.MaxIterations > 0 && iterations >= r.MaxIterations {
+			return errorAt(keyword.Token, "while loop exceeded %d iterations", r.MaxIterations)
+		}
+
+		err = r.executeStatements(nodes[blockAt])
+		if stop, err := loopOutcome(err); stop {
+			return err
+		}
+	}
+}
+
+// executeFor runs "for item in list { ... }" once per item, in order, and
+// "for key, value in record { ... }" once per field. A single name over a
+// record binds the keys. Records are visited in the order their fields were
+// first set, so the order is stable from run to run; fields added by the body
+// are not visited. Each iteration gets a fresh block scope
+// holding the loop variables; other assignments reach the enclosing scope.
+func (r *Runner) executeFor(nodes []*placer.Node) error {
+	keyword := nodes[0]
+
+	inAt := -1
+	for i, node := range nodes {
+		if isKeyword(node, "in") {
+			inAt = i
+			break
+		}
+	}
+	if inAt < 0 {
+		return errorAt(keyword.Token, "expected 'in' after the loop variables of for")
+	}
+
+	names, err := loopVariables(keyword, nodes[1:inAt])
+	if err != nil {
+		return err
+	}
+
+	body := nodes[len(nodes)-1]
+	if body.Type != placer.Block || len(nodes)-1 == inAt {
+		return errorAt(keyword.Token, "expected a block after for")
+	}
+	if len(nodes)-1 == inAt+1 {
+		return errorAt(nodes[inAt].Token, "expected a list or record after in")
+	}
+
+	collection, err := r.evaluateNodes(nodes[inAt+1 : len(nodes)-1])
+	if err != nil {
+		return err
+	}
+
+	r.loops++
+	defer func() { r.loops-- }()
+
+	switch collection.Kind {
+	case List:
+		if len(names) != 1 {
+			return errorAt(keyword.Token, "for over a list takes one loop variable, got %d", len(names))
+		}
+
+		items := append([]Value(nil), collection.List.Items...)
+		for _, item := range items {
+			err := r.iterate(body, names, item)
+			if stop, err := loopOutcome(err); stop {
+				return err
+			}
+		}
+		return nil
+	case Record:
+		for _, key := range collection.Record.Keys() {
+			err := r.iterate(body, names, StringValue(key), collection.Record.Fields[key])
+			if stop, err := loopOutcome(err); stop {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errorAt(nodes[inAt].Token, "cannot iterate over %v", collection.Kind)
+	}
+}
+
+// switchClause is one "case value:" or "default:" of a switch, with the
+// statements that run when it is chosen.
+type switchClause struct {
+	value      []*placer.Node
+	statements [][]*placer.Node
+}
+
+// executeSwitch runs "switch expr { case v1: ... case v2: ... default: ... }",
+// executing the statements of the first case whose value equals expr, or those
+// of default when none does. Case values are compared like == and are only
+// evaluated until one matches. Cases do not fall through: once the chosen case
+// finishes, execution continues after the switch. A case's statements may
+// follow its ':' on the same line or fill the lines up to the next case.
+func (r *Runner) executeSwitch(nodes []*placer.Node) error {
+	keyword := nodes[0]
+
+	blockAt := indexOfBlock(nodes)
+	if blockAt < 0 {
+		return errorAt(keyword.Token, "expected a block after switch")
+	}
+	if blockAt != len(nodes)-1 {
+		extra := nodes[blockAt+1]
+		return errorAt(extra.Token, "unexpected %q after switch block", extra.Value)
+	}
+	if blockAt == 1 {
+		return errorAt(keyword.Token, "expected a value after switch")
+	}
+
+	clauses, fallback, err := switchClauses(nodes[blockAt])
+	if err != nil {
+		return err
+	}
+
+	subject, err := r.evaluateNodes(nodes[1:blockAt])
+	if err != nil {
+		return err
+	}
+
+	chosen := fallback
+	for _, clause := range clauses {
+		value, err := r.evaluateNodes(clause.value)
+		if err != nil {
+			return err
+		}
+		if value.Equal(subject) {
+			chosen = clause
+			break
+		}
+	}
+	if chosen == nil {
+		return nil
+	}
+
+	for _, statement := range chosen.statements {
+		if err := r.executeStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// switchClauses splits the block of a switch into its cases, in order, and its
+// default clause, which is nil when there is none.
+func switchClauses(block *placer.Node) ([]*switchClause, *switchClause, error) {
+	var clauses []*switchClause
+	var fallback, current *switchClause
+
+	for _, nodes := range statementsOf(block) {
+		if len(nodes) == 0 {
+			continue
+		}
+
+		first := nodes[0]
+		if !isKeyword(first, "case") && !isKeyword(first, "default") {
+			if current == nil {
+				return nil, nil, errorAt(first.Token, "expected case or default in switch, got %q", first.Value)
+			}
+			current.statements = append(current.statements, nodes)
+			continue
+		}
+
+		colonAt := indexOfSymbol(nodes, ":")
+		if colonAt < 0 {
+			return nil, nil, errorAt(first.Token, "expected ':' after %s", first.Value)
+		}
+
+		current = &switchClause{value: nodes[1:colonAt]}
+		if rest := nodes[colonAt+1:]; len(rest) > 0 {
+			current.statements = append(current.statements, rest)
+		}
+
+		if isKeyword(first, "default") {
+			if colonAt != 1 {
+				return nil, nil, errorAt(nodes[1].Token, "unexpected %q after default", nodes[1].Value)
+			}
+			if fallback != nil {
+				return nil, nil, errorAt(first.Token, "switch has more than one default")
+			}
+			fallback = current
+			continue
+		}
+
+		if colonAt == 1 {
+			return nil, nil, errorAt(first.Token, "expected a value after case")
+		}
+		clauses = append(clauses, current)
+	}
+
+	return clauses, fallback, nil
+}
+
+// loopVariables reads the one or two comma separated names between for and in.
+func loopVariables(keyword *placer.Node, nodes []*placer.Node) ([]string, error) {
+	var names []string
+	for i, node := range nodes {
+		if i%2 == 1 {
+			if !isSymbol(node, ",") {
+				return nil, errorAt(node.Token, "expected ',' or 'in' after loop variable, got %q", node.Value)
+			}
+			continue
+		}
+		if node.Type != placer.Leaf || node.Token.Type != lexer.Alphanumeric {
+			return nil, errorAt(node.Token, "expected a loop variable name, got %q", node.Value)
+		}
+		names = append(names, node.Value)
+	}
+
+	if len(names) == 0 || len(nodes)%2 == 0 {
+		return nil, errorAt(keyword.Token, "expected a loop variable after for")
+	}
+	if len(names) > 2 {
+		return nil, errorAt(keyword.Token, "for takes at most two loop variables, got %d", len(names))
+	}
+	return names, nil
+}
+
+// iterate runs one pass of a for loop body with the loop variables bound to values.
+func (r *Runner) iterate(body *placer.Node, names []string, values ...Value) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	previous := r.scope
+	r.scope = newBlockScope(previous)
+	defer func() { r.scope = previous }()
+
+	for i, name := range names {
+		r.scope.declare(name, values[i])
+	}
+	return r.executeStatements(body)
+}
+
+// loopSignal unwinds execution from a break or continue statement to the
+// innermost loop, which stops or moves on to its next iteration.
+type loopSignal struct {
+	keyword string
+}
+
+func (s *loopSignal) Error() string {
+	return s.keyword + " outside of a loop"
+}
+
+// executeLoopControl runs "break" or "continue" inside a while or for loop.
+func (r *Runner) executeLoopControl(nodes []*placer.Node) error {
+	keyword := nodes[0]
+	if len(nodes) > 1 {
+		return errorAt(nodes[1].Token, "unexpected %q after %s", nodes[1].Value, keyword.Value)
+	}
+	if r.loops == 0 {
+		return errorAt(keyword.Token, "%s outside of a loop", keyword.Value)
+	}
+	return &loopSignal{keyword: keyword.Value}
+}
+
+// loopOutcome interprets the error from one pass of a loop body. It reports
+// whether the loop must stop and the error, if any, the loop should return:
+// break stops the loop cleanly, continue carries on with the next pass and
+// any other error stops the loop and is passed on.
+func loopOutcome(err error) (bool, error) {
+	var signal *loopSignal
+	ok := errors.As(err, &signal)
+	switch {
+	case err == nil:
+		return false, nil
+	case ok && signal.keyword == "continue":
+		return false, nil
+	case ok:
+		return true, nil
+	default:
+		return true, err
+	}
+}
+
+// evaluateCondition evaluates the condition of a control statement, which must be a boolean.
+func (r *Runner) evaluateCondition(keyword *placer.Node, nodes []*placer.Node) (bool, error) {
+	if len(nodes) == 0 {
+		return false, errorAt(keyword.Token, "expected a condition after %s", keyword.Value)
+	}
+
+	value, err := r.evaluateNodes(nodes)
+	if err != nil {
+		return false, err
+	}
+
+	if value.Kind != Boolean {
+		return false, errorAt(keyword.Token, "%s condition must be a boolean, got %v", keyword.Value, value.Kind)
+	}
+	return value.Bool, nil
+}
+
+// indexOfBlock returns the position of the first Block node, or -1 when there is none.
+func indexOfBlock(nodes []*placer.Node) int {
+	for i, node := range nodes {
+		if node.Type == placer.Block {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,117 @@
+// runner/convert.go
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+)
+
+// convertBuiltins returns the built-in functions that convert a value explicitly
+// from one kind to another.
+func convertBuiltins() map[string]Builtin {
+	return map[string]Builtin{
+		"to_number":  builtinToNumber,
+		"to_string":  builtinToString,
+		"to_boolean": builtinToBoolean,
+	}
+}
+
+// builtinToNumber returns a number unchanged and parses a string written the
+// way a numeric literal is, with an optional sign and surrounding spaces, so
+// to_number(" -1_250.50 ") is the decimal -1250.5 and to_number("5%") is 0.05.
+// Like a literal, the result is an integer, a number or a decimal.
+func builtinToNumber(args []Value) (Value, error) {
+	if err := expectArgs("to_number", args, 1); err != nil {
+		return Value{}, err
+	}
+
+	switch arg := args[0]; {
+	case isNumeric(arg):
+		return arg, nil
+	case arg.Kind == String:
+		n, ok := parseNumber(arg.Str)
+		if !ok {
+			return Value{}, fmt.Errorf("to_number cannot convert %q to a number", arg.Str)
+		}
+		return n, nil
+	default:
+		return Value{}, fmt.Errorf("to_number cannot convert %v to a number", arg.Kind)
+	}
+}
+
+// parseNumber parses text holding a single numeric literal, optionally signed.
+func parseNumber(text string) (Value, bool) {
+	text = strings.TrimSpace(text)
+	negative := strings.HasPrefix(text, "-")
+	if negative || strings.HasPrefix(text, "+") {
+		text = text[1:]
+	}
+
+	tokens, err := lexer.NewLexer(text).Lex()
+	if err != nil || len(tokens) != 2 || tokens[0].Type != lexer.Numeric || tokens[0].Value != text {
+		return Value{}, false
+	}
+
+	n, err := parseNumeric(tokens[0])
+	if err != nil {
+		return Value{}, false
+	}
+	if negative {
+		n = negate(n)
+	}
+	return n, true
+}
+
+// builtinToString renders any value the way print shows it, so
+// to_string(19.90) is "19.9" and to_string(null) is "null".
+func builtinToString(args []Value) (Value, error) {
+	if err := expectArgs("to_string", args, 1); err != nil {
+		return Value{}, err
+	}
+	return StringValue(args[0].String()), nil
+}
+
+// builtinToBoolean converts a value to a boolean by these rules:
+//   - a boolean is returned unchanged, and null is false;
+//   - a number of any kind is false when it is zero and true otherwise;
+//   - a list or a record is false when it is empty and true otherwise;
+//   - a string, ignoring case and surrounding spaces, is true when it reads
+//     "true", "yes" or "1" and false when it reads "false", "no", "0" or
+//     nothing at all.
+//
+// Any other string, and a function, cannot be converted.
+func builtinToBoolean(args []Value) (Value, error) {
+	if err := expectArgs("to_boolean", args, 1); err != nil {
+		return Value{}, err
+	}
+
+	switch arg := args[0]; arg.Kind {
+	case Boolean:
+		return arg, nil
+	case Null:
+		return BooleanValue(false), nil
+	case Integer:
+		return BooleanValue(arg.Int != 0), nil
+	case Number:
+		return BooleanValue(arg.Num != 0), nil
+	case Decimal:
+		return BooleanValue(arg.Dec.Sign() != 0), nil
+	case List:
+		return BooleanValue(len(arg.List.Items) > 0), nil
+	case Record:
+		return BooleanValue(len(arg.Record.Fields) > 0), nil
+	case String:
+		switch strings.ToLower(strings.TrimSpace(arg.Str)) {
+		case "true", "yes", "1":
+			return BooleanValue(true), nil
+		case "false", "no", "0", "":
+			return BooleanValue(false), nil
+		}
+		return Value{}, fmt.Errorf("to_boolean cannot convert %q to a boolean", arg.Str)
+	default:
+		return Value{}, fmt.Errorf("to_boolean cannot convert %v to a boolean", arg.Kind)
+	}
+}
@@ -0,0 +1,99 @@
+// runner/decimal.go
+
+package runner
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// repeatingDigits is how many fractional digits are shown for a decimal
+// whose expansion never terminates, such as 1/3.
+const repeatingDigits = 16
+
+// DecimalValue creates an exact decimal Value. The Value keeps its own copy of d.
+func DecimalValue(d *big.Rat) Value {
+	return Value{Kind: Decimal, Dec: new(big.Rat).Set(d)}
+}
+
+// isNumeric reports whether a value is an integer, a number or a decimal.
+func isNumeric(v Value) bool {
+	return v.Kind == Integer || v.Kind == Number || v.Kind == Decimal
+}
+
+// toRat converts a numeric value to a rational. A number converts from its
+// shortest printed form, so 0.1 becomes exactly 1/10. Numbers are finite:
+// arithmetic and built-ins report an error rather than produce infinity or NaN.
+func toRat(v Value) *big.Rat {
+	switch v.Kind {
+	case Decimal:
+		return v.Dec
+	case Integer:
+		return new(big.Rat).SetInt64(v.Int)
+	}
+
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(v.Num, 'g', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+// ratValue turns an exact result into an integer when it is a whole number
+// that fits in one, and into a decimal otherwise.
+func ratValue(r *big.Rat) Value {
+	if r.IsInt() && r.Num().IsInt64() {
+		return IntegerValue(r.Num().Int64())
+	}
+	return Value{Kind: Decimal, Dec: r}
+}
+
+// formatDecimal renders a decimal with exactly as many fractional digits as it
+// needs, falling back to repeatingDigits when the expansion does not terminate.
+func formatDecimal(d *big.Rat) string {
+	if d.IsInt() {
+		return d.FloatString(0)
+	}
+
+	digits, terminates := fractionalDigits(d.Denom())
+	if terminates {
+		return d.FloatString(digits)
+	}
+
+	text := d.FloatString(repeatingDigits)
+	return strings.TrimRight(strings.TrimRight(text, "0"), ".")
+}
+
+// fractionalDigits returns how many decimal places 1/denominator needs and
+// whether that expansion terminates, which it does when the denominator has
+// no prime factors other than 2 and 5.
+func fractionalDigits(denominator *big.Int) (int, bool) {
+	rest := new(big.Int).Set(denominator)
+	two, five := big.NewInt(2), big.NewInt(5)
+	remainder := new(big.Int)
+
+	twos, fives := 0, 0
+	for {
+		quotient, m := new(big.Int).QuoRem(rest, two, remainder)
+		if m.Sign() != 0 {
+			break
+		}
+		rest, twos = quotient, twos+1
+	}
+	for {
+		quotient, m := new(big.Int).QuoRem(rest, five, remainder)
+		if m.Sign() != 0 {
+			break
+		}
+		rest, fives = quotient, fives+1
+	}
+
+	if rest.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
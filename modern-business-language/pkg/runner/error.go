@@ -0,0 +1,90 @@
+// runner/error.go
+
+package runner
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+)
+
+// RuntimeError describes a failure while executing a program, positioned at
+// the token that caused it. Cause holds the underlying error, if any, such as
+// the error returned by a built-in function.
+type RuntimeError struct {
+	Message string
+	Line    int
+	Column  int
+	Cause   error
+}
+
+// Error renders the error with its line and column.
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("runtime error at line %d col %d: %s", e.Line, e.Column, e.Message)
+}
+
+// Unwrap returns the underlying cause so errors.Is and errors.As can see it.
+func (e *RuntimeError) Unwrap() error {
+	return e.Cause
+}
+
+// AssertionError is the Cause of the RuntimeError raised when a call to the
+// assert built-in fails. Message is the message passed to assert, if any.
+type AssertionError struct {
+	Message string
+}
+
+// Error renders the error with its message.
+func (e *AssertionError) Error() string {
+	if e.Message == "" {
+		return "assertion failed"
+	}
+	return "assertion failed: " + e.Message
+}
+
+// PanicError is the Cause of the RuntimeError returned in place of a Go panic
+// raised while a statement ran, whether by a bug in the runner or by a
+// registered built-in. Value is the value passed to panic and Stack the Go
+// stack trace at the point of the panic, to help track the bug down.
+type PanicError struct {
+	Value interface{}
+	Stack string
+}
+
+// Error renders the error with the panic value.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("internal error: %v", e.Value)
+}
+
+// Helper function to turn a panic raised while running the statement starting
+// at token into a RuntimeError stored in err. It must be deferred directly.
+// A Go stack overflow is fatal rather than a panic and cannot be caught here;
+// MaxCallDepth and the guards against nesting and cyclic values keep scripts
+// from causing one.
+func recoverPanic(token lexer.Token, err *error) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	*err = wrapAt(token, &PanicError{Value: value, Stack: string(debug.Stack())})
+}
+
+// Helper function to build a RuntimeError positioned at the given token.
+func errorAt(token lexer.Token, format string, args ...interface{}) error {
+	return &RuntimeError{
+		Message: fmt.Sprintf(format, args...),
+		Line:    token.Line,
+		Column:  token.Column,
+	}
+}
+
+// Helper function to wrap an error in a RuntimeError positioned at the given token.
+func wrapAt(token lexer.Token, cause error) error {
+	return &RuntimeError{
+		Message: cause.Error(),
+		Line:    token.Line,
+		Column:  token.Column,
+		Cause:   cause,
+	}
+}
@@ -0,0 +1,346 @@
+// runner/evaluate.go
+
+package runner
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/Solifugus/mbl/pkg/placer"
+)
+
+// evaluateNodes parses the nodes of a statement as an expression and evaluates it.
+func (r *Runner) evaluateNodes(nodes []*placer.Node) (Value, error) {
+	expression, err := r.parseExpression(nodes)
+	if err != nil {
+		return Value{}, err
+	}
+	return r.evaluate(expression)
+}
+
+// expressionKey identifies a run of statement nodes parsed as an expression.
+// A statement hands the runner the same nodes in the same order each time it
+// runs, so its first and last node and their count pin the run down.
+type expressionKey struct {
+	first, last *placer.Node
+	count       int
+}
+
+// parseExpression parses nodes like placer.ParseExpression, reusing the tree
+// built the first time the same nodes were parsed, so the statements of a
+// loop or function body are parsed once rather than every time they run.
+// Errors are not remembered. Only nodes of the program tree being executed
+// belong here; nodes placed while it runs would stay cached until it ends.
+func (r *Runner) parseExpression(nodes []*placer.Node) (*placer.Node, error) {
+	if len(nodes) == 0 {
+		return placer.ParseExpression(nodes)
+	}
+
+	key := expressionKey{first: nodes[0], last: nodes[len(nodes)-1], count: len(nodes)}
+	if expression, ok := r.expressions[key]; ok {
+		return expression, nil
+	}
+
+	expression, err := placer.ParseExpression(nodes)
+	if err != nil {
+		return nil, err
+	}
+	r.expressions[key] = expression
+	return expression, nil
+}
+
+// evaluate computes the value of an expression tree.
+func (r *Runner) evaluate(node *placer.Node) (Value, error) {
+	switch node.Type {
+	case placer.Leaf:
+		return r.executeToken(node.Token)
+	case placer.UnaryExpr:
+		return r.evaluateUnary(node)
+	case placer.BinaryExpr:
+		if node.Value == "&&" || node.Value == "||" {
+			return r.evaluateLogical(node)
+		}
+		return r.evaluateBinary(node)
+	case placer.CallExpr:
+		return r.evaluateCall(node)
+	case placer.ListExpr:
+		return r.evaluateList(node)
+	case placer.IndexExpr:
+		return r.evaluateIndex(node)
+	case placer.RecordExpr:
+		return r.evaluateRecord(node)
+	case placer.MemberExpr:
+		return r.evaluateMember(node)
+	case placer.ConditionalExpr:
+		return r.evaluateConditional(node)
+	case placer.LambdaExpr:
+		return r.evaluateLambda(node)
+	default:
+		return Value{}, errorAt(node.Token, "cannot evaluate %v node", node.Type)
+	}
+}
+
+// evaluateUnary applies a prefix operator, - or !, to its operand.
+func (r *Runner) evaluateUnary(node *placer.Node) (Value, error) {
+	operand, err := r.evaluate(node.Children[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	if node.Value == "!" {
+		if operand.Kind != Boolean {
+			return Value{}, errorAt(node.Token, "cannot apply %s to %v", node.Value, operand.Kind)
+		}
+		return BooleanValue(!operand.Bool), nil
+	}
+
+	if !isNumeric(operand) {
+		return Value{}, errorAt(node.Token, "cannot apply %s to %v", node.Value, operand.Kind)
+	}
+	return negate(operand), nil
+}
+
+// negate returns the negation of a numeric value. The negation of the
+// smallest integer does not fit in an integer, so it becomes a decimal.
+func negate(v Value) Value {
+	switch v.Kind {
+	case Integer:
+		if v.Int == math.MinInt64 {
+			return ratValue(new(big.Rat).Neg(toRat(v)))
+		}
+		return IntegerValue(-v.Int)
+	case Number:
+		return NumberValue(-v.Num)
+	default:
+		return Value{Kind: Decimal, Dec: new(big.Rat).Neg(v.Dec)}
+	}
+}
+
+// evaluateLogical applies && or ||, evaluating the right operand only when the
+// left one does not already decide the result.
+func (r *Runner) evaluateLogical(node *placer.Node) (Value, error) {
+	left, err := r.evaluateOperand(node, node.Children[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	if (node.Value == "&&" && !left) || (node.Value == "||" && left) {
+		return BooleanValue(left), nil
+	}
+
+	right, err := r.evaluateOperand(node, node.Children[1])
+	if err != nil {
+		return Value{}, err
+	}
+	return BooleanValue(right), nil
+}
+
+// evaluateOperand evaluates one side of a logical operator, which must be a boolean.
+func (r *Runner) evaluateOperand(operator, operand *placer.Node) (bool, error) {
+	value, err := r.evaluate(operand)
+	if err != nil {
+		return false, err
+	}
+
+	if value.Kind != Boolean {
+		return false, errorAt(operator.Token, "%s operand must be a boolean, got %v", operator.Value, value.Kind)
+	}
+	return value.Bool, nil
+}
+
+// evaluateConditional evaluates cond ? a : b. The condition must be a boolean,
+// and only the branch it selects is evaluated.
+func (r *Runner) evaluateConditional(node *placer.Node) (Value, error) {
+	condition, err := r.evaluate(node.Children[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	if condition.Kind != Boolean {
+		return Value{}, errorAt(node.Token, "%s condition must be a boolean, got %v", node.Value, condition.Kind)
+	}
+
+	if condition.Bool {
+		return r.evaluate(node.Children[1])
+	}
+	return r.evaluate(node.Children[2])
+}
+
+// evaluateBinary applies an arithmetic, text or comparison operator to its two operands.
+// Two integers give an integer, except that / gives a decimal when the
+// division is not exact, so 6 / 3 is 2 but 5 / 2 is 2.5; // always divides
+// down to a whole number, so 5 // 2 is 2. An integer mixed with a decimal is
+// promoted to a decimal and one mixed with a floating point number to floating
+// point, while a decimal mixed with a floating point number stays exact.
+// Null stands for a missing value: it may be compared with == and !=, where it
+// equals only null, but any other operator applied to it is an error, so that
+// null + 1 and "Total: " + null fail instead of producing a made-up result.
+func (r *Runner) evaluateBinary(node *placer.Node) (Value, error) {
+	left, err := r.evaluate(node.Children[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	right, err := r.evaluate(node.Children[1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	if comparisons[node.Value] {
+		return evaluateComparison(node, left, right)
+	}
+
+	if left.Kind == Null || right.Kind == Null {
+		return Value{}, errorAt(node.Token, "cannot apply %s to %v and %v", node.Value, left.Kind, right.Kind)
+	}
+
+	if left.Kind == String || right.Kind == String {
+		return evaluateText(node, left, right)
+	}
+
+	if !isNumeric(left) || !isNumeric(right) {
+		return Value{}, errorAt(node.Token, "cannot apply %s to %v and %v", node.Value, left.Kind, right.Kind)
+	}
+
+	if node.Value == "//" {
+		return evaluateFloorDivision(node, toRat(left), toRat(right))
+	}
+
+	if left.Kind == Integer && right.Kind == Integer {
+		return evaluateInteger(node, left.Int, right.Int)
+	}
+
+	if left.Kind == Decimal || right.Kind == Decimal {
+		return evaluateDecimal(node, toRat(left), toRat(right))
+	}
+
+	a, b := toFloat(left), toFloat(right)
+	var result float64
+	switch node.Value {
+	case "+":
+		result = a + b
+	case "-":
+		result = a - b
+	case "*":
+		result = a * b
+	case "/":
+		if b == 0 {
+			return Value{}, errorAt(node.Token, "division by zero")
+		}
+		result = a / b
+	case "%":
+		if b == 0 {
+			return Value{}, errorAt(node.Token, "modulo by zero")
+		}
+		result = floorMod(a, b)
+	default:
+		return Value{}, errorAt(node.Token, "unknown operator %s", node.Value)
+	}
+
+	// An infinite result would compare and convert as if it were zero, so
+	// overflowing the range of floating point is an error.
+	if !isFinite(result) {
+		return Value{}, errorAt(node.Token, "result of %s is too large for a number", node.Value)
+	}
+	return NumberValue(result), nil
+}
+
+// evaluateInteger applies an operator to two integers. Sums, differences and
+// products too large for an integer become decimals rather than wrapping.
+func evaluateInteger(node *placer.Node, a, b int64) (Value, error) {
+	switch node.Value {
+	case "+":
+		if sum := a + b; (sum > a) == (b > 0) {
+			return IntegerValue(sum), nil
+		}
+	case "-":
+		if difference := a - b; (difference < a) == (b > 0) {
+			return IntegerValue(difference), nil
+		}
+	case "*":
+		if product := a * b; a == 0 || (product/a == b && !(a == -1 && b == math.MinInt64)) {
+			return IntegerValue(product), nil
+		}
+	}
+
+	result, err := evaluateDecimal(node, new(big.Rat).SetInt64(a), new(big.Rat).SetInt64(b))
+	if err != nil {
+		return Value{}, err
+	}
+	return ratValue(result.Dec), nil
+}
+
+// evaluateFloorDivision applies //, dividing and rounding down to a whole
+// number, so 7 // 2 is 3 and -7 // 2 is -4. Together with %, a is always
+// (a // b) * b + a % b.
+func evaluateFloorDivision(node *placer.Node, left, right *big.Rat) (Value, error) {
+	if right.Sign() == 0 {
+		return Value{}, errorAt(node.Token, "division by zero")
+	}
+	return ratValue(floorRat(new(big.Rat).Quo(left, right))), nil
+}
+
+// toFloat converts an integer or a number to floating point.
+func toFloat(v Value) float64 {
+	if v.Kind == Integer {
+		return float64(v.Int)
+	}
+	return v.Num
+}
+
+// isFinite reports whether a floating point number is neither infinite nor NaN.
+func isFinite(n float64) bool {
+	return !math.IsInf(n, 0) && !math.IsNaN(n)
+}
+
+// floorMod returns the remainder of a divided by b, taking the sign of b, so
+// -7 % 3 is 2 and 7 % -3 is -2. This floored convention keeps a % n within
+// 0 to n-1 for any a, which suits counting every nth item.
+func floorMod(a, b float64) float64 {
+	m := math.Mod(a, b)
+	if m != 0 && (m < 0) != (b < 0) {
+		m += b
+	}
+	return m
+}
+
+// evaluateDecimal applies an operator exactly once either operand is a decimal.
+func evaluateDecimal(node *placer.Node, left, right *big.Rat) (Value, error) {
+	result := new(big.Rat)
+
+	switch node.Value {
+	case "+":
+		result.Add(left, right)
+	case "-":
+		result.Sub(left, right)
+	case "*":
+		result.Mul(left, right)
+	case "/":
+		if right.Sign() == 0 {
+			return Value{}, errorAt(node.Token, "division by zero")
+		}
+		result.Quo(left, right)
+	case "%":
+		if right.Sign() == 0 {
+			return Value{}, errorAt(node.Token, "modulo by zero")
+		}
+		// left - right * floor(left / right) has the sign of right.
+		quotient := floorRat(new(big.Rat).Quo(left, right))
+		result.Sub(left, quotient.Mul(quotient, right))
+	default:
+		return Value{}, errorAt(node.Token, "unknown operator %s", node.Value)
+	}
+
+	return Value{Kind: Decimal, Dec: result}, nil
+}
+
+// evaluateText applies an operator other than a comparison where at least one
+// operand is a string. Only + is supported: it concatenates, converting a
+// non-string operand other than null to its printed form, so "Total: " + 5
+// gives "Total: 5".
+func evaluateText(node *placer.Node, left, right Value) (Value, error) {
+	if node.Value != "+" {
+		return Value{}, errorAt(node.Token, "cannot apply %s to %v and %v", node.Value, left.Kind, right.Kind)
+	}
+	return StringValue(left.String() + right.String()), nil
+}
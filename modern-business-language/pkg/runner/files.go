@@ -0,0 +1,66 @@
+// runner/files.go
+
+package runner
+
+import (
+	"os"
+)
+
+// FileSystem is the storage that read_file and write_file work against.
+// Embedders can supply their own to sandbox scripts or keep files in memory.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+}
+
+// OSFileSystem reads and writes files on the local disk. It is the default.
+type OSFileSystem struct{}
+
+// ReadFile returns the contents of the named file.
+func (OSFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// WriteFile replaces the contents of the named file, creating it if needed.
+func (OSFileSystem) WriteFile(name string, data []byte) error {
+	return os.WriteFile(name, data, 0644)
+}
+
+// SetFileSystem makes read_file and write_file use fs instead of the local disk.
+func (r *Runner) SetFileSystem(fs FileSystem) {
+	r.files = fs
+}
+
+// builtinReadFile returns the contents of the file at path as a string.
+func (r *Runner) builtinReadFile(args []Value) (Value, error) {
+	if err := expectArgs("read_file", args, 1); err != nil {
+		return Value{}, err
+	}
+	path, err := expectString("read_file", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	data, err := r.files.ReadFile(path)
+	if err != nil {
+		return Value{}, err
+	}
+	return StringValue(string(data)), nil
+}
+
+// builtinWriteFile replaces the contents of the file at path with a string.
+func (r *Runner) builtinWriteFile(args []Value) (Value, error) {
+	if err := expectArgs("write_file", args, 2); err != nil {
+		return Value{}, err
+	}
+	path, err := expectString("write_file", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	content, err := expectString("write_file", args[1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{}, r.files.WriteFile(path, []byte(content))
+}
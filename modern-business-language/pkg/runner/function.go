@@ -0,0 +1,260 @@
+// runner/function.go
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+	"github.com/Solifugus/mbl/pkg/placer"
+)
+
+// returnSignal unwinds execution from a return statement to the call it ends.
+type returnSignal struct {
+	value Value
+}
+
+func (s *returnSignal) Error() string {
+	return "return outside of a function"
+}
+
+// executeFunction defines "function name(a, b) { ... }" in the current scope
+// from a flat statement. The placer turns well-formed definitions into
+// FunctionDef nodes, so this mostly reports what is wrong with the rest.
+func (r *Runner) executeFunction(nodes []*placer.Node) error {
+	keyword := nodes[0]
+	if len(nodes) < 2 || nodes[1].Type != placer.Leaf || nodes[1].Token.Type != lexer.Alphanumeric {
+		return errorAt(keyword.Token, "expected a function name")
+	}
+	name := nodes[1]
+
+	if len(nodes) < 3 || !isSymbol(nodes[2], "(") {
+		return errorAt(name.Token, "expected '(' after function %s", name.Value)
+	}
+
+	params, rest, err := parseParameters(nodes[3:], name)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 1 || rest[0].Type != placer.Block {
+		return errorAt(name.Token, "expected a block after function %s", name.Value)
+	}
+
+	return r.bindFunction(name, params, rest[0])
+}
+
+// defineFunction binds the function a FunctionDef node from the placer
+// describes, the same way executeFunction does for a flat definition. Reaching
+// a definition that was hoisted leaves the function bound by the hoisting in
+// place, unless its name has been assigned something else since.
+func (r *Runner) defineFunction(definition *placer.Node) error {
+	name, parameters, body := definition.Children[0], definition.Children[1], definition.Children[2]
+
+	if bound, ok := r.scope.lookup(name.Value); ok && bound.Kind == Function &&
+		bound.Func.Body == body && bound.Func.closure == r.scope {
+		return nil
+	}
+
+	params := make([]string, len(parameters.Children))
+	for i, param := range parameters.Children {
+		params[i] = param.Value
+	}
+	return r.bindFunction(name, params, body)
+}
+
+// bindFunction binds name to a function in the current scope, which the
+// function keeps as its closure.
+func (r *Runner) bindFunction(name *placer.Node, params []string, body *placer.Node) error {
+	if r.scope.isConstant(name.Value) {
+		return errorAt(name.Token, "cannot assign to constant %s", name.Value)
+	}
+
+	function := &FunctionValue{Name: name.Value, Params: params, Body: body, closure: r.scope}
+	r.scope.set(name.Value, Value{Kind: Function, Func: function})
+	return nil
+}
+
+// executeReturn ends the innermost function call with the value of "return expr",
+// or null when no value is given.
+func (r *Runner) executeReturn(nodes []*placer.Node) error {
+	if r.depth == 0 {
+		return errorAt(nodes[0].Token, "return outside of a function")
+	}
+
+	var value Value
+	if len(nodes) > 1 {
+		var err error
+		value, err = r.evaluateNodes(nodes[1:])
+		if err != nil {
+			return err
+		}
+	}
+	return &returnSignal{value: value}
+}
+
+// evaluateCall calls a function value with its arguments bound positionally
+// in a fresh scope whose parent is the scope the function was defined in.
+// Names are looked up through that chain. An assignment updates a variable
+// captured from an enclosing function but binds a new local in place of a
+// global, so a closure can keep state in the variables it captured. A
+// function that ends without return yields null.
+func (r *Runner) evaluateCall(node *placer.Node) (Value, error) {
+	callee, err := r.evaluate(node.Children[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	if callee.Kind != Function {
+		return Value{}, errorAt(node.Token, "cannot call %v", callee.Kind)
+	}
+	function := callee.Func
+
+	arguments := node.Children[1:]
+	if function.Builtin != nil {
+		return r.callBuiltin(node, function, arguments)
+	}
+
+	if len(arguments) != len(function.Params) {
+		return Value{}, errorAt(node.Token, "function %s expects %d arguments, got %d",
+			function.Name, len(function.Params), len(arguments))
+	}
+
+	values := make([]Value, len(arguments))
+	for i, argument := range arguments {
+		value, err := r.evaluate(argument)
+		if err != nil {
+			return Value{}, err
+		}
+		values[i] = value
+	}
+	if err := r.checkCallDepth(); err != nil {
+		return Value{}, wrapAt(node.Token, err)
+	}
+	return r.invoke(function, values)
+}
+
+// evaluateLambda makes a function value of a lambda, closing over the scope it
+// is evaluated in the way a function definition does.
+func (r *Runner) evaluateLambda(node *placer.Node) (Value, error) {
+	parameters, body := node.Children[0], node.Children[1]
+
+	params := make([]string, len(parameters.Children))
+	for i, param := range parameters.Children {
+		params[i] = param.Value
+	}
+	function := &FunctionValue{Name: "lambda", Params: params, Body: body, closure: r.scope}
+	return Value{Kind: Function, Func: function}, nil
+}
+
+// callFunction calls a function value with arguments already evaluated, for
+// built-ins such as map that take a function. An error in the arguments is
+// returned without a position, for the built-in's caller to place.
+func (r *Runner) callFunction(function *FunctionValue, args []Value) (Value, error) {
+	if function.Builtin != nil {
+		return function.Builtin(args)
+	}
+	if len(args) != len(function.Params) {
+		return Value{}, fmt.Errorf("function %s expects %d arguments, got %d",
+			function.Name, len(function.Params), len(args))
+	}
+	if err := r.checkCallDepth(); err != nil {
+		return Value{}, err
+	}
+	return r.invoke(function, args)
+}
+
+// checkCallDepth reports an error when another call would nest function calls
+// deeper than MaxCallDepth.
+func (r *Runner) checkCallDepth() error {
+	if r.MaxCallDepth > 0 && r.depth >= r.MaxCallDepth {
+		return fmt.Errorf("call depth exceeded %d", r.MaxCallDepth)
+	}
+	return nil
+}
+
+// invoke runs the body of a function defined in MBL code with its parameters
+// bound to the given arguments and returns the value it returns, or for a
+// lambda evaluates its expression.
+func (r *Runner) invoke(function *FunctionValue, args []Value) (Value, error) {
+	local := newScope(function.closure)
+	for i, value := range args {
+		local.declare(function.Params[i], value)
+	}
+
+	// A function body is outside of any loop its caller is running.
+	caller, callerLoops := r.scope, r.loops
+	r.scope = local
+	r.depth++
+	r.loops = 0
+	defer func() {
+		r.scope = caller
+		r.depth--
+		r.loops = callerLoops
+	}()
+
+	if function.Body.Type != placer.Block {
+		return r.evaluate(function.Body)
+	}
+	err := r.executeStatements(function.Body)
+
+	var signal *returnSignal
+	if errors.As(err, &signal) {
+		return signal.value, nil
+	}
+	return Value{}, err
+}
+
+// callBuiltin evaluates the arguments of a call and hands them to a built-in.
+func (r *Runner) callBuiltin(node *placer.Node, function *FunctionValue, arguments []*placer.Node) (Value, error) {
+	values := make([]Value, len(arguments))
+	for i, argument := range arguments {
+		value, err := r.evaluate(argument)
+		if err != nil {
+			return Value{}, err
+		}
+		values[i] = value
+	}
+
+	result, err := function.Builtin(values)
+	if err != nil {
+		// An error raised by a function the built-in called is already positioned.
+		var runtimeErr *RuntimeError
+		if errors.As(err, &runtimeErr) {
+			return Value{}, err
+		}
+		return Value{}, wrapAt(node.Token, err)
+	}
+	return result, nil
+}
+
+// parseParameters reads "a, b)" from a function definition, returning the
+// parameter names and the nodes after the closing parenthesis.
+func parseParameters(nodes []*placer.Node, name *placer.Node) ([]string, []*placer.Node, error) {
+	params := []string{}
+	for i := 0; i < len(nodes); i++ {
+		if len(params) == 0 && isSymbol(nodes[i], ")") {
+			return params, nodes[i+1:], nil
+		}
+
+		param := nodes[i]
+		if param.Type != placer.Leaf || param.Token.Type != lexer.Alphanumeric {
+			return nil, nil, errorAt(param.Token, "expected a parameter name in function %s", name.Value)
+		}
+		params = append(params, param.Value)
+
+		i++
+		if i == len(nodes) {
+			break
+		}
+		if isSymbol(nodes[i], ")") {
+			return params, nodes[i+1:], nil
+		}
+		if !isSymbol(nodes[i], ",") {
+			return nil, nil, errorAt(nodes[i].Token, "expected ',' or ')' in function %s", name.Value)
+		}
+	}
+
+	return nil, nil, errorAt(name.Token, "missing ')' in function %s", name.Value)
+}
@@ -0,0 +1,34 @@
+// runner/input.go
+
+package runner
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// SetInput makes readline read from rd instead of os.Stdin.
+func (r *Runner) SetInput(rd io.Reader) {
+	r.input = bufio.NewReader(rd)
+}
+
+// builtinReadline returns the next line of input without its line ending,
+// or null once the input is exhausted.
+func (r *Runner) builtinReadline(args []Value) (Value, error) {
+	if err := expectArgs("readline", args, 0); err != nil {
+		return Value{}, err
+	}
+
+	line, err := r.input.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return Value{}, nil
+	}
+	if err != nil && err != io.EOF {
+		return Value{}, err
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return StringValue(line), nil
+}
@@ -0,0 +1,124 @@
+// runner/list.go
+
+package runner
+
+import (
+	"github.com/Solifugus/mbl/pkg/placer"
+)
+
+// evaluateList builds a list from the items of a [a, b, c] literal.
+func (r *Runner) evaluateList(node *placer.Node) (Value, error) {
+	items := make([]Value, len(node.Children))
+	for i, child := range node.Children {
+		value, err := r.evaluate(child)
+		if err != nil {
+			return Value{}, err
+		}
+		items[i] = value
+	}
+	return NewList(items...), nil
+}
+
+// evaluateIndex reads list[i], where i counts from zero and must be in range,
+// or rec["key"], which is null when the record has no such field.
+func (r *Runner) evaluateIndex(node *placer.Node) (Value, error) {
+	target, err := r.evaluate(node.Children[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	index, err := r.evaluate(node.Children[1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch target.Kind {
+	case List:
+		i, err := listIndex(node, target, index)
+		if err != nil {
+			return Value{}, err
+		}
+		return target.List.Items[i], nil
+	case Record:
+		if index.Kind != String {
+			return Value{}, errorAt(node.Token, "record key must be a string, got %v", index.Kind)
+		}
+		return target.Record.Fields[index.Str], nil
+	default:
+		return Value{}, errorAt(node.Token, "cannot index %v", target.Kind)
+	}
+}
+
+// assignElement stores a value in the record field or list item named by a
+// MemberExpr or IndexExpr target. Assigning to a missing field creates it.
+func (r *Runner) assignElement(target *placer.Node, value Value) error {
+	container, err := r.evaluate(target.Children[0])
+	if err != nil {
+		return err
+	}
+
+	if target.Type == placer.MemberExpr {
+		if container.Kind != Record {
+			return errorAt(target.Token, "cannot set field %s on %v", target.Value, container.Kind)
+		}
+		container.Record.Set(target.Value, value)
+		return nil
+	}
+
+	index, err := r.evaluate(target.Children[1])
+	if err != nil {
+		return err
+	}
+
+	switch container.Kind {
+	case List:
+		i, err := listIndex(target, container, index)
+		if err != nil {
+			return err
+		}
+		container.List.Items[i] = value
+		return nil
+	case Record:
+		if index.Kind != String {
+			return errorAt(target.Token, "record key must be a string, got %v", index.Kind)
+		}
+		container.Record.Set(index.Str, value)
+		return nil
+	default:
+		return errorAt(target.Token, "cannot index %v", container.Kind)
+	}
+}
+
+// listIndex checks that index names an item of list and returns it as an int.
+func listIndex(node *placer.Node, list, index Value) (int, error) {
+	i, ok := toIndex(index)
+	if !ok {
+		return 0, errorAt(node.Token, "list index must be a whole number, got %v", index)
+	}
+
+	items := list.List.Items
+	if i < 0 || i >= len(items) {
+		return 0, errorAt(node.Token, "index %d out of range for list of length %d", i, len(items))
+	}
+	return i, nil
+}
+
+// toIndex converts a numeric value holding a whole number to an int.
+func toIndex(v Value) (int, bool) {
+	switch v.Kind {
+	case Integer:
+		return int(v.Int), true
+	case Number:
+		if v.Num != float64(int(v.Num)) {
+			return 0, false
+		}
+		return int(v.Num), true
+	case Decimal:
+		if !v.Dec.IsInt() || !v.Dec.Num().IsInt64() {
+			return 0, false
+		}
+		return int(v.Dec.Num().Int64()), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,249 @@
+// runner/math.go
+
+package runner
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// maxExactExponent is the largest whole exponent pow computes exactly on decimals.
+const maxExactExponent = 1024
+
+// maxRoundDigits is the largest number of places round rounds to, either side
+// of the decimal point.
+const maxRoundDigits = 1000
+
+// mathBuiltins returns the built-in functions that work on integers, numbers and decimals.
+func mathBuiltins() map[string]Builtin {
+	return map[string]Builtin{
+		"abs":   builtinAbs,
+		"round": builtinRound,
+		"floor": builtinFloor,
+		"ceil":  builtinCeil,
+		"min":   builtinMin,
+		"max":   builtinMax,
+		"pow":   builtinPow,
+	}
+}
+
+// expectNumeric checks that an argument is an integer, a number or a decimal.
+func expectNumeric(name string, arg Value) error {
+	if !isNumeric(arg) {
+		return fmt.Errorf("%s expects a number, got %v", name, arg.Kind)
+	}
+	return nil
+}
+
+// builtinAbs returns the absolute value of its argument.
+func builtinAbs(args []Value) (Value, error) {
+	if err := expectArgs("abs", args, 1); err != nil {
+		return Value{}, err
+	}
+	if err := expectNumeric("abs", args[0]); err != nil {
+		return Value{}, err
+	}
+
+	switch args[0].Kind {
+	case Integer:
+		return ratValue(new(big.Rat).Abs(toRat(args[0]))), nil
+	case Decimal:
+		return Value{Kind: Decimal, Dec: new(big.Rat).Abs(args[0].Dec)}, nil
+	default:
+		return NumberValue(math.Abs(args[0].Num)), nil
+	}
+}
+
+// builtinRound rounds to a whole number, or to the given number of decimal
+// places, as in round(2.345, 2). Halves round away from zero ("half-up", as
+// on an invoice), so round(2.5) is 3 and round(-2.5) is -3; this is not
+// banker's rounding. Rounding to a whole number gives an integer; otherwise
+// the result has the same kind as the value rounded. At most maxRoundDigits
+// places are allowed either side of the point.
+func builtinRound(args []Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{}, fmt.Errorf("round expects 1 or 2 arguments, got %d", len(args))
+	}
+	if err := expectNumeric("round", args[0]); err != nil {
+		return Value{}, err
+	}
+
+	digits := 0
+	if len(args) == 2 {
+		var ok bool
+		digits, ok = toIndex(args[1])
+		if !ok {
+			return Value{}, fmt.Errorf("round expects a whole number of digits, got %v", args[1])
+		}
+		if digits < -maxRoundDigits || digits > maxRoundDigits {
+			return Value{}, fmt.Errorf("round expects at most %d digits, got %v", maxRoundDigits, args[1])
+		}
+	}
+
+	rounded := roundRat(toRat(args[0]), digits)
+	if digits <= 0 {
+		return wholeValue(args[0], rounded), nil
+	}
+	return sameKind(args[0], rounded), nil
+}
+
+// builtinFloor returns the largest whole number not greater than its argument, as an integer.
+func builtinFloor(args []Value) (Value, error) {
+	if err := expectArgs("floor", args, 1); err != nil {
+		return Value{}, err
+	}
+	if err := expectNumeric("floor", args[0]); err != nil {
+		return Value{}, err
+	}
+
+	if args[0].Kind == Number {
+		return wholeValue(args[0], toRat(NumberValue(math.Floor(args[0].Num)))), nil
+	}
+	return wholeValue(args[0], floorRat(toRat(args[0]))), nil
+}
+
+// builtinCeil returns the smallest whole number not less than its argument, as an integer.
+func builtinCeil(args []Value) (Value, error) {
+	if err := expectArgs("ceil", args, 1); err != nil {
+		return Value{}, err
+	}
+	if err := expectNumeric("ceil", args[0]); err != nil {
+		return Value{}, err
+	}
+
+	if args[0].Kind == Number {
+		return wholeValue(args[0], toRat(NumberValue(math.Ceil(args[0].Num)))), nil
+	}
+	negated := floorRat(new(big.Rat).Neg(toRat(args[0])))
+	return wholeValue(args[0], negated.Neg(negated)), nil
+}
+
+// builtinMin returns the smallest of one or more numeric arguments.
+func builtinMin(args []Value) (Value, error) {
+	return extreme("min", args, -1)
+}
+
+// builtinMax returns the largest of one or more numeric arguments.
+func builtinMax(args []Value) (Value, error) {
+	return extreme("max", args, 1)
+}
+
+// extreme returns the argument that sorts furthest in the given direction.
+func extreme(name string, args []Value, direction int) (Value, error) {
+	if len(args) == 0 {
+		return Value{}, fmt.Errorf("%s expects at least 1 argument, got 0", name)
+	}
+
+	best := args[0]
+	for _, arg := range args {
+		if err := expectNumeric(name, arg); err != nil {
+			return Value{}, err
+		}
+
+		order, _ := compareOrder(arg, best)
+		if order == direction {
+			best = arg
+		}
+	}
+	return best, nil
+}
+
+// builtinPow raises base to the power exp. An integer or decimal base with a
+// whole exponent is computed exactly, giving an integer when both the base and
+// result are whole; other combinations use floating point.
+func builtinPow(args []Value) (Value, error) {
+	if err := expectArgs("pow", args, 2); err != nil {
+		return Value{}, err
+	}
+	for _, arg := range args {
+		if err := expectNumeric("pow", arg); err != nil {
+			return Value{}, err
+		}
+	}
+
+	base, exp := args[0], args[1]
+	n, whole := toIndex(exp)
+	exact := base.Kind == Integer || base.Kind == Decimal
+	if exact && exp.Kind != Number && whole && n >= -maxExactExponent && n <= maxExactExponent {
+		b := toRat(base)
+		if b.Sign() == 0 && n < 0 {
+			return Value{}, fmt.Errorf("pow of zero to a negative exponent")
+		}
+
+		result := big.NewRat(1, 1)
+		for i := 0; i < absInt(n); i++ {
+			result.Mul(result, b)
+		}
+		if n < 0 {
+			result.Inv(result)
+		}
+		if base.Kind == Integer {
+			return ratValue(result), nil
+		}
+		return Value{Kind: Decimal, Dec: result}, nil
+	}
+
+	f, _ := toRat(base).Float64()
+	e, _ := toRat(exp).Float64()
+	result := math.Pow(f, e)
+	if !isFinite(result) {
+		return Value{}, fmt.Errorf("pow of %v to %v is not a finite number", base, exp)
+	}
+	return NumberValue(result), nil
+}
+
+// roundRat rounds r to the given number of decimal places, taking halves away
+// from zero. Negative digits round to tens, hundreds and so on.
+func roundRat(r *big.Rat, digits int) *big.Rat {
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(absInt(digits))), nil))
+	if digits < 0 {
+		scale.Inv(scale)
+	}
+
+	scaled := new(big.Rat).Mul(r, scale)
+	negative := scaled.Sign() < 0
+	rounded := floorRat(scaled.Add(scaled.Abs(scaled), big.NewRat(1, 2)))
+	if negative {
+		rounded.Neg(rounded)
+	}
+	return rounded.Quo(rounded, scale)
+}
+
+// floorRat returns the largest whole rational not greater than r.
+func floorRat(r *big.Rat) *big.Rat {
+	// The denominator of a big.Rat is always positive, so Euclidean division
+	// rounds towards negative infinity.
+	quotient := new(big.Int).Div(r.Num(), r.Denom())
+	return new(big.Rat).SetInt(quotient)
+}
+
+// sameKind converts a rational result back to the kind of the original value.
+func sameKind(original Value, r *big.Rat) Value {
+	switch original.Kind {
+	case Integer:
+		return ratValue(r)
+	case Decimal:
+		return Value{Kind: Decimal, Dec: r}
+	default:
+		f, _ := r.Float64()
+		return NumberValue(f)
+	}
+}
+
+// wholeValue returns a whole rational result as an integer, falling back to
+// the kind of the original value when it is too large for one.
+func wholeValue(original Value, r *big.Rat) Value {
+	if r.Num().IsInt64() {
+		return IntegerValue(r.Num().Int64())
+	}
+	return sameKind(original, r)
+}
+
+// absInt returns the absolute value of n.
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
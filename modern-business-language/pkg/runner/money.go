@@ -0,0 +1,65 @@
+// runner/money.go
+
+package runner
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// moneyBuiltins returns the built-in functions for presenting currency amounts.
+func moneyBuiltins() map[string]Builtin {
+	return map[string]Builtin{
+		"format_money": builtinFormatMoney,
+	}
+}
+
+// builtinFormatMoney renders an amount with two decimal places and thousands
+// separators after the given currency symbol, so format_money(1234.5, "$") is
+// "$1,234.50". The amount is rounded exactly, halves away from zero, and a
+// negative amount puts its sign before the symbol: "-$5.00".
+func builtinFormatMoney(args []Value) (Value, error) {
+	if err := expectArgs("format_money", args, 2); err != nil {
+		return Value{}, err
+	}
+	if err := expectNumeric("format_money", args[0]); err != nil {
+		return Value{}, err
+	}
+	symbol, err := expectString("format_money", args[1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	return StringValue(formatMoney(toRat(args[0]), symbol)), nil
+}
+
+// formatMoney renders r to the cent with grouped thousands.
+func formatMoney(r *big.Rat, symbol string) string {
+	cents := new(big.Rat).Mul(roundRat(r, 2), big.NewRat(100, 1)).Num()
+
+	sign := ""
+	if cents.Sign() < 0 {
+		sign = "-"
+	}
+
+	digits := new(big.Int).Abs(cents).String()
+	if len(digits) < 3 {
+		digits = strings.Repeat("0", 3-len(digits)) + digits
+	}
+	whole, fraction := digits[:len(digits)-2], digits[len(digits)-2:]
+
+	return fmt.Sprintf("%s%s%s.%s", sign, symbol, groupThousands(whole), fraction)
+}
+
+// groupThousands inserts a comma between every three digits, counting from the right.
+func groupThousands(digits string) string {
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	return grouped.String()
+}
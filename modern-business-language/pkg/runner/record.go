@@ -0,0 +1,33 @@
+// runner/record.go
+
+package runner
+
+import (
+	"github.com/Solifugus/mbl/pkg/placer"
+)
+
+// evaluateRecord builds a record from the key and value pairs of a { key: value } literal.
+func (r *Runner) evaluateRecord(node *placer.Node) (Value, error) {
+	record := NewRecord()
+	for i := 0; i+1 < len(node.Children); i += 2 {
+		value, err := r.evaluate(node.Children[i+1])
+		if err != nil {
+			return Value{}, err
+		}
+		record.Record.Set(node.Children[i].Value, value)
+	}
+	return record, nil
+}
+
+// evaluateMember reads rec.key, which is null when the record has no such field.
+func (r *Runner) evaluateMember(node *placer.Node) (Value, error) {
+	target, err := r.evaluate(node.Children[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	if target.Kind != Record {
+		return Value{}, errorAt(node.Token, "cannot read field %s of %v", node.Value, target.Kind)
+	}
+	return target.Record.Fields[node.Value], nil
+}
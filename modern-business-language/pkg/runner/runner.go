@@ -3,26 +3,200 @@
 package runner
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+	"github.com/Solifugus/mbl/pkg/placer"
 )
 
+// DefaultMaxIterations is the number of iterations a single loop may run
+// before the Runner stops it.
+const DefaultMaxIterations = 1000000
+
+// DefaultMaxCallDepth is the number of function calls that may be in progress
+// at once before the Runner stops the program.
+const DefaultMaxCallDepth = 5000
+
 // Runner is responsible for executing functions at specified places in storage.
 type Runner struct {
-	// Add any necessary fields for maintaining the execution state.
+	// MaxIterations bounds how many times a single loop may repeat, guarding
+	// against runaway scripts. Zero or less removes the limit.
+	MaxIterations int
+
+	// MaxCallDepth bounds how deeply function calls may nest, so runaway
+	// recursion fails with a RuntimeError instead of overflowing the Go stack,
+	// which no recover can catch. Zero or less removes the limit.
+	MaxCallDepth int
+
+	ctx      context.Context
+	globals  *scope
+	scope    *scope
+	depth    int
+	loops    int
+	output   io.Writer
+	input    *bufio.Reader
+	files    FileSystem
+	builtins map[string]Builtin
+
+	// expressions caches the trees parseExpression built for the program
+	// being executed.
+	expressions map[expressionKey]*placer.Node
 }
 
 // NewRunner creates a new Runner instance.
 func NewRunner() *Runner {
-	return &Runner{}
+	globals := newScope(nil)
+	r := &Runner{
+		MaxIterations: DefaultMaxIterations,
+		MaxCallDepth:  DefaultMaxCallDepth,
+		ctx:           context.Background(),
+		globals:       globals,
+		scope:         globals,
+		output:        os.Stdout,
+		input:         bufio.NewReader(os.Stdin),
+		files:         OSFileSystem{},
+		builtins:      defaultBuiltins(),
+		expressions:   make(map[expressionKey]*placer.Node),
+	}
+	// These built-ins use the runner's input and file system, or call back
+	// into the runner, so they are bound to r.
+	r.builtins["readline"] = r.builtinReadline
+	r.builtins["read_file"] = r.builtinReadFile
+	r.builtins["write_file"] = r.builtinWriteFile
+	r.builtins["map"] = r.builtinMap
+	r.builtins["filter"] = r.builtinFilter
+	r.builtins["reduce"] = r.builtinReduce
+	return r
+}
+
+// SetOutput directs the output of print statements to w.
+func (r *Runner) SetOutput(w io.Writer) {
+	r.output = w
+}
+
+// Run places the tokens in brace mode and executes the resulting statements.
+func (r *Runner) Run(tokens []lexer.Token) error {
+	return r.RunContext(context.Background(), tokens)
+}
+
+// RunContext is like Run but stops with ctx.Err() once ctx is cancelled or
+// its deadline passes. Cancellation is checked before every statement and
+// every loop iteration, so a script cannot outlive the context that runs it.
+func (r *Runner) RunContext(ctx context.Context, tokens []lexer.Token) error {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err := p.PlaceTokens(tokens)
+	if err != nil {
+		return err
+	}
+
+	return r.ExecContext(ctx, p.Root())
+}
+
+// Exec executes the statements held by a placed node, in order.
+func (r *Runner) Exec(root *placer.Node) error {
+	return r.ExecContext(context.Background(), root)
+}
+
+// ExecContext is like Exec but stops with ctx.Err() once ctx is done.
+func (r *Runner) ExecContext(ctx context.Context, root *placer.Node) error {
+	defer r.withContext(ctx)()
+	r.expressions = make(map[expressionKey]*placer.Node)
+
+	if err := r.hoistFunctions(root); err != nil {
+		return err
+	}
+	return r.executeStatements(root)
 }
 
-// Run executes the functions at specified places in storage.
-func (r *Runner) Run(tokens []Token) error {
-	// Implement the logic to execute functions at specified places in storage.
-	// Iterate through the tokens and execute their associated functions.
+// hoistFunctions defines the functions of the FunctionDef nodes held directly
+// by root before any statement runs, so a program may call a function written
+// further down. Only top-level definitions are hoisted, and only functions:
+// variables and constants are still bound when their statement runs.
+func (r *Runner) hoistFunctions(root *placer.Node) error {
+	for _, child := range root.Children {
+		if child.Type != placer.FunctionDef {
+			continue
+		}
+		if err := r.defineFunction(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Eval executes the statements held by a placed node like Exec and returns the
+// value of the last statement when it is a bare expression such as "x + 1".
+// Otherwise, or when there are no statements, the result is null.
+// Variables persist between calls, which lets a REPL evaluate line by line.
+func (r *Runner) Eval(root *placer.Node) (Value, error) {
+	return r.EvalContext(context.Background(), root)
+}
+
+// EvalContext is like Eval but stops with ctx.Err() once ctx is done.
+func (r *Runner) EvalContext(ctx context.Context, root *placer.Node) (Value, error) {
+	defer r.withContext(ctx)()
+	r.expressions = make(map[expressionKey]*placer.Node)
+
+	if err := r.hoistFunctions(root); err != nil {
+		return Value{}, err
+	}
 
-	for _, token := range tokens {
-		err := r.executeToken(token)
+	statements := statementsOf(root)
+	if len(statements) == 0 {
+		return Value{}, nil
+	}
+
+	for _, nodes := range statements[:len(statements)-1] {
+		err := r.executeStatement(nodes)
+		if err != nil {
+			return Value{}, err
+		}
+	}
+
+	last := statements[len(statements)-1]
+	if err := r.ctx.Err(); err != nil {
+		return Value{}, err
+	}
+	if !isExpressionStatement(last) {
+		return Value{}, r.executeStatement(last)
+	}
+	return r.evaluateStatement(last)
+}
+
+// evaluateStatement evaluates a bare expression statement, recovering from a
+// Go panic the way executeStatement does.
+func (r *Runner) evaluateStatement(nodes []*placer.Node) (value Value, err error) {
+	defer recoverPanic(nodes[0].Token, &err)
+	return r.evaluateNodes(nodes)
+}
+
+// withContext makes ctx the context checked during execution and returns a
+// function that restores the previous one.
+func (r *Runner) withContext(ctx context.Context) func() {
+	previous := r.ctx
+	r.ctx = ctx
+	return func() { r.ctx = previous }
+}
+
+// Get returns the value stored in a global variable and whether it has been assigned.
+func (r *Runner) Get(name string) (Value, bool) {
+	return r.globals.lookup(name)
+}
+
+// executeStatements executes the statements held by a Root or Block node, in order.
+func (r *Runner) executeStatements(block *placer.Node) error {
+	for _, nodes := range statementsOf(block) {
+		err := r.executeStatement(nodes)
 		if err != nil {
 			return err
 		}
@@ -31,30 +205,389 @@ func (r *Runner) Run(tokens []Token) error {
 	return nil
 }
 
-// executeToken executes the function associated with a token.
-func (r *Runner) executeToken(token Token) error {
-	// Implement the logic to execute the function associated with the token.
-	// You may need to pass values and manage the execution flow.
+// statementsOf returns the significant nodes of each statement held by a Root or
+// Block node. An else statement on its own line continues the if statement before
+// it. BlankLines nodes kept for formatters are skipped, and a FunctionDef
+// node stands alone as the single node of its statement.
+func statementsOf(block *placer.Node) [][]*placer.Node {
+	var merged [][]*placer.Node
 
-	switch token.Type {
-	case Text:
-		// Handle Text token execution logic.
-	case Numeric:
-		// Handle Numeric token execution logic.
-	case Alphanumeric:
-		// Handle Alphanumeric token execution logic.
-	case NewLine:
-		// Handle NewLine token execution logic.
-	case Tab:
-		// Handle Tab token execution logic.
-	case Symbol:
-		// Handle Symbol token execution logic.
+	var statements []*placer.Node
+	for _, child := range block.Children {
+		if child.Type != placer.BlankLines {
+			statements = append(statements, child)
+		}
+	}
+	for i := 0; i < len(statements); i++ {
+		if statements[i].Type == placer.FunctionDef {
+			merged = append(merged, []*placer.Node{statements[i]})
+			continue
+		}
+
+		nodes := significant(statements[i].Children)
+		for len(nodes) > 0 && isKeyword(nodes[0], "if") && i+1 < len(statements) {
+			next := significant(statements[i+1].Children)
+			if len(next) == 0 || !isKeyword(next[0], "else") {
+				break
+			}
+			nodes = append(nodes, next...)
+			i++
+		}
+
+		merged = append(merged, nodes)
+	}
+
+	return merged
+}
+
+// executeStatement executes the significant nodes of a single statement. A Go
+// panic raised while it runs is returned as a RuntimeError positioned at the
+// statement, with a PanicError as its Cause, so a bug cannot bring down the
+// program embedding the runner.
+func (r *Runner) executeStatement(nodes []*placer.Node) (err error) {
+	if len(nodes) == 0 {
+		return nil
+	}
+	defer recoverPanic(nodes[0].Token, &err)
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	switch {
+	case nodes[0].Type == placer.FunctionDef:
+		return r.defineFunction(nodes[0])
+	case isKeyword(nodes[0], "const"):
+		return r.executeConst(nodes)
+	case indexOfSymbol(nodes, ":=") > 0:
+		return r.executeAssignment(nodes, indexOfSymbol(nodes, ":="))
+	case isName(nodes[0], "print"):
+		return r.executePrint(nodes)
+	case isKeyword(nodes[0], "if"):
+		return r.executeIf(nodes)
+	case isKeyword(nodes[0], "while"):
+		return r.executeWhile(nodes)
+	case isKeyword(nodes[0], "for"):
+		return r.executeFor(nodes)
+	case isKeyword(nodes[0], "switch"):
+		return r.executeSwitch(nodes)
+	case isKeyword(nodes[0], "case"), isKeyword(nodes[0], "default"):
+		return errorAt(nodes[0].Token, "%s outside of a switch", nodes[0].Value)
+	case isKeyword(nodes[0], "break"), isKeyword(nodes[0], "continue"):
+		return r.executeLoopControl(nodes)
+	case isKeyword(nodes[0], "function"):
+		return r.executeFunction(nodes)
+	case isKeyword(nodes[0], "return"):
+		return r.executeReturn(nodes)
 	default:
-		return fmt.Errorf("unknown token type: %v", token.Type)
+		return r.executeExpression(nodes)
 	}
+}
 
+// isExpressionStatement reports whether a statement is a bare expression rather
+// than one of the statement forms.
+func isExpressionStatement(nodes []*placer.Node) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+	if indexOfSymbol(nodes, ":=") > 0 {
+		return false
+	}
+	if isName(nodes[0], "print") {
+		return false
+	}
+	return nodes[0].Token.Type != lexer.Keyword || isKeyword(nodes[0], "fn")
+}
+
+// executeExpression runs a statement made of a single call, discarding its result.
+func (r *Runner) executeExpression(nodes []*placer.Node) error {
+	expression, err := r.parseExpression(nodes)
+	if err != nil {
+		return err
+	}
+	if expression.Type != placer.CallExpr {
+		return errorAt(nodes[0].Token, "unsupported statement")
+	}
+
+	_, err = r.evaluate(expression)
+	return err
+}
+
+// executeAssignment evaluates the right side of "target := expr" and stores it.
+// The target, made of the nodes before the := at position at, is a variable
+// name, a record field such as rec.key or rec["key"], or a list item list[i].
+func (r *Runner) executeAssignment(nodes []*placer.Node, at int) error {
+	operator := nodes[at]
+	if at == len(nodes)-1 {
+		return errorAt(operator.Token, "expected a value after :=")
+	}
+
+	value, err := r.evaluateNodes(nodes[at+1:])
+	if err != nil {
+		return err
+	}
+
+	if at == 1 && nodes[0].Type == placer.Leaf && nodes[0].Token.Type == lexer.Alphanumeric {
+		if r.scope.isConstant(nodes[0].Value) {
+			return errorAt(nodes[0].Token, "cannot assign to constant %s", nodes[0].Value)
+		}
+		r.scope.set(nodes[0].Value, value)
+		return nil
+	}
+
+	target, err := r.parseExpression(nodes[:at])
+	if err != nil {
+		return err
+	}
+
+	switch target.Type {
+	case placer.MemberExpr, placer.IndexExpr:
+		return r.assignElement(target, value)
+	default:
+		return errorAt(nodes[0].Token, "cannot assign to %q", nodes[0].Value)
+	}
+}
+
+// executeConst evaluates "const NAME := expr" and binds NAME for good: later
+// assignments to it, or a function defined with its name, are runtime errors.
+// Only the binding is fixed, so the fields of a constant record or the items of
+// a constant list can still change.
+func (r *Runner) executeConst(nodes []*placer.Node) error {
+	keyword := nodes[0]
+	if len(nodes) < 2 || nodes[1].Type != placer.Leaf || nodes[1].Token.Type != lexer.Alphanumeric {
+		return errorAt(keyword.Token, "expected a constant name after const")
+	}
+	name := nodes[1]
+
+	if len(nodes) < 3 || !isSymbol(nodes[2], ":=") {
+		return errorAt(name.Token, "expected := after const %s", name.Value)
+	}
+	if len(nodes) == 3 {
+		return errorAt(nodes[2].Token, "expected a value after :=")
+	}
+	if r.scope.isConstant(name.Value) {
+		return errorAt(name.Token, "cannot assign to constant %s", name.Value)
+	}
+
+	value, err := r.evaluateNodes(nodes[3:])
+	if err != nil {
+		return err
+	}
+
+	r.scope.setConstant(name.Value, value)
 	return nil
 }
 
-// Add any additional helper functions or methods as needed for executing functions in the storage.
+// executePrint writes the value of "print expr" followed by a newline.
+func (r *Runner) executePrint(nodes []*placer.Node) error {
+	if len(nodes) == 1 {
+		return errorAt(nodes[0].Token, "expected a value after print")
+	}
+
+	value, err := r.evaluateNodes(nodes[1:])
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(r.output, value.String())
+	return err
+}
+
+// executeToken evaluates a single token to the value it stands for.
+func (r *Runner) executeToken(token lexer.Token) (Value, error) {
+	switch token.Type {
+	case lexer.Text, lexer.Char:
+		return StringValue(token.Value), nil
+	case lexer.Interpolation:
+		return r.evaluateInterpolation(token)
+	case lexer.Numeric:
+		return parseNumeric(token)
+	case lexer.Boolean:
+		return BooleanValue(token.Value == "true"), nil
+	case lexer.Null:
+		return Value{}, nil
+	case lexer.Date:
+		date, err := time.Parse("2006-01-02", token.Value)
+		if err != nil {
+			return Value{}, errorAt(token, "invalid date %q", token.Value)
+		}
+		return Value{Kind: Date, Date: date}, nil
+	case lexer.Currency:
+		return parseCurrency(token)
+	case lexer.Alphanumeric:
+		value, ok := r.scope.lookup(token.Value)
+		if !ok {
+			value, ok = r.lookupBuiltin(token.Value)
+		}
+		if !ok {
+			return Value{}, errorAt(token, "undefined variable %q", token.Value)
+		}
+		return value, nil
+	default:
+		return Value{}, errorAt(token, "unknown token type %s", token.Type)
+	}
+}
+
+// parseCurrency converts a Currency token such as "$1234.56", whose value the
+// lexer gives without group separators, to a decimal that keeps its symbol.
+func parseCurrency(token lexer.Token) (Value, error) {
+	_, width := utf8.DecodeRuneInString(token.Value)
+	amount, ok := new(big.Rat).SetString(token.Value[width:])
+	if !ok {
+		return Value{}, errorAt(token, "invalid amount %q", token.Value)
+	}
+	return Value{Kind: Decimal, Dec: amount, Currency: token.Value[:width]}, nil
+}
+
+// numericScales maps each suffix a numeric literal may end in to the factor it
+// scales the literal by.
+var numericScales = map[byte]*big.Rat{
+	'k': big.NewRat(1000, 1),
+	'M': big.NewRat(1000000, 1),
+	'%': big.NewRat(1, 100),
+}
+
+// evaluateInterpolation evaluates text such as "total is {x}", evaluating each
+// expression in braces in the current scope and splicing in its printed form.
+func (r *Runner) evaluateInterpolation(token lexer.Token) (Value, error) {
+	segments, err := token.Segments()
+	if err != nil {
+		return Value{}, err
+	}
+
+	var text strings.Builder
+	for _, segment := range segments {
+		if segment.Tokens == nil {
+			text.WriteString(segment.Text)
+			continue
+		}
+
+		p := placer.NewPlacer()
+		p.Mode = placer.BraceMode
+		if err := p.PlaceTokens(segment.Tokens); err != nil {
+			return Value{}, err
+		}
+
+		statements := statementsOf(p.Root())
+		if len(statements) != 1 || len(statements[0]) == 0 {
+			return Value{}, errorAt(segment.Tokens[0], "expected a single expression in braces")
+		}
+
+		// The segment's nodes are placed afresh on every evaluation, so they
+		// bypass r.parseExpression: caching them would keep each one alive
+		// until the run ends.
+		expression, err := placer.ParseExpression(statements[0])
+		if err != nil {
+			return Value{}, err
+		}
+		value, err := r.evaluate(expression)
+		if err != nil {
+			return Value{}, err
+		}
+		text.WriteString(value.String())
+	}
+	return StringValue(text.String()), nil
+}
+
+// parseNumeric converts a Numeric token, including underscores and hex digits, to a Value.
+// Literals with a decimal point become exact decimals, those with an exponent
+// become floating point numbers and whole numbers become integers.
+// A k, M or % suffix scales the literal exactly, so 1k is the integer 1000
+// and 50% the decimal 0.5.
+func parseNumeric(token lexer.Token) (Value, error) {
+	text := strings.ReplaceAll(token.Value, "_", "")
+
+	scale, ok := numericScales[text[len(text)-1]]
+	if !ok {
+		return parseUnscaled(token, text)
+	}
+
+	base, err := parseUnscaled(token, text[:len(text)-1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	scaled := new(big.Rat).Mul(toRat(base), scale)
+	switch base.Kind {
+	case Number:
+		f, _ := scaled.Float64()
+		if !isFinite(f) {
+			return Value{}, errorAt(token, "invalid number %q", token.Value)
+		}
+		return NumberValue(f), nil
+	case Decimal:
+		return Value{Kind: Decimal, Dec: scaled}, nil
+	default:
+		return ratValue(scaled), nil
+	}
+}
+
+// parseUnscaled converts the text of a Numeric token, without underscores or a
+// scale suffix, to a Value.
+func parseUnscaled(token lexer.Token, text string) (Value, error) {
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		n, err := strconv.ParseInt(text, 0, 64)
+		if err != nil {
+			return Value{}, errorAt(token, "invalid number %q", token.Value)
+		}
+		return IntegerValue(n), nil
+	}
+
+	if strings.Contains(text, ".") {
+		d, ok := new(big.Rat).SetString(text)
+		if !ok {
+			return Value{}, errorAt(token, "invalid number %q", token.Value)
+		}
+		return Value{Kind: Decimal, Dec: d}, nil
+	}
 
+	if !strings.ContainsAny(text, "eE") {
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return Value{}, errorAt(token, "integer %q is out of range", token.Value)
+		}
+		return IntegerValue(n), nil
+	}
+
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Value{}, errorAt(token, "invalid number %q", token.Value)
+	}
+	return NumberValue(n), nil
+}
+
+// significant drops comment leaves, which have no effect on execution.
+func significant(nodes []*placer.Node) []*placer.Node {
+	kept := make([]*placer.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Type == placer.Leaf && node.Token.Type == lexer.Comment {
+			continue
+		}
+		kept = append(kept, node)
+	}
+	return kept
+}
+
+// indexOfSymbol returns the position of the first leaf holding the given symbol, or -1.
+func indexOfSymbol(nodes []*placer.Node, symbol string) int {
+	for i, node := range nodes {
+		if isSymbol(node, symbol) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isKeyword reports whether a node is a leaf holding the given keyword.
+func isKeyword(node *placer.Node, keyword string) bool {
+	return node.Type == placer.Leaf && node.Token.Type == lexer.Keyword && node.Value == keyword
+}
+
+// isName reports whether a node is a leaf holding the given identifier.
+func isName(node *placer.Node, name string) bool {
+	return node.Type == placer.Leaf && node.Token.Type == lexer.Alphanumeric && node.Value == name
+}
+
+// isSymbol reports whether a node is a leaf holding the given symbol.
+func isSymbol(node *placer.Node, symbol string) bool {
+	return node.Type == placer.Leaf && node.Token.Type == lexer.Symbol && node.Value == symbol
+}
@@ -0,0 +1,92 @@
+// runner/scope.go
+
+package runner
+
+// scope holds the variables of one level of execution: the program itself
+// or a single function invocation. Lookups fall back to the parent scope.
+// A block scope, such as the one a for loop opens on each iteration, only
+// holds the names declared in it; other assignments pass through it.
+type scope struct {
+	variables map[string]Value
+	constants map[string]bool
+	parent    *scope
+	block     bool
+}
+
+// newScope creates an empty scope nested inside parent, which may be nil.
+func newScope(parent *scope) *scope {
+	return &scope{variables: make(map[string]Value), parent: parent}
+}
+
+// newBlockScope creates an empty block scope nested inside parent.
+func newBlockScope(parent *scope) *scope {
+	return &scope{variables: make(map[string]Value), parent: parent, block: true}
+}
+
+// lookup returns the value bound to name in this scope or the nearest enclosing one.
+func (s *scope) lookup(name string) (Value, bool) {
+	for current := s; current != nil; current = current.parent {
+		if value, ok := current.variables[name]; ok {
+			return value, true
+		}
+	}
+	return Value{}, false
+}
+
+// set binds name to value in this scope, leaving the globals untouched.
+// Block scopes hand the assignment on to their parent unless they declared
+// name themselves, so "total := total + item" in a loop updates the outer total.
+// A function scope likewise updates a binding it captured from an enclosing
+// function, so "count := count + 1" in a closure changes the captured count.
+func (s *scope) set(name string, value Value) {
+	s.target(name).variables[name] = value
+}
+
+// setConstant binds name to value like set and marks the binding as constant.
+func (s *scope) setConstant(name string, value Value) {
+	target := s.target(name)
+	if target.constants == nil {
+		target.constants = make(map[string]bool)
+	}
+	target.variables[name] = value
+	target.constants[name] = true
+}
+
+// isConstant reports whether name resolves to a binding made by const. Such a
+// binding cannot be assigned again, not even from inside a function.
+func (s *scope) isConstant(name string) bool {
+	for current := s; current != nil; current = current.parent {
+		if _, ok := current.variables[name]; ok {
+			return current.constants[name]
+		}
+	}
+	return false
+}
+
+// target returns the scope an assignment to name binds it in: the nearest
+// scope below the globals that already binds name, or else the innermost
+// scope that is not a block scope.
+func (s *scope) target(name string) *scope {
+	current := s
+	for current.block && current.parent != nil {
+		if _, ok := current.variables[name]; ok {
+			return current
+		}
+		current = current.parent
+	}
+	if _, ok := current.variables[name]; ok {
+		return current
+	}
+
+	for outer := current.parent; outer != nil && outer.parent != nil; outer = outer.parent {
+		if _, ok := outer.variables[name]; ok {
+			return outer
+		}
+	}
+	return current
+}
+
+// declare binds name to value in this scope itself, even in a block scope.
+func (s *scope) declare(name string, value Value) {
+	s.variables[name] = value
+}
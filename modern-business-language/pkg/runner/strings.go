@@ -0,0 +1,122 @@
+// runner/strings.go
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stringBuiltins returns the built-in functions that work on text.
+func stringBuiltins() map[string]Builtin {
+	return map[string]Builtin{
+		"upper":     builtinUpper,
+		"lower":     builtinLower,
+		"trim":      builtinTrim,
+		"substring": builtinSubstring,
+		"contains":  builtinContains,
+	}
+}
+
+// expectString returns the text of an argument, which must be a string.
+func expectString(name string, arg Value) (string, error) {
+	if arg.Kind != String {
+		return "", fmt.Errorf("%s expects a string, got %v", name, arg.Kind)
+	}
+	return arg.Str, nil
+}
+
+// builtinUpper returns its argument in upper case.
+func builtinUpper(args []Value) (Value, error) {
+	if err := expectArgs("upper", args, 1); err != nil {
+		return Value{}, err
+	}
+
+	s, err := expectString("upper", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return StringValue(strings.ToUpper(s)), nil
+}
+
+// builtinLower returns its argument in lower case.
+func builtinLower(args []Value) (Value, error) {
+	if err := expectArgs("lower", args, 1); err != nil {
+		return Value{}, err
+	}
+
+	s, err := expectString("lower", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return StringValue(strings.ToLower(s)), nil
+}
+
+// builtinTrim returns its argument without leading and trailing whitespace.
+func builtinTrim(args []Value) (Value, error) {
+	if err := expectArgs("trim", args, 1); err != nil {
+		return Value{}, err
+	}
+
+	s, err := expectString("trim", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return StringValue(strings.TrimSpace(s)), nil
+}
+
+// builtinSubstring returns up to length characters of s starting at the
+// zero-based character position start. Positions count characters, not bytes,
+// and arguments outside the string are clamped to it instead of failing.
+func builtinSubstring(args []Value) (Value, error) {
+	if err := expectArgs("substring", args, 3); err != nil {
+		return Value{}, err
+	}
+
+	s, err := expectString("substring", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+
+	start, ok := toIndex(args[1])
+	if !ok {
+		return Value{}, fmt.Errorf("substring expects a whole number start, got %v", args[1])
+	}
+	length, ok := toIndex(args[2])
+	if !ok {
+		return Value{}, fmt.Errorf("substring expects a whole number length, got %v", args[2])
+	}
+
+	runes := []rune(s)
+	start = clamp(start, 0, len(runes))
+	end := clamp(start+clamp(length, 0, len(runes)), start, len(runes))
+	return StringValue(string(runes[start:end])), nil
+}
+
+// builtinContains reports whether s contains the text sub.
+func builtinContains(args []Value) (Value, error) {
+	if err := expectArgs("contains", args, 2); err != nil {
+		return Value{}, err
+	}
+
+	s, err := expectString("contains", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	sub, err := expectString("contains", args[1])
+	if err != nil {
+		return Value{}, err
+	}
+	return BooleanValue(strings.Contains(s, sub)), nil
+}
+
+// clamp limits n to the range from low to high.
+func clamp(n, low, high int) int {
+	if n < low {
+		return low
+	}
+	if n > high {
+		return high
+	}
+	return n
+}
@@ -0,0 +1,114 @@
+// runner/transform.go
+
+package runner
+
+import "fmt"
+
+// expectList checks that an argument is a list and returns its items.
+func expectList(name string, arg Value) ([]Value, error) {
+	if arg.Kind != List {
+		return nil, fmt.Errorf("%s expects a list, got %v", name, arg.Kind)
+	}
+	return arg.List.Items, nil
+}
+
+// expectFunction checks that an argument can be called and returns it.
+func expectFunction(name string, arg Value) (*FunctionValue, error) {
+	if arg.Kind != Function {
+		return nil, fmt.Errorf("%s expects a function, got %v", name, arg.Kind)
+	}
+	return arg.Func, nil
+}
+
+// builtinMap returns a new list holding the result of calling a function with
+// each item of a list, as in map(prices, double). Like a loop, map, filter and
+// reduce stop with ctx.Err() once the runner's context is done.
+func (r *Runner) builtinMap(args []Value) (Value, error) {
+	if err := expectArgs("map", args, 2); err != nil {
+		return Value{}, err
+	}
+	items, err := expectList("map", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	function, err := expectFunction("map", args[1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	results := make([]Value, 0, len(items))
+	for _, item := range items {
+		if err := r.ctx.Err(); err != nil {
+			return Value{}, err
+		}
+		result, err := r.callFunction(function, []Value{item})
+		if err != nil {
+			return Value{}, err
+		}
+		results = append(results, result)
+	}
+	return NewList(results...), nil
+}
+
+// builtinFilter returns a new list holding the items of a list for which a
+// function returns true, in order. The function must return a boolean.
+func (r *Runner) builtinFilter(args []Value) (Value, error) {
+	if err := expectArgs("filter", args, 2); err != nil {
+		return Value{}, err
+	}
+	items, err := expectList("filter", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	function, err := expectFunction("filter", args[1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	kept := []Value{}
+	for _, item := range items {
+		if err := r.ctx.Err(); err != nil {
+			return Value{}, err
+		}
+		keep, err := r.callFunction(function, []Value{item})
+		if err != nil {
+			return Value{}, err
+		}
+		if keep.Kind != Boolean {
+			return Value{}, fmt.Errorf("filter expects the function to return a boolean, got %v", keep.Kind)
+		}
+		if keep.Bool {
+			kept = append(kept, item)
+		}
+	}
+	return NewList(kept...), nil
+}
+
+// builtinReduce folds a list into one value by calling a function with the
+// value so far and each item in turn, starting from an initial value, as in
+// reduce(prices, add, 0). An empty list reduces to the initial value.
+func (r *Runner) builtinReduce(args []Value) (Value, error) {
+	if err := expectArgs("reduce", args, 3); err != nil {
+		return Value{}, err
+	}
+	items, err := expectList("reduce", args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	function, err := expectFunction("reduce", args[1])
+	if err != nil {
+		return Value{}, err
+	}
+
+	result := args[2]
+	for _, item := range items {
+		if err := r.ctx.Err(); err != nil {
+			return Value{}, err
+		}
+		result, err = r.callFunction(function, []Value{result, item})
+		if err != nil {
+			return Value{}, err
+		}
+	}
+	return result, nil
+}
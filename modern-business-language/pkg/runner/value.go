@@ -0,0 +1,332 @@
+// runner/value.go
+
+package runner
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Solifugus/mbl/pkg/placer"
+)
+
+// Kind identifies the type of data held by a Value.
+type Kind int
+
+const (
+	Null Kind = iota
+	Integer
+	Number
+	Decimal
+	String
+	Boolean
+	Function
+	List
+	Record
+	Date
+)
+
+var kindNames = map[Kind]string{
+	Null:     "null",
+	Integer:  "integer",
+	Number:   "number",
+	Decimal:  "decimal",
+	String:   "string",
+	Boolean:  "boolean",
+	Function: "function",
+	List:     "list",
+	Record:   "record",
+	Date:     "date",
+}
+
+// String returns the name of the kind as used in error messages.
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Value is a piece of data produced by evaluating MBL code.
+// The zero Value is null. Whole-number literals are integers, literals with a
+// decimal point are exact decimals and numbers with an exponent are floating
+// point; see evaluateBinary for how the three mix. A date literal such as
+// #2024-01-31# is a Date holding midnight UTC of that day.
+type Value struct {
+	Kind   Kind
+	Int    int64
+	Num    float64
+	Dec    *big.Rat
+	Str    string
+	Bool   bool
+	Func   *FunctionValue
+	List   *ListValue
+	Record *RecordValue
+	Date   time.Time
+
+	// Currency is the symbol of a decimal written as a currency literal, such
+	// as "$" for $1,234.56, and is empty for any other value. It only changes
+	// how the amount prints: amounts compare by value whatever their symbol,
+	// and arithmetic on them yields plain decimals.
+	Currency string
+}
+
+// RecordValue holds the fields of a record. Like lists, records are shared by
+// reference. A record remembers the order its fields were first set in, and
+// for loops and the printed form visit them in that order, so a report built
+// from a record comes out the same on every run. Setting a field again keeps
+// its place.
+type RecordValue struct {
+	Fields map[string]Value
+	keys   []string
+}
+
+// Set stores a field, placing its key after the existing ones when it is new.
+func (r *RecordValue) Set(key string, value Value) {
+	if _, ok := r.Fields[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.Fields[key] = value
+}
+
+// Keys returns the names of the fields in the order they were first set.
+// Fields stored in the Fields map directly rather than through Set have no
+// recorded place, so they follow the others in sorted order.
+func (r *RecordValue) Keys() []string {
+	keys := append([]string(nil), r.keys...)
+	if len(keys) == len(r.Fields) {
+		return keys
+	}
+
+	ordered := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		ordered[key] = true
+	}
+	var rest []string
+	for key := range r.Fields {
+		if !ordered[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// ListValue holds the items of a list. Lists are shared by reference, so a
+// list assigned to two variables is the same list.
+type ListValue struct {
+	Items []Value
+}
+
+// FunctionValue is a function that can be called from MBL code: either a
+// user-defined function with its parameters, its body and the scope it was
+// defined in, or a built-in implemented in Go. The Body of a function is the
+// Block it runs; that of a lambda is the expression it returns.
+type FunctionValue struct {
+	Name    string
+	Params  []string
+	Body    *placer.Node
+	Builtin Builtin
+
+	// closure is the scope the function was defined in. Its body runs in a
+	// scope nested inside it, so a function returned from another keeps the
+	// locals of that call alive and sees them as they are when it runs.
+	closure *scope
+}
+
+// NewList creates a list Value holding the given items.
+func NewList(items ...Value) Value {
+	return Value{Kind: List, List: &ListValue{Items: items}}
+}
+
+// NewRecord creates an empty record Value.
+func NewRecord() Value {
+	return Value{Kind: Record, Record: &RecordValue{Fields: make(map[string]Value)}}
+}
+
+// IntegerValue creates a whole-number Value.
+func IntegerValue(n int64) Value {
+	return Value{Kind: Integer, Int: n}
+}
+
+// NumberValue creates a floating point Value. n should be finite; the runner
+// never produces infinity or NaN itself.
+func NumberValue(n float64) Value {
+	return Value{Kind: Number, Num: n}
+}
+
+// StringValue creates a text Value.
+func StringValue(s string) Value {
+	return Value{Kind: String, Str: s}
+}
+
+// BooleanValue creates a boolean Value.
+func BooleanValue(b bool) Value {
+	return Value{Kind: Boolean, Bool: b}
+}
+
+// Equal reports whether two values have the same kind and contents.
+// Integers, numbers and decimals compare by numeric value, so 1.0 equals 1.
+// A list or record that contains itself equals another wherever comparing
+// them leads back to a pair already being compared.
+func (v Value) Equal(other Value) bool {
+	return v.equal(other, nil)
+}
+
+// containerPair is a pair of lists or records being compared by equal.
+type containerPair struct {
+	left, right interface{}
+}
+
+// Helper function to compare v with other, with open holding the pairs of
+// lists and records being compared around them.
+func (v Value) equal(other Value, open map[containerPair]bool) bool {
+	if isNumeric(v) && isNumeric(other) && v.Kind != other.Kind {
+		return toRat(v).Cmp(toRat(other)) == 0
+	}
+
+	if v.Kind != other.Kind {
+		return false
+	}
+
+	switch v.Kind {
+	case Integer:
+		return v.Int == other.Int
+	case Number:
+		return v.Num == other.Num
+	case Decimal:
+		return v.Dec.Cmp(other.Dec) == 0
+	case Date:
+		return v.Date.Equal(other.Date)
+	case String:
+		return v.Str == other.Str
+	case Boolean:
+		return v.Bool == other.Bool
+	case Function:
+		return v.Func == other.Func
+	case List:
+		if len(v.List.Items) != len(other.List.Items) {
+			return false
+		}
+		pair := containerPair{v.List, other.List}
+		if open[pair] {
+			return true
+		}
+		open = enterPair(open, pair)
+		defer delete(open, pair)
+
+		for i, item := range v.List.Items {
+			if !item.equal(other.List.Items[i], open) {
+				return false
+			}
+		}
+		return true
+	case Record:
+		if len(v.Record.Fields) != len(other.Record.Fields) {
+			return false
+		}
+		pair := containerPair{v.Record, other.Record}
+		if open[pair] {
+			return true
+		}
+		open = enterPair(open, pair)
+		defer delete(open, pair)
+
+		for key, field := range v.Record.Fields {
+			otherField, ok := other.Record.Fields[key]
+			if !ok || !field.equal(otherField, open) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+// Helper function to add pair to the pairs being compared, creating the set
+// on first use.
+func enterPair(open map[containerPair]bool, pair containerPair) map[containerPair]bool {
+	if open == nil {
+		open = make(map[containerPair]bool)
+	}
+	open[pair] = true
+	return open
+}
+
+// String renders the value the way print shows it: text without quotes,
+// numbers in their shortest exact form and booleans as true or false.
+// A list or record met again inside itself is shown as [...] or {...}.
+func (v Value) String() string {
+	return v.format(nil)
+}
+
+// Helper function to render v, with open holding the lists and records being
+// rendered around it.
+func (v Value) format(open map[interface{}]bool) string {
+	switch v.Kind {
+	case Integer:
+		return strconv.FormatInt(v.Int, 10)
+	case Number:
+		return strconv.FormatFloat(v.Num, 'f', -1, 64)
+	case Decimal:
+		return v.Currency + formatDecimal(v.Dec)
+	case Date:
+		return v.Date.Format("2006-01-02")
+	case String:
+		return v.Str
+	case Boolean:
+		return strconv.FormatBool(v.Bool)
+	case Function:
+		return "function " + v.Func.Name
+	case List:
+		if open[v.List] {
+			return "[...]"
+		}
+		open = enterContainer(open, v.List)
+		defer delete(open, v.List)
+
+		items := make([]string, len(v.List.Items))
+		for i, item := range v.List.Items {
+			items[i] = item.literal(open)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case Record:
+		if open[v.Record] {
+			return "{...}"
+		}
+		open = enterContainer(open, v.Record)
+		defer delete(open, v.Record)
+
+		keys := v.Record.Keys()
+		fields := make([]string, len(keys))
+		for i, key := range keys {
+			fields[i] = key + ": " + v.Record.Fields[key].literal(open)
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	default:
+		return "null"
+	}
+}
+
+// literal renders the value as it would be written in MBL code, quoting strings,
+// for use inside the printed form of a list or record.
+func (v Value) literal(open map[interface{}]bool) string {
+	if v.Kind == String {
+		return strconv.Quote(v.Str)
+	}
+	return v.format(open)
+}
+
+// Helper function to add a list or record to those being rendered, creating
+// the set on first use.
+func enterContainer(open map[interface{}]bool, container interface{}) map[interface{}]bool {
+	if open == nil {
+		open = make(map[interface{}]bool)
+	}
+	open[container] = true
+	return open
+}
@@ -0,0 +1,40 @@
+// tests/build_test.go
+
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+	_ "github.com/Solifugus/mbl/pkg/mbl"
+	"github.com/Solifugus/mbl/pkg/placer"
+	"github.com/Solifugus/mbl/pkg/runner"
+)
+
+// TestPackagesBuildTogether passes the same []lexer.Token through the placer
+// and the runner, so it stops compiling if the packages drift apart.
+func TestPackagesBuildTogether(t *testing.T) {
+	var tokens []lexer.Token
+	tokens, err := lexer.Tokenize(`print "ok"`)
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	if err := p.PlaceTokens(tokens); err != nil {
+		t.Fatalf("unexpected place error: %v", err)
+	}
+
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+	if err := r.Run(tokens); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if output.String() != "ok\n" {
+		t.Errorf("expected output %q, got %q", "ok\n", output.String())
+	}
+}
@@ -3,7 +3,9 @@
 package tests
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Solifugus/mbl/pkg/lexer"
@@ -17,11 +19,30 @@ func TestLexerLex(t *testing.T) {
 		{
 			input: "Text123 \"String with spaces\" 42\nAlphanumeric",
 			tokens: []lexer.Token{
-				{Type: lexer.Alphanumeric, Value: "Text123"},
-				{Type: lexer.Text, Value: "String with spaces"},
-				{Type: lexer.Numeric, Value: "42"},
-				{Type: lexer.NewLine, Value: "\n"},
-				{Type: lexer.Alphanumeric, Value: "Alphanumeric"},
+				lexer.NewToken(lexer.Alphanumeric, "Text123", 1, 1),
+				lexer.NewToken(lexer.Text, "String with spaces", 1, 9),
+				lexer.NewToken(lexer.Numeric, "42", 1, 30),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 32),
+				lexer.NewToken(lexer.Alphanumeric, "Alphanumeric", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 13),
+			},
+		},
+		{
+			input: "a\n\nb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n\n", 1, 2),
+				lexer.NewToken(lexer.Alphanumeric, "b", 3, 1),
+				lexer.NewToken(lexer.EOF, "", 3, 2),
+			},
+		},
+		{
+			input: "a \r\nb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 3),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 2),
 			},
 		},
 		// Add more test cases as needed
@@ -41,3 +62,2206 @@ func TestLexerLex(t *testing.T) {
 		})
 	}
 }
+
+func TestLexerPositions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		tabWidth int
+		tokens   []lexer.Token
+	}{
+		{
+			name:     "columns reset after newline",
+			input:    "ab cd\nef",
+			tabWidth: 1,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "ab", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "cd", 1, 4),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 6),
+				lexer.NewToken(lexer.Alphanumeric, "ef", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 3),
+			},
+		},
+		{
+			name:     "tab width",
+			input:    "a\tb",
+			tabWidth: 4,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Tab, "\t", 1, 2),
+				lexer.NewToken(lexer.Alphanumeric, "b", 1, 6),
+				lexer.NewToken(lexer.EOF, "", 1, 7),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			l.TabWidth = testCase.tabWidth
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerLineEndings(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			name:  "line feed",
+			input: "a\nb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 2),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 2),
+			},
+		},
+		{
+			name:  "carriage return line feed",
+			input: "a\r\nb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 2),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 2),
+			},
+		},
+		{
+			name:  "lone carriage return",
+			input: "a\rb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 2),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 2),
+			},
+		},
+		{
+			name:  "blank lines of mixed endings",
+			input: "a\r\n\r\n\r\nb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n\n\n", 1, 2),
+				lexer.NewToken(lexer.Alphanumeric, "b", 4, 1),
+				lexer.NewToken(lexer.EOF, "", 4, 2),
+			},
+		},
+		{
+			name:  "comment before carriage return line feed",
+			input: "# note\r\nb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Comment, " note", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 7),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 2),
+			},
+		},
+		{
+			name:  "tab after carriage return line feed",
+			input: "a\r\n\tb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 2),
+				lexer.NewToken(lexer.Tab, "\t", 2, 1),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 2),
+				lexer.NewToken(lexer.EOF, "", 2, 3),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerTabs(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "\t\tprint",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Tab, "\t\t", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "print", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 8),
+			},
+		},
+		{
+			input: "\t \t",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Tab, "\t", 1, 1),
+				lexer.NewToken(lexer.Tab, "\t", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+		{
+			input: "a\n\tb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 2),
+				lexer.NewToken(lexer.Tab, "\t", 2, 1),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 2),
+				lexer.NewToken(lexer.EOF, "", 2, 3),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerComments(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		strip  bool
+		tokens []lexer.Token
+	}{
+		{
+			name:  "comment before newline",
+			input: "x # the total\ny",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+				lexer.NewToken(lexer.Comment, " the total", 1, 3),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 14),
+				lexer.NewToken(lexer.Alphanumeric, "y", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 2),
+			},
+		},
+		{
+			name:  "comment at EOF",
+			input: "x #done",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+				lexer.NewToken(lexer.Comment, "done", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 8),
+			},
+		},
+		{
+			name:  "stripped comments",
+			input: "# heading\nx #done",
+			strip: true,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.NewLine, "\n", 1, 10),
+				lexer.NewToken(lexer.Alphanumeric, "x", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 8),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			l.StripComments = testCase.strip
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerBlockComments(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "a /* note\nmore */ b",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Comment, " note\nmore ", 1, 3),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 9),
+				lexer.NewToken(lexer.EOF, "", 2, 10),
+			},
+		},
+		{
+			input: "/* /* */ */",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Comment, " /* ", 1, 1),
+				lexer.NewToken(lexer.Symbol, "*", 1, 10),
+				lexer.NewToken(lexer.Symbol, "/", 1, 11),
+				lexer.NewToken(lexer.EOF, "", 1, 12),
+			},
+		},
+		{
+			input: "a / b",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "/", 1, 3),
+				lexer.NewToken(lexer.Alphanumeric, "b", 1, 5),
+				lexer.NewToken(lexer.EOF, "", 1, 6),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerUnclosedBlockComment(t *testing.T) {
+	l := lexer.NewLexer("x\n  /* never closed")
+	_, err := l.Lex()
+	if err == nil {
+		t.Fatal("expected an error for an unclosed block comment")
+	}
+
+	expected := "lex error at line 2 col 3: unclosed block comment"
+	if err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err.Error())
+	}
+}
+
+func TestLexerTextEscapes(t *testing.T) {
+	testCases := []struct {
+		input string
+		value string
+	}{
+		{input: `"line1\nline2"`, value: "line1\nline2"},
+		{input: `"a\tb"`, value: "a\tb"},
+		{input: `"a\rb"`, value: "a\rb"},
+		{input: `"say \"hi\""`, value: `say "hi"`},
+		{input: `"C:\\temp"`, value: `C:\temp`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := []lexer.Token{
+				lexer.NewToken(lexer.Text, testCase.value, 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, len(testCase.input)+1),
+			}
+			if !reflect.DeepEqual(tokens, expected) {
+				t.Errorf("expected tokens %v, got %v", expected, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerTextQuotes(t *testing.T) {
+	testCases := []struct {
+		name   string
+		quotes string
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			name:   "single quoted text",
+			quotes: `"'`,
+			input:  `'it\'s' "both"`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Text, "it's", 1, 1),
+				lexer.NewToken(lexer.Text, "both", 1, 9),
+				lexer.NewToken(lexer.EOF, "", 1, 15),
+			},
+		},
+		{
+			name:   "double quotes inside single quoted text",
+			quotes: `'`,
+			input:  `'say "hi"\n'`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Text, "say \"hi\"\n", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 13),
+			},
+		},
+		{
+			name:   "char literals take precedence",
+			quotes: `"'`,
+			input:  `'A' '\n' 'AB' ''`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Char, "A", 1, 1),
+				lexer.NewToken(lexer.Char, "\n", 1, 5),
+				lexer.NewToken(lexer.Text, "AB", 1, 10),
+				lexer.NewToken(lexer.Text, "", 1, 15),
+				lexer.NewToken(lexer.EOF, "", 1, 17),
+			},
+		},
+		{
+			name:   "interpolation",
+			quotes: `'`,
+			input:  `'total {x}'`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Interpolation, "total {x}", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 12),
+			},
+		},
+		{
+			name:   "double quote no longer a text quote",
+			quotes: `'`,
+			input:  `"x"`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, `"`, 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 2),
+				lexer.NewToken(lexer.Symbol, `"`, 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			l.TextQuotes = testCase.quotes
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerUnclosedAlternateQuote(t *testing.T) {
+	l := lexer.NewLexer("x := 'open")
+	l.TextQuotes = `"'`
+	_, err := l.Lex()
+
+	expected := "lex error at line 1 col 6: unclosed quote"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestLexerUnknownEscape(t *testing.T) {
+	l := lexer.NewLexer(`x "bad \q"`)
+	_, err := l.Lex()
+	if err == nil {
+		t.Fatal("expected an error for an unknown escape sequence")
+	}
+
+	expected := `lex error at line 1 col 8: unknown escape sequence \q`
+	if err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err.Error())
+	}
+}
+
+func TestLexerInterpolation(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: `"total is {x}"`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Interpolation, "total is {x}", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 15),
+			},
+		},
+		{
+			input: `"no braces\n"`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Text, "no braces\n", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 14),
+			},
+		},
+		{
+			input: `"escaped \{braces\}"`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Text, "escaped {braces}", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 21),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			tokens, err := lexer.Tokenize(testCase.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestTokenSegments(t *testing.T) {
+	tokens, err := lexer.Tokenize(`x := "Dear {name}, you owe \{{amount * 2}\}"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	segments, err := tokens[2].Segments()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Segment{
+		{Text: "Dear "},
+		{Tokens: []lexer.Token{lexer.NewToken(lexer.Alphanumeric, "name", 1, 13)}},
+		{Text: ", you owe {"},
+		{Tokens: []lexer.Token{
+			lexer.NewToken(lexer.Alphanumeric, "amount", 1, 31),
+			lexer.NewToken(lexer.Symbol, "*", 1, 38),
+			lexer.NewToken(lexer.Numeric, "2", 1, 40),
+		}},
+		{Text: "}"},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("expected segments %v, got %v", expected, segments)
+	}
+}
+
+func TestLexerMalformedInterpolation(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: `"sum {x"`, expected: "lex error at line 1 col 6: unclosed '{' in text"},
+		{input: `"sum {{a: 1}"`, expected: "lex error at line 1 col 6: unclosed '{' in text"},
+		{input: `"sum }"`, expected: "lex error at line 1 col 6: unmatched '}' in text"},
+		{input: `"sum { }"`, expected: "lex error at line 1 col 6: empty interpolation in text"},
+		{input: `"sum {'ab'}"`, expected: "lex error at line 1 col 7: character literal holds more than one character"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			_, err := lexer.Tokenize(testCase.input)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.input)
+			}
+
+			if err.Error() != testCase.expected {
+				t.Errorf("expected error %q, got %q", testCase.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestLexerHexadecimal(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "0xFF 10 0X1a",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "0xFF", 1, 1),
+				lexer.NewToken(lexer.Numeric, "10", 1, 6),
+				lexer.NewToken(lexer.Numeric, "0X1a", 1, 9),
+				lexer.NewToken(lexer.EOF, "", 1, 13),
+			},
+		},
+		{
+			input: "0xFFg",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "0xFF", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "g", 1, 5),
+				lexer.NewToken(lexer.EOF, "", 1, 6),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerHexadecimalWithoutDigits(t *testing.T) {
+	l := lexer.NewLexer("x 0x")
+	_, err := l.Lex()
+	if err == nil {
+		t.Fatal("expected an error for a hexadecimal literal without digits")
+	}
+
+	expected := "lex error at line 1 col 3: hexadecimal literal without digits"
+	if err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err.Error())
+	}
+}
+
+func TestLexerExponents(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "1.5e6 2e+3",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "1.5e6", 1, 1),
+				lexer.NewToken(lexer.Numeric, "2e+3", 1, 7),
+				lexer.NewToken(lexer.EOF, "", 1, 11),
+			},
+		},
+		{
+			input: "2E-3",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "2E-3", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 5),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerNumericSuffixes(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "1k 2M 50%",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "1k", 1, 1),
+				lexer.NewToken(lexer.Numeric, "2M", 1, 4),
+				lexer.NewToken(lexer.Numeric, "50%", 1, 7),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+		{
+			input: "1.5k 2e3M",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "1.5k", 1, 1),
+				lexer.NewToken(lexer.Numeric, "2e3M", 1, 6),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+		{
+			input: "1kg",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "1k", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "g", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+		{
+			input: "rate*15%)",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "rate", 1, 1),
+				lexer.NewToken(lexer.Symbol, "*", 1, 5),
+				lexer.NewToken(lexer.Numeric, "15%", 1, 6),
+				lexer.NewToken(lexer.Symbol, ")", 1, 9),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+		{
+			input: "10%3 10%x",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "10", 1, 1),
+				lexer.NewToken(lexer.Symbol, "%", 1, 3),
+				lexer.NewToken(lexer.Numeric, "3", 1, 4),
+				lexer.NewToken(lexer.Numeric, "10", 1, 6),
+				lexer.NewToken(lexer.Symbol, "%", 1, 8),
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 9),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+		{
+			input: "10%-3 10%(x)",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "10", 1, 1),
+				lexer.NewToken(lexer.Symbol, "%", 1, 3),
+				lexer.NewToken(lexer.Symbol, "-", 1, 4),
+				lexer.NewToken(lexer.Numeric, "3", 1, 5),
+				lexer.NewToken(lexer.Numeric, "10", 1, 7),
+				lexer.NewToken(lexer.Symbol, "%", 1, 9),
+				lexer.NewToken(lexer.Symbol, "(", 1, 10),
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 11),
+				lexer.NewToken(lexer.Symbol, ")", 1, 12),
+				lexer.NewToken(lexer.EOF, "", 1, 13),
+			},
+		},
+		{
+			input: "[5%, 10%]\n",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "[", 1, 1),
+				lexer.NewToken(lexer.Numeric, "5%", 1, 2),
+				lexer.NewToken(lexer.Symbol, ",", 1, 4),
+				lexer.NewToken(lexer.Numeric, "10%", 1, 6),
+				lexer.NewToken(lexer.Symbol, "]", 1, 9),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 10),
+				lexer.NewToken(lexer.EOF, "", 2, 1),
+			},
+		},
+		{
+			input: "0x1F%",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "0x1F", 1, 1),
+				lexer.NewToken(lexer.Symbol, "%", 1, 5),
+				lexer.NewToken(lexer.EOF, "", 1, 6),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			tokens, err := lexer.Tokenize(testCase.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerMalformedExponents(t *testing.T) {
+	for _, input := range []string{"1e", "1e+", "2.5E-"} {
+		t.Run(input, func(t *testing.T) {
+			l := lexer.NewLexer(input)
+			_, err := l.Lex()
+			if err == nil {
+				t.Fatalf("expected an error for %q", input)
+			}
+
+			expected := "lex error at line 1 col 1: exponent without digits"
+			if err.Error() != expected {
+				t.Errorf("expected error %q, got %q", expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestLexerOperators(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "a:=b>=c",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 1, 2),
+				lexer.NewToken(lexer.Alphanumeric, "b", 1, 4),
+				lexer.NewToken(lexer.Symbol, ">=", 1, 5),
+				lexer.NewToken(lexer.Alphanumeric, "c", 1, 7),
+				lexer.NewToken(lexer.EOF, "", 1, 8),
+			},
+		},
+		{
+			input: "== != <= -> && || = <",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "==", 1, 1),
+				lexer.NewToken(lexer.Symbol, "!=", 1, 4),
+				lexer.NewToken(lexer.Symbol, "<=", 1, 7),
+				lexer.NewToken(lexer.Symbol, "->", 1, 10),
+				lexer.NewToken(lexer.Symbol, "&&", 1, 13),
+				lexer.NewToken(lexer.Symbol, "||", 1, 16),
+				lexer.NewToken(lexer.Symbol, "=", 1, 19),
+				lexer.NewToken(lexer.Symbol, "<", 1, 21),
+				lexer.NewToken(lexer.EOF, "", 1, 22),
+			},
+		},
+		{
+			input: "7//2/1",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "7", 1, 1),
+				lexer.NewToken(lexer.Symbol, "//", 1, 2),
+				lexer.NewToken(lexer.Numeric, "2", 1, 4),
+				lexer.NewToken(lexer.Symbol, "/", 1, 5),
+				lexer.NewToken(lexer.Numeric, "1", 1, 6),
+				lexer.NewToken(lexer.EOF, "", 1, 7),
+			},
+		},
+		{
+			input: "===",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "==", 1, 1),
+				lexer.NewToken(lexer.Symbol, "=", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerOperatorsAtEnd(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "x =",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+				lexer.NewToken(lexer.Symbol, "=", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+		{
+			input: "x :",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+		{
+			input: "x :=",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 5),
+			},
+		},
+		{
+			input: "a<",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "<", 1, 2),
+				lexer.NewToken(lexer.EOF, "", 1, 3),
+			},
+		},
+		{
+			input: "a&",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "&", 1, 2),
+				lexer.NewToken(lexer.EOF, "", 1, 3),
+			},
+		},
+		{
+			input: "a/",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "/", 1, 2),
+				lexer.NewToken(lexer.EOF, "", 1, 3),
+			},
+		},
+		{
+			input: "a||",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "||", 1, 2),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+		{
+			input: "=",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "=", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 2),
+			},
+		},
+		{
+			input: "!=\n=",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "!=", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 3),
+				lexer.NewToken(lexer.Symbol, "=", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 2),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			tokens, err := lexer.Tokenize(testCase.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerMalformedNumerics(t *testing.T) {
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{input: "1.2.3", err: "lex error at line 1 col 4: numeric literal has a second decimal point"},
+		{input: "x := 1_", err: "lex error at line 1 col 6: numeric literal ends with an underscore"},
+		{input: "1_e5", err: "lex error at line 1 col 1: numeric literal ends with an underscore"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			_, err := l.Lex()
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.input)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestLexerLeadingUnderscoreIsNotNumeric(t *testing.T) {
+	l := lexer.NewLexer("_1 1_000")
+	tokens, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Symbol, "_", 1, 1),
+		lexer.NewToken(lexer.Numeric, "1", 1, 2),
+		lexer.NewToken(lexer.Numeric, "1_000", 1, 4),
+		lexer.NewToken(lexer.EOF, "", 1, 9),
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestLexerSnakeCaseIdentifiers(t *testing.T) {
+	l := lexer.NewLexer("snake_case_name trailing_ _leading a__b x_1")
+	tokens, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Alphanumeric, "snake_case_name", 1, 1),
+		lexer.NewToken(lexer.Alphanumeric, "trailing_", 1, 17),
+		lexer.NewToken(lexer.Symbol, "_", 1, 27),
+		lexer.NewToken(lexer.Alphanumeric, "leading", 1, 28),
+		lexer.NewToken(lexer.Alphanumeric, "a__b", 1, 36),
+		lexer.NewToken(lexer.Alphanumeric, "x_1", 1, 41),
+		lexer.NewToken(lexer.EOF, "", 1, 44),
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestLexerKeywords(t *testing.T) {
+	l := lexer.NewLexer("if total else ifs\nreturn\nswitch case default const fn")
+	tokens, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Keyword, "if", 1, 1),
+		lexer.NewToken(lexer.Alphanumeric, "total", 1, 4),
+		lexer.NewToken(lexer.Keyword, "else", 1, 10),
+		lexer.NewToken(lexer.Alphanumeric, "ifs", 1, 15),
+		lexer.NewToken(lexer.NewLine, "\n", 1, 18),
+		lexer.NewToken(lexer.Keyword, "return", 2, 1),
+		lexer.NewToken(lexer.NewLine, "\n", 2, 7),
+		lexer.NewToken(lexer.Keyword, "switch", 3, 1),
+		lexer.NewToken(lexer.Keyword, "case", 3, 8),
+		lexer.NewToken(lexer.Keyword, "default", 3, 13),
+		lexer.NewToken(lexer.Keyword, "const", 3, 21),
+		lexer.NewToken(lexer.Keyword, "fn", 3, 27),
+		lexer.NewToken(lexer.EOF, "", 3, 29),
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestLexerAddKeywords(t *testing.T) {
+	l := lexer.NewLexer("service ship")
+	l.AddKeywords("service")
+	tokens, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Keyword, "service", 1, 1),
+		lexer.NewToken(lexer.Alphanumeric, "ship", 1, 9),
+		lexer.NewToken(lexer.EOF, "", 1, 13),
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestLexerLiterals(t *testing.T) {
+	testCases := []struct {
+		input           string
+		caseInsensitive bool
+		tokens          []lexer.Token
+	}{
+		{
+			input: "true false null",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Boolean, "true", 1, 1),
+				lexer.NewToken(lexer.Boolean, "false", 1, 6),
+				lexer.NewToken(lexer.Null, "null", 1, 12),
+				lexer.NewToken(lexer.EOF, "", 1, 16),
+			},
+		},
+		{
+			input: "True NULL",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "True", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "NULL", 1, 6),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+		{
+			input:           "True NULL",
+			caseInsensitive: true,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Boolean, "true", 1, 1),
+				lexer.NewToken(lexer.Null, "null", 1, 6),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			l.CaseInsensitiveLiterals = testCase.caseInsensitive
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerUTF8(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "café x",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "café", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 6),
+				lexer.NewToken(lexer.EOF, "", 1, 7),
+			},
+		},
+		{
+			input: "-π -1",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "-", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "π", 1, 2),
+				lexer.NewToken(lexer.Symbol, "-", 1, 4),
+				lexer.NewToken(lexer.Numeric, "1", 1, 5),
+				lexer.NewToken(lexer.EOF, "", 1, 6),
+			},
+		},
+		{
+			input: "\"naïve\" ≠ 2",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Text, "naïve", 1, 1),
+				lexer.NewToken(lexer.Symbol, "≠", 1, 9),
+				lexer.NewToken(lexer.Numeric, "2", 1, 11),
+				lexer.NewToken(lexer.EOF, "", 1, 12),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerUnicodeIdentifiers(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "número := 1",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "número", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 1, 8),
+				lexer.NewToken(lexer.Numeric, "1", 1, 11),
+				lexer.NewToken(lexer.EOF, "", 1, 12),
+			},
+		},
+		{
+			// "número" with the accent as a separate combining mark.
+			input: "nu\u0301mero+1",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "nu\u0301mero", 1, 1),
+				lexer.NewToken(lexer.Symbol, "+", 1, 8),
+				lexer.NewToken(lexer.Numeric, "1", 1, 9),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+		{
+			input: "größe_neu straße2 日本語",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "größe_neu", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "straße2", 1, 11),
+				lexer.NewToken(lexer.Alphanumeric, "日本語", 1, 19),
+				lexer.NewToken(lexer.EOF, "", 1, 22),
+			},
+		},
+		{
+			input: "my_var:=über_total",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "my_var", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 1, 7),
+				lexer.NewToken(lexer.Alphanumeric, "über_total", 1, 9),
+				lexer.NewToken(lexer.EOF, "", 1, 19),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(testCase.input).Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerNextToken(t *testing.T) {
+	input := "total := 1_000 # running\n\tprint \"done\""
+
+	expected, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	l := lexer.NewLexer(input)
+	var tokens []lexer.Token
+	for {
+		token, err := l.NextToken()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tokens = append(tokens, token)
+		if token.Type == lexer.EOF {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+
+	token, err := l.NextToken()
+	if err != nil || token.Type != lexer.EOF {
+		t.Errorf("expected EOF again after exhaustion, got %v, %v", token, err)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	testCases := []string{
+		"total := 1_000 # running\n\tprint \"done\"",
+		"@strict\r\nif a <= 2.5 {\n\tb := 'c'\n}",
+		"",
+	}
+
+	for _, input := range testCases {
+		t.Run(input, func(t *testing.T) {
+			expected, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tokens, err := lexer.Tokenize(input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, expected) {
+				t.Errorf("expected tokens %v, got %v", expected, tokens)
+			}
+		})
+	}
+}
+
+func TestTokenizeError(t *testing.T) {
+	_, expected := lexer.NewLexer(`x := "open`).Lex()
+	tokens, err := lexer.Tokenize(`x := "open`)
+	if err == nil {
+		t.Fatalf("expected an error, got tokens %v", tokens)
+	}
+
+	if err.Error() != expected.Error() {
+		t.Errorf("expected error %q, got %q", expected, err)
+	}
+}
+
+func TestLexerEOF(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.EOF, "", 1, 1),
+			},
+		},
+		{
+			input: "x\n",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 2),
+				lexer.NewToken(lexer.EOF, "", 2, 1),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexErrorFields(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected lexer.LexError
+	}{
+		{
+			input:    "x := 1\ny := \"open",
+			expected: lexer.LexError{Message: "unclosed quote", Line: 2, Column: 6, Offset: 12},
+		},
+		{
+			input:    "a /* b",
+			expected: lexer.LexError{Message: "unclosed block comment", Line: 1, Column: 3, Offset: 2},
+		},
+		{
+			input:    "\"é\\x\"",
+			expected: lexer.LexError{Message: "unknown escape sequence \\x", Line: 1, Column: 3, Offset: 3},
+		},
+		{
+			input:    "n := 1.2.3",
+			expected: lexer.LexError{Message: "numeric literal has a second decimal point", Line: 1, Column: 9, Offset: 8},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			_, err := lexer.NewLexer(testCase.input).Lex()
+
+			var lexErr *lexer.LexError
+			if !errors.As(err, &lexErr) {
+				t.Fatalf("expected a *lexer.LexError, got %v", err)
+			}
+
+			if *lexErr != testCase.expected {
+				t.Errorf("expected %+v, got %+v", testCase.expected, *lexErr)
+			}
+		})
+	}
+}
+
+func TestSourceLine(t *testing.T) {
+	input := "first\r\nsecond\nthird\rfourth"
+
+	testCases := []struct {
+		line     int
+		expected string
+	}{
+		{line: 1, expected: "first"},
+		{line: 2, expected: "second"},
+		{line: 3, expected: "third"},
+		{line: 4, expected: "fourth"},
+		{line: 5, expected: ""},
+		{line: 0, expected: ""},
+	}
+
+	for _, testCase := range testCases {
+		if got := lexer.SourceLine(input, testCase.line); got != testCase.expected {
+			t.Errorf("line %d: expected %q, got %q", testCase.line, testCase.expected, got)
+		}
+	}
+}
+
+func TestLexerCurrency(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "$1,234.56",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Currency, "$1234.56", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+		{
+			input: "€1.234",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Currency, "€1.234", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 7),
+			},
+		},
+		{
+			input: "£12,000,000",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Currency, "£12000000", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 12),
+			},
+		},
+		{
+			input: "total := $5, $6",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "total", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 1, 7),
+				lexer.NewToken(lexer.Currency, "$5", 1, 10),
+				lexer.NewToken(lexer.Symbol, ",", 1, 12),
+				lexer.NewToken(lexer.Currency, "$6", 1, 14),
+				lexer.NewToken(lexer.EOF, "", 1, 16),
+			},
+		},
+		{
+			input: "$x",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "$", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 2),
+				lexer.NewToken(lexer.EOF, "", 1, 3),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			l.CurrencyLiterals = true
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerCurrencyDisabled(t *testing.T) {
+	tokens, err := lexer.NewLexer("$1,234").Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Symbol, "$", 1, 1),
+		lexer.NewToken(lexer.Numeric, "1", 1, 2),
+		lexer.NewToken(lexer.Symbol, ",", 1, 3),
+		lexer.NewToken(lexer.Numeric, "234", 1, 4),
+		lexer.NewToken(lexer.EOF, "", 1, 7),
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestLexerMalformedCurrency(t *testing.T) {
+	testCases := []string{"$1,23,4", "$1234,567", "x := €1,0000"}
+
+	for _, input := range testCases {
+		t.Run(input, func(t *testing.T) {
+			l := lexer.NewLexer(input)
+			l.CurrencyLiterals = true
+			_, err := l.Lex()
+
+			var lexErr *lexer.LexError
+			if !errors.As(err, &lexErr) {
+				t.Fatalf("expected a *lexer.LexError, got %v", err)
+			}
+
+			if lexErr.Message != "malformed digit grouping in currency literal" {
+				t.Errorf("expected a grouping error, got %q", lexErr.Message)
+			}
+		})
+	}
+}
+
+func TestLexerDates(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "due := #2024-01-31#",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "due", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 1, 5),
+				lexer.NewToken(lexer.Date, "2024-01-31", 1, 8),
+				lexer.NewToken(lexer.EOF, "", 1, 20),
+			},
+		},
+		{
+			input: "#2024-02-29# # leap day",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Date, "2024-02-29", 1, 1),
+				lexer.NewToken(lexer.Comment, " leap day", 1, 14),
+				lexer.NewToken(lexer.EOF, "", 1, 24),
+			},
+		},
+		{
+			input: "#2024 budget",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Comment, "2024 budget", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 13),
+			},
+		},
+		{
+			input: "#2024-12-25 release day",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Comment, "2024-12-25 release day", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 24),
+			},
+		},
+		{
+			input: "#2024-99 notes",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Comment, "2024-99 notes", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 15),
+			},
+		},
+		{
+			input: "x := 5 #3-4",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 1, 3),
+				lexer.NewToken(lexer.Numeric, "5", 1, 6),
+				lexer.NewToken(lexer.Comment, "3-4", 1, 8),
+				lexer.NewToken(lexer.EOF, "", 1, 12),
+			},
+		},
+		{
+			input: "#12-25# notes\n#2024-1-5",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Comment, "12-25# notes", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 14),
+				lexer.NewToken(lexer.Comment, "2024-1-5", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 10),
+			},
+		},
+		{
+			input: "#2024-12-25 release #1",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Comment, "2024-12-25 release #1", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 23),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(testCase.input).Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerMalformedDates(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected lexer.LexError
+	}{
+		{
+			input:    "#2024-13-01#",
+			expected: lexer.LexError{Message: `invalid date "2024-13-01"`, Line: 1, Column: 1, Offset: 0},
+		},
+		{
+			input:    "x := #2023-02-29#",
+			expected: lexer.LexError{Message: `invalid date "2023-02-29"`, Line: 1, Column: 6, Offset: 5},
+		},
+		{
+			input:    "#2024-1-5#",
+			expected: lexer.LexError{Message: `invalid date "2024-1-5"`, Line: 1, Column: 1, Offset: 0},
+		},
+		{
+			input:    "#2024-01-31",
+			expected: lexer.LexError{Message: "unclosed date literal", Line: 1, Column: 1, Offset: 0},
+		},
+		{
+			input:    "a\n#2024-01-31\n#",
+			expected: lexer.LexError{Message: "unclosed date literal", Line: 2, Column: 1, Offset: 2},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			_, err := lexer.NewLexer(testCase.input).Lex()
+
+			var lexErr *lexer.LexError
+			if !errors.As(err, &lexErr) {
+				t.Fatalf("expected a *lexer.LexError, got %v", err)
+			}
+
+			if *lexErr != testCase.expected {
+				t.Errorf("expected %+v, got %+v", testCase.expected, *lexErr)
+			}
+		})
+	}
+}
+
+func TestTokenTypeString(t *testing.T) {
+	expected := map[lexer.TokenType]string{
+		lexer.Text:          "Text",
+		lexer.Numeric:       "Numeric",
+		lexer.Alphanumeric:  "Alphanumeric",
+		lexer.NewLine:       "NewLine",
+		lexer.Tab:           "Tab",
+		lexer.Symbol:        "Symbol",
+		lexer.Comment:       "Comment",
+		lexer.Keyword:       "Keyword",
+		lexer.Boolean:       "Boolean",
+		lexer.Null:          "Null",
+		lexer.Currency:      "Currency",
+		lexer.Date:          "Date",
+		lexer.EOF:           "EOF",
+		lexer.TokenType(99): "TokenType(99)",
+	}
+
+	for tokenType, name := range expected {
+		if got := tokenType.String(); got != name {
+			t.Errorf("expected %q, got %q", name, got)
+		}
+	}
+}
+
+func TestTokenOperator(t *testing.T) {
+	tokens, err := lexer.Tokenize("total := (a + b) * 2 // 3 % 4 - c / d\nok := !x && y || z != 1 <= 2 ? f.g : [1, 2]; @ $")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var kinds []lexer.Operator
+	for _, token := range tokens {
+		if token.Type == lexer.Symbol {
+			kinds = append(kinds, token.Operator())
+		}
+	}
+
+	expected := []lexer.Operator{
+		lexer.Assign, lexer.LeftParen, lexer.Plus, lexer.RightParen, lexer.Star, lexer.DoubleSlash,
+		lexer.Percent, lexer.Minus, lexer.Slash,
+		lexer.Assign, lexer.Not, lexer.And, lexer.Or, lexer.NotEqual, lexer.LessEqual, lexer.Question,
+		lexer.Dot, lexer.Colon, lexer.LeftBracket, lexer.Comma, lexer.RightBracket, lexer.Semicolon,
+		lexer.OtherSymbol, lexer.OtherSymbol,
+	}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("expected operators %v, got %v", expected, kinds)
+	}
+}
+
+func TestTokenOperatorOfOtherTokens(t *testing.T) {
+	for _, token := range []lexer.Token{
+		lexer.NewToken(lexer.Text, "+", 1, 1),
+		lexer.NewToken(lexer.Alphanumeric, "plus", 1, 1),
+		lexer.NewToken(lexer.NewLine, "\n", 1, 1),
+	} {
+		if got := token.Operator(); got != lexer.NotOperator {
+			t.Errorf("%v: expected NotOperator, got %v", token, got)
+		}
+	}
+}
+
+func TestOperatorString(t *testing.T) {
+	expected := map[lexer.Operator]string{
+		lexer.NotOperator:  "NotOperator",
+		lexer.OtherSymbol:  "OtherSymbol",
+		lexer.Percent:      "Percent",
+		lexer.GreaterEqual: "GreaterEqual",
+		lexer.RightBrace:   "RightBrace",
+		lexer.Operator(99): "Operator(99)",
+	}
+
+	for operator, name := range expected {
+		if got := operator.String(); got != name {
+			t.Errorf("expected %q, got %q", name, got)
+		}
+	}
+}
+
+func TestTokenString(t *testing.T) {
+	testCases := []struct {
+		token    lexer.Token
+		expected string
+	}{
+		{token: lexer.NewToken(lexer.Numeric, "42", 3, 10), expected: `Numeric("42")@3:10`},
+		{token: lexer.NewToken(lexer.Text, "say \"hi\"", 1, 1), expected: `Text("say \"hi\"")@1:1`},
+		{token: lexer.NewToken(lexer.NewLine, "\n\n", 2, 7), expected: `NewLine("\n\n")@2:7`},
+		{token: lexer.NewToken(lexer.EOF, "", 4, 1), expected: `EOF("")@4:1`},
+	}
+
+	for _, testCase := range testCases {
+		if got := testCase.token.String(); got != testCase.expected {
+			t.Errorf("expected %s, got %s", testCase.expected, got)
+		}
+	}
+}
+
+func TestLexerReset(t *testing.T) {
+	l := lexer.NewLexer("first := 1\n")
+	l.AddKeywords("first")
+
+	first, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(first) != 5 || first[0].Type != lexer.Keyword {
+		t.Fatalf("expected the first input to lex with its keyword, got %v", first)
+	}
+
+	l.Reset(`print "second"`)
+	second, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Alphanumeric, "print", 1, 1),
+		lexer.NewToken(lexer.Text, "second", 1, 7),
+		lexer.NewToken(lexer.EOF, "", 1, 15),
+	}
+	if !reflect.DeepEqual(second, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, second)
+	}
+
+	l.Reset("first")
+	third, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if third[0].Type != lexer.Keyword {
+		t.Errorf("expected keywords to survive Reset, got %v", third[0])
+	}
+}
+
+func TestLexerStats(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected lexer.Stats
+	}{
+		{
+			input: "x := 42 # the answer\nprint \"hi\"\n",
+			expected: lexer.Stats{
+				Counts: map[lexer.TokenType]int{
+					lexer.Alphanumeric: 2,
+					lexer.Symbol:       1,
+					lexer.Numeric:      1,
+					lexer.Comment:      1,
+					lexer.NewLine:      2,
+					lexer.Text:         1,
+				},
+				Tokens: 8,
+				Bytes:  32,
+				Lines:  2,
+			},
+		},
+		{
+			input: "if a\n\tb",
+			expected: lexer.Stats{
+				Counts: map[lexer.TokenType]int{lexer.Keyword: 1, lexer.Alphanumeric: 2, lexer.NewLine: 1, lexer.Tab: 1},
+				Tokens: 5,
+				Bytes:  7,
+				Lines:  2,
+			},
+		},
+		{
+			input:    "",
+			expected: lexer.Stats{Counts: map[lexer.TokenType]int{}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.input)
+			if _, err := l.Lex(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := l.Stats(); !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("expected stats %+v, got %+v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestLexerStatsAreIncremental(t *testing.T) {
+	l := lexer.NewLexer("a b\nc")
+
+	if _, err := l.NextToken(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := l.Stats(); got.Tokens != 1 || got.Counts[lexer.Alphanumeric] != 1 || got.Bytes != 1 || got.Lines != 1 {
+		t.Errorf("expected one name on one line after the first token, got %+v", got)
+	}
+
+	if _, err := l.Lex(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := l.Stats(); got.Tokens != 4 || got.Lines != 2 {
+		t.Errorf("expected four tokens on two lines, got %+v", got)
+	}
+
+	// Further EOF tokens are not counted.
+	if _, err := l.NextToken(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := l.Stats(); got.Tokens != 4 {
+		t.Errorf("expected EOF not to be counted, got %+v", got)
+	}
+
+	l.Reset("d")
+	if got := l.Stats(); got.Tokens != 0 || got.Bytes != 0 || got.Lines != 0 {
+		t.Errorf("expected Reset to clear the stats, got %+v", got)
+	}
+}
+
+func TestLexerMinusIsASymbol(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "5-3",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Numeric, "5", 1, 1),
+				lexer.NewToken(lexer.Symbol, "-", 1, 2),
+				lexer.NewToken(lexer.Numeric, "3", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+		{
+			input: "a-1",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "-", 1, 2),
+				lexer.NewToken(lexer.Numeric, "1", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+		{
+			input: "-3",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "-", 1, 1),
+				lexer.NewToken(lexer.Numeric, "3", 1, 2),
+				lexer.NewToken(lexer.EOF, "", 1, 3),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(testCase.input).Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerPreserveWhitespace(t *testing.T) {
+	testCases := []string{
+		"x := 1   +  2\n",
+		"  indented\n\t\tif  a {\n\t  b\n}  ",
+		"total:=price*  qty\r\n",
+		"old\rmac\r\rlines",
+		"total := a + \\\n\t\tb\n",
+		"x := \"a\" # c",
+		"say(\"tab\\there \\\"quoted\\\"\")",
+		"letter := 'A' + '\\n'",
+		"query := `SELECT *\n\tFROM t`",
+		"/* a\n   block */ x := 1 // not a comment",
+		"due := #2024-01-31# # the deadline",
+		"@version 2\nx := 1",
+		"greet := \"hi ${name}!\"",
+		"ok := TRUE && Null == null",
+	}
+
+	for _, input := range testCases {
+		t.Run(input, func(t *testing.T) {
+			l := lexer.NewLexer(input)
+			l.PreserveWhitespace = true
+			l.CaseInsensitiveLiterals = true
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rebuilt strings.Builder
+			for _, token := range tokens {
+				rebuilt.WriteString(token.Lexeme())
+			}
+
+			if rebuilt.String() != input {
+				t.Errorf("expected %q, got %q from %v", input, rebuilt.String(), tokens)
+			}
+		})
+	}
+}
+
+func TestLexerWhitespaceTokens(t *testing.T) {
+	l := lexer.NewLexer("a  b")
+	l.PreserveWhitespace = true
+	tokens, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+		lexer.NewToken(lexer.Whitespace, "  ", 1, 2),
+		lexer.NewToken(lexer.Alphanumeric, "b", 1, 4),
+		lexer.NewToken(lexer.EOF, "", 1, 5),
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestLexerRawLexeme(t *testing.T) {
+	l := lexer.NewLexer(`x := "a\tb" # c`)
+	l.PreserveWhitespace = true
+	tokens, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+		lexer.NewToken(lexer.Whitespace, " ", 1, 2),
+		lexer.NewToken(lexer.Symbol, ":=", 1, 3),
+		lexer.NewToken(lexer.Whitespace, " ", 1, 5),
+		{Type: lexer.Text, Value: "a\tb", Line: 1, Column: 6, Raw: `"a\tb"`},
+		lexer.NewToken(lexer.Whitespace, " ", 1, 12),
+		{Type: lexer.Comment, Value: " c", Line: 1, Column: 13, Raw: "# c"},
+		lexer.NewToken(lexer.EOF, "", 1, 16),
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+
+	tokens, err = lexer.Tokenize(`x := "a\tb" # c`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, token := range tokens {
+		if token.Raw != "" {
+			t.Errorf("expected no raw text without PreserveWhitespace, got %q on %v", token.Raw, token)
+		}
+	}
+}
+
+func TestLexerRawText(t *testing.T) {
+	tokens, err := lexer.NewLexer("query := `SELECT *\n\tFROM \"orders\" \\n`\nx").Lex()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []lexer.Token{
+		lexer.NewToken(lexer.Alphanumeric, "query", 1, 1),
+		lexer.NewToken(lexer.Symbol, ":=", 1, 7),
+		lexer.NewToken(lexer.Text, "SELECT *\n\tFROM \"orders\" \\n", 1, 10),
+		lexer.NewToken(lexer.NewLine, "\n", 2, 19),
+		lexer.NewToken(lexer.Alphanumeric, "x", 3, 1),
+		lexer.NewToken(lexer.EOF, "", 3, 2),
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestLexerUnclosedRawText(t *testing.T) {
+	_, err := lexer.NewLexer("a\nb := `never\nclosed").Lex()
+
+	var lexErr *lexer.LexError
+	if !errors.As(err, &lexErr) {
+		t.Fatalf("expected a *lexer.LexError, got %v", err)
+	}
+
+	expected := lexer.LexError{Message: "unclosed raw string", Line: 2, Column: 6, Offset: 7}
+	if *lexErr != expected {
+		t.Errorf("expected %+v, got %+v", expected, *lexErr)
+	}
+}
+
+func TestLexerChars(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			input: "'A'",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Char, "A", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+		{
+			input: `'\n' '\''`,
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Char, "\n", 1, 1),
+				lexer.NewToken(lexer.Char, "'", 1, 6),
+				lexer.NewToken(lexer.EOF, "", 1, 10),
+			},
+		},
+		{
+			input: "'€'",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Char, "€", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(testCase.input).Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+func TestLexerMalformedChars(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected lexer.LexError
+	}{
+		{input: "''", expected: lexer.LexError{Message: "empty character literal", Line: 1, Column: 1, Offset: 0}},
+		{input: "x := 'ab'", expected: lexer.LexError{Message: "character literal holds more than one character", Line: 1, Column: 6, Offset: 5}},
+		{input: "'a", expected: lexer.LexError{Message: "unclosed character literal", Line: 1, Column: 1, Offset: 0}},
+		{input: `'\q'`, expected: lexer.LexError{Message: "unknown escape sequence \\q", Line: 1, Column: 2, Offset: 1}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.input, func(t *testing.T) {
+			_, err := lexer.NewLexer(testCase.input).Lex()
+
+			var lexErr *lexer.LexError
+			if !errors.As(err, &lexErr) {
+				t.Fatalf("expected a *lexer.LexError, got %v", err)
+			}
+
+			if *lexErr != testCase.expected {
+				t.Errorf("expected %+v, got %+v", testCase.expected, *lexErr)
+			}
+		})
+	}
+}
+
+func TestLexerDirectives(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			name:  "directive at the start of a program",
+			input: "@strict\nx := 1",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Directive, "strict", 1, 1),
+				lexer.NewToken(lexer.NewLine, "\n", 1, 8),
+				lexer.NewToken(lexer.Alphanumeric, "x", 2, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 2, 3),
+				lexer.NewToken(lexer.Numeric, "1", 2, 6),
+				lexer.NewToken(lexer.EOF, "", 2, 7),
+			},
+		},
+		{
+			name:  "directive with an argument",
+			input: "@version 2",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Directive, "version", 1, 1),
+				lexer.NewToken(lexer.Numeric, "2", 1, 10),
+				lexer.NewToken(lexer.EOF, "", 1, 11),
+			},
+		},
+		{
+			name:  "unknown directive is passed through",
+			input: "@no_such_thing",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Directive, "no_such_thing", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 15),
+			},
+		},
+		{
+			name:  "at sign followed by a digit",
+			input: "@1",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "@", 1, 1),
+				lexer.NewToken(lexer.Numeric, "1", 1, 2),
+				lexer.NewToken(lexer.EOF, "", 1, 3),
+			},
+		},
+		{
+			name:  "at sign followed by a space",
+			input: "@ strict",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "@", 1, 1),
+				lexer.NewToken(lexer.Alphanumeric, "strict", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 9),
+			},
+		},
+		{
+			name:  "lone at sign",
+			input: "@",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Symbol, "@", 1, 1),
+				lexer.NewToken(lexer.EOF, "", 1, 2),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(testCase.input).Lex()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
+
+// benchmarkSource is a few kilobytes of typical MBL: assignments, text with
+// escapes, nested blocks, comments, calls and records.
+var benchmarkSource = strings.Repeat(`# Monthly invoice run
+function line_total(quantity, unit_price) {
+	return quantity * unit_price
+}
+
+customers := [{name: "Ada", balance: 120.50}, {name: "Grace", balance: 0}]
+for customer in customers {
+	if customer.balance > 0 {
+		print "Invoice for \"" + customer.name + "\":\t" + format_money(customer.balance, "$")
+	} else {
+		print "Nothing due for " + customer.name
+	}
+}
+
+total := 0
+i := 0
+while i < 10 {
+	total := total + line_total(i, 2.25)
+	i := i + 1
+}
+
+
+`, 12)
+
+func BenchmarkLex(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, err := lexer.NewLexer(benchmarkSource).Lex()
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkLexReset(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	b.ReportAllocs()
+
+	l := lexer.NewLexer("")
+	for i := 0; i < b.N; i++ {
+		l.Reset(benchmarkSource)
+		_, err := l.Lex()
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestLexerLineContinuation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		tokens []lexer.Token
+	}{
+		{
+			name:  "continued expression",
+			input: "total := price \\\n\t* qty\n",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "total", 1, 1),
+				lexer.NewToken(lexer.Symbol, ":=", 1, 7),
+				lexer.NewToken(lexer.Alphanumeric, "price", 1, 10),
+				lexer.NewToken(lexer.Symbol, "*", 2, 2),
+				lexer.NewToken(lexer.Alphanumeric, "qty", 2, 4),
+				lexer.NewToken(lexer.NewLine, "\n", 2, 7),
+				lexer.NewToken(lexer.EOF, "", 3, 1),
+			},
+		},
+		{
+			name:  "continued with a carriage return",
+			input: "a +\\\r\nb",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "+", 1, 3),
+				lexer.NewToken(lexer.Alphanumeric, "b", 2, 1),
+				lexer.NewToken(lexer.EOF, "", 2, 2),
+			},
+		},
+		{
+			name:  "stray backslash",
+			input: "a \\ b",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "\\", 1, 3),
+				lexer.NewToken(lexer.Alphanumeric, "b", 1, 5),
+				lexer.NewToken(lexer.EOF, "", 1, 6),
+			},
+		},
+		{
+			name:  "backslash at the end of the input",
+			input: "a \\",
+			tokens: []lexer.Token{
+				lexer.NewToken(lexer.Alphanumeric, "a", 1, 1),
+				lexer.NewToken(lexer.Symbol, "\\", 1, 3),
+				lexer.NewToken(lexer.EOF, "", 1, 4),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tokens, err := lexer.Tokenize(testCase.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.tokens) {
+				t.Errorf("expected tokens %v, got %v", testCase.tokens, tokens)
+			}
+		})
+	}
+}
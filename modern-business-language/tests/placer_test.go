@@ -0,0 +1,1438 @@
+// tests/placer_test.go
+
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+	"github.com/Solifugus/mbl/pkg/placer"
+)
+
+func TestPlacerRoot(t *testing.T) {
+	tokens := []lexer.Token{
+		lexer.NewToken(lexer.Alphanumeric, "x", 1, 1),
+		lexer.NewToken(lexer.Symbol, ":=", 1, 3),
+		lexer.NewToken(lexer.Numeric, "42", 1, 6),
+		lexer.NewToken(lexer.EOF, "", 1, 8),
+	}
+
+	p := placer.NewPlacer()
+	err := p.PlaceTokens(tokens)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	root := p.Root()
+	if root.Type != placer.Root {
+		t.Fatalf("expected a Root node, got %v", root.Type)
+	}
+
+	if len(root.Children) != 1 || root.Children[0].Type != placer.Statement {
+		t.Fatalf("expected a single Statement child, got %s", describe(root))
+	}
+
+	statement := root.Children[0]
+	if statement.Parent != root {
+		t.Errorf("expected the statement's parent to be the root")
+	}
+
+	if len(statement.Children) != 3 {
+		t.Fatalf("expected 3 children, got %d", len(statement.Children))
+	}
+
+	for i, child := range statement.Children {
+		if child.Type != placer.Leaf {
+			t.Errorf("child %d: expected a Leaf node, got %v", i, child.Type)
+		}
+		if child.Token != tokens[i] || child.Value != tokens[i].Value {
+			t.Errorf("child %d: expected token %v, got %v", i, tokens[i], child.Token)
+		}
+		if child.Parent != statement {
+			t.Errorf("child %d: expected parent to be the statement", i)
+		}
+	}
+}
+
+// describe renders a node tree compactly, e.g. "Root[a Block[b]]", for shape assertions.
+func describe(node *placer.Node) string {
+	if node.Type == placer.Leaf {
+		return strings.ReplaceAll(node.Value, "\n", "⏎")
+	}
+	if node.Type == placer.BlankLines {
+		return "BlankLines(" + node.Value + ")"
+	}
+
+	parts := make([]string, len(node.Children))
+	for i, child := range node.Children {
+		parts[i] = describe(child)
+	}
+
+	return node.Type.String() + "[" + strings.Join(parts, " ") + "]"
+}
+
+func commentValues(comments []lexer.Token) string {
+	values := make([]string, len(comments))
+	for i, comment := range comments {
+		values[i] = comment.Value
+	}
+	return strings.Join(values, "|")
+}
+
+func placeSource(t *testing.T, p *placer.Placer, source string) error {
+	t.Helper()
+
+	tokens, err := lexer.Tokenize(source)
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+	return p.PlaceTokens(tokens)
+}
+
+func placeLexed(t *testing.T, p *placer.Placer, l *lexer.Lexer) error {
+	t.Helper()
+
+	tokens, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+	return p.PlaceTokens(tokens)
+}
+
+func TestPlacerIndentation(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{
+			source:   "a\n\tb\n\t\tc\nd",
+			expected: "Root[Statement[a Block[Statement[b Block[Statement[c]]]]] Statement[d]]",
+		},
+		{
+			source:   "a\n\tb\n\n\tc\n",
+			expected: "Root[Statement[a Block[Statement[b] Statement[c]]]]",
+		},
+		{
+			source:   "if x\n\ty\n# note\n\tz",
+			expected: "Root[Statement[if x Block[Statement[y] Statement[ note] Statement[z]]]]",
+		},
+		{
+			source:   "if x\n\ty\n  /* a */ # b\n\tz\nw",
+			expected: "Root[Statement[if x Block[Statement[y] Statement[ a   b] Statement[z]]] Statement[w]]",
+		},
+		{
+			source:   "if x\n\ty\n\t\t# note\nz",
+			expected: "Root[Statement[if x Block[Statement[y]]] Statement[ note] Statement[z]]",
+		},
+		{
+			source:   "if x\n\ty\n# note\n",
+			expected: "Root[Statement[if x Block[Statement[y] Statement[ note]]]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			p := placer.NewPlacer()
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlacerBadDedent(t *testing.T) {
+	err := placeSource(t, placer.NewPlacer(), "a\n\t\tb\n\tc\n")
+
+	var placeErr *placer.PlaceError
+	if !errors.As(err, &placeErr) {
+		t.Fatalf("expected a *placer.PlaceError, got %v", err)
+	}
+
+	if placeErr.Line != 3 {
+		t.Errorf("expected the error on line 3, got line %d", placeErr.Line)
+	}
+}
+
+func TestPlacerUnexpectedIndent(t *testing.T) {
+	testCases := []struct {
+		name   string
+		source string
+		line   int
+	}{
+		{name: "leading tab on the first line", source: "\tx := 1\ny := 2\n", line: 1},
+		{name: "leading tab after blank lines", source: "\n\n\tx := 1\n", line: 3},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := placeSource(t, placer.NewPlacer(), testCase.source)
+
+			var placeErr *placer.PlaceError
+			if !errors.As(err, &placeErr) {
+				t.Fatalf("expected a *placer.PlaceError, got %v", err)
+			}
+
+			if placeErr.Message != "unexpected indent" || placeErr.Line != testCase.line {
+				t.Errorf("expected unexpected indent on line %d, got %v", testCase.line, err)
+			}
+		})
+	}
+}
+
+func TestPlacerBlankLines(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     placer.Mode
+		source   string
+		expected string
+	}{
+		{
+			name:     "between statements",
+			source:   "a\n\n\nb\n",
+			expected: "Root[Statement[a] BlankLines(2) Statement[b]]",
+		},
+		{
+			name:     "single line break",
+			source:   "a\nb\n",
+			expected: "Root[Statement[a] Statement[b]]",
+		},
+		{
+			name:     "at the start of the input",
+			source:   "\n\na",
+			expected: "Root[BlankLines(2) Statement[a]]",
+		},
+		{
+			name:     "inside a block",
+			source:   "if a\n\tb\n\n\tc\n",
+			expected: "Root[Statement[if a Block[Statement[b] BlankLines(1) Statement[c]]]]",
+		},
+		{
+			name:     "before the first line of a block",
+			source:   "if a\n\n\tb\n",
+			expected: "Root[Statement[if a Block[BlankLines(1) Statement[b]]]]",
+		},
+		{
+			name:     "line holding only tabs",
+			source:   "if a\n\tb\n\t\n\tc\n",
+			expected: "Root[Statement[if a Block[Statement[b] BlankLines(1) Statement[c]]]]",
+		},
+		{
+			name:     "brace mode",
+			mode:     placer.BraceMode,
+			source:   "if a {\n\tb\n\n\n\tc\n}\n\nd",
+			expected: "Root[Statement[if a Block[Statement[b] BlankLines(2) Statement[c]]] BlankLines(1) Statement[d]]",
+		},
+		{
+			name:     "carriage returns",
+			source:   "a\r\n\r\n\r\nb",
+			expected: "Root[Statement[a] BlankLines(2) Statement[b]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			p.PreserveBlankLines = true
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlacerBlankLinesOffByDefault(t *testing.T) {
+	p := placer.NewPlacer()
+	err := placeSource(t, p, "a\n\n\nb\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "Root[Statement[a] Statement[b]]"
+	if got := describe(p.Root()); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestPlacerFunctionDef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     placer.Mode
+		source   string
+		expected string
+	}{
+		{
+			name:     "braces",
+			mode:     placer.BraceMode,
+			source:   "function add(a, b) {\n\treturn a + b\n}\nprint add(1, 2)",
+			expected: "Root[FunctionDef[add Parameters[a b] Block[Statement[return a + b]]] Statement[print add ( 1 , 2 )]]",
+		},
+		{
+			name:     "indentation",
+			source:   "function greet()\n\tprint \"hi\"\n",
+			expected: "Root[FunctionDef[greet Parameters[] Block[Statement[print hi]]]]",
+		},
+		{
+			name:     "nested",
+			mode:     placer.BraceMode,
+			source:   "function outer() {\n\tfunction inner(x) { return x }\n}",
+			expected: "Root[FunctionDef[outer Parameters[] Block[FunctionDef[inner Parameters[x] Block[Statement[return x]]]]]]",
+		},
+		{
+			name:     "comment before the body",
+			source:   "function id(x) # identity\n\treturn x\n",
+			expected: "Root[FunctionDef[id Parameters[x] Block[Statement[return x]]]]",
+		},
+		{
+			name:     "missing name",
+			mode:     placer.BraceMode,
+			source:   "function (a) { }",
+			expected: "Root[Statement[function ( a ) Block[]]]",
+		},
+		{
+			name:     "trailing comma",
+			mode:     placer.BraceMode,
+			source:   "function f(a,) { }",
+			expected: "Root[Statement[function f ( a , ) Block[]]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlacerFunctionDefFields(t *testing.T) {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err := placeSource(t, p, "function total(price, qty) {\n\treturn price * qty\n}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	definition := p.Root().Children[0]
+	if definition.Type != placer.FunctionDef || definition.Value != "total" || definition.Parent != p.Root() {
+		t.Fatalf("expected a FunctionDef named total under the root, got %s %q", definition.Type, definition.Value)
+	}
+	if definition.Start.Value != "function" || definition.End.Value != "}" {
+		t.Errorf("expected the definition to span function through }, got %v to %v", definition.Start, definition.End)
+	}
+
+	params := definition.Children[1]
+	if params.Type != placer.Parameters || params.Parent != definition {
+		t.Fatalf("expected Parameters as the second child, got %s", params.Type)
+	}
+	if params.Start.Value != "(" || params.End.Value != ")" {
+		t.Errorf("expected the parameters to span the parentheses, got %v to %v", params.Start, params.End)
+	}
+}
+
+func TestPlacerFunctionDocComment(t *testing.T) {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err := placeSource(t, p, "# Total returns the price of qty items.\n# It does not round.\nfunction total(price, qty) {\n\treturn price * qty\n} # total")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	definition := p.Root().Children[len(p.Root().Children)-1]
+	if definition.Type != placer.FunctionDef {
+		t.Fatalf("expected the last child to be a FunctionDef, got %s", definition.Type)
+	}
+	if got := commentValues(definition.LeadingComments); got != " Total returns the price of qty items.| It does not round." {
+		t.Errorf("expected the doc comment to lead the definition, got %q", got)
+	}
+	if got := commentValues(definition.TrailingComments); got != " total" {
+		t.Errorf("expected the comment after } to trail the definition, got %q", got)
+	}
+}
+
+func TestPlacerComments(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		mode     placer.Mode
+		leading  string
+		trailing string
+	}{
+		{name: "trailing", source: "x := 1 # one", trailing: " one"},
+		{name: "leading", source: "# the count\nx := 1", leading: " the count"},
+		{name: "leading and trailing", source: "# the count\nx := 1 # one", leading: " the count", trailing: " one"},
+		{name: "several trailing", source: "x := 1 /* a */ # b", trailing: " a | b"},
+		{name: "comment inside the statement", source: "x := /* a */ 1"},
+		{name: "blank line detaches", source: "# stray\n\nx := 1"},
+		{name: "blank line between comments", source: "# stray\n\n# the count\nx := 1", leading: " the count"},
+		{name: "block comment", source: "/* the\ncount */\nx := 1", leading: " the\ncount "},
+		{name: "comment on the next line", source: "y := 2\n# the count\nx := 1", leading: " the count"},
+		{name: "nested statement", source: "if x {\n\t# say it\n\tprint x # loudly\n}", mode: placer.BraceMode, leading: " say it", trailing: " loudly"},
+		{name: "nested statement in indent mode", source: "if x\n\t# say it\n\tprint x # loudly", leading: " say it", trailing: " loudly"},
+		{name: "unindented comment in indent mode", source: "if x\n\ty\n# say it\n\tprint x", leading: " say it"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The statement under test is the last one, in the innermost block.
+			statement := p.Root().Children[len(p.Root().Children)-1]
+			for last := statement.Children[len(statement.Children)-1]; last.Type == placer.Block; last = statement.Children[len(statement.Children)-1] {
+				statement = last.Children[len(last.Children)-1]
+			}
+
+			if got := commentValues(statement.LeadingComments); got != testCase.leading {
+				t.Errorf("expected leading comments %q, got %q", testCase.leading, got)
+			}
+			if got := commentValues(statement.TrailingComments); got != testCase.trailing {
+				t.Errorf("expected trailing comments %q, got %q", testCase.trailing, got)
+			}
+		})
+	}
+}
+
+func TestPlacerCommentsStripped(t *testing.T) {
+	l := lexer.NewLexer("# the count\nx := 1 # one")
+	l.StripComments = true
+	p := placer.NewPlacer()
+	err := placeLexed(t, p, l)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	statement := p.Root().Children[0]
+	if statement.LeadingComments != nil || statement.TrailingComments != nil {
+		t.Errorf("expected no comments, got %v and %v", statement.LeadingComments, statement.TrailingComments)
+	}
+}
+
+func TestPlacerDirectives(t *testing.T) {
+	testCases := []struct {
+		name       string
+		mode       placer.Mode
+		source     string
+		directives string
+		expected   string
+	}{
+		{
+			name:       "at the start of a program",
+			source:     "@strict\nx := 1",
+			directives: "strict[]",
+			expected:   "Root[Statement[x := 1]]",
+		},
+		{
+			name:       "with an argument and a comment",
+			mode:       placer.BraceMode,
+			source:     "@version 2 # the second edition\n@strict\nx := 1",
+			directives: "strict[] version[2]",
+			expected:   "Root[Statement[x := 1]]",
+		},
+		{
+			name:       "after an indented block",
+			source:     "if x\n\ty\n@strict\nz",
+			directives: "strict[]",
+			expected:   "Root[Statement[if x Block[Statement[y]]] Statement[z]]",
+		},
+		{
+			name:       "after a braced block",
+			mode:       placer.BraceMode,
+			source:     "if x {\n\ty\n}\n@strict\nz",
+			directives: "strict[]",
+			expected:   "Root[Statement[if x Block[Statement[y]]] Statement[z]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var directives []string
+			for _, directive := range p.Directives() {
+				args := make([]string, len(directive.Args))
+				for i, arg := range directive.Args {
+					args[i] = arg.Value
+				}
+				directives = append(directives, directive.Name+"["+strings.Join(args, " ")+"]")
+			}
+			sort.Strings(directives)
+			if got := strings.Join(directives, " "); got != testCase.directives {
+				t.Errorf("expected directives %s, got %s", testCase.directives, got)
+			}
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlacerDirectiveErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		mode   placer.Mode
+		source string
+		err    string
+	}{
+		{name: "unknown", source: "@no_such_thing", err: "place error at line 1 col 1: unknown directive @no_such_thing"},
+		{name: "after code", source: "x := 1 @strict", err: "place error at line 1 col 8: directive @strict must start a line outside any block"},
+		{name: "inside braces", mode: placer.BraceMode, source: "if x {\n@strict\n}", err: "place error at line 2 col 1: directive @strict must start a line outside any block"},
+		{name: "inside parentheses", mode: placer.BraceMode, source: "f(\n@strict)", err: "place error at line 2 col 1: directive @strict must start a line outside any block"},
+		{name: "indented", source: "if x\n\t@strict", err: "place error at line 2 col 2: directive @strict must start a line outside any block"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			err := placeSource(t, p, testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestPlacerAddDirectives(t *testing.T) {
+	p := placer.NewPlacer()
+	p.AddDirectives("currency")
+	err := placeSource(t, p, "@currency EUR\n@strict")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	directives := p.Directives()
+	if len(directives) != 2 || directives[0].Name != "currency" || directives[0].Args[0].Value != "EUR" {
+		t.Errorf("expected @currency EUR and then @strict, got %v", directives)
+	}
+}
+
+func TestPlacerBraces(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{
+			source:   "a { b { c } } d",
+			expected: "Root[Statement[a Block[Statement[b Block[Statement[c]]]] d]]",
+		},
+		{
+			source:   "if x {\n\ty\n}",
+			expected: "Root[Statement[if x Block[Statement[y]]]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = placer.BraceMode
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlacerSemicolons(t *testing.T) {
+	testCases := []struct {
+		mode     placer.Mode
+		source   string
+		expected string
+	}{
+		{
+			mode:     placer.BraceMode,
+			source:   "x := 1; y := 2\nz := 3",
+			expected: "Root[Statement[x := 1] Statement[y := 2] Statement[z := 3]]",
+		},
+		{
+			mode:     placer.BraceMode,
+			source:   ";a;; b;\n;\nc;",
+			expected: "Root[Statement[a] Statement[b] Statement[c]]",
+		},
+		{
+			mode:     placer.BraceMode,
+			source:   "if x { a; b }; c",
+			expected: "Root[Statement[if x Block[Statement[a] Statement[b]]] Statement[c]]",
+		},
+		{
+			mode:     placer.IndentMode,
+			source:   "if x\n\ta; b;\nc; d",
+			expected: "Root[Statement[if x Block[Statement[a] Statement[b]]] Statement[c] Statement[d]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlacerUnbalancedBraces(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected placer.PlaceError
+	}{
+		{
+			source:   "a }",
+			expected: placer.PlaceError{Message: "found '}' with no open '{'", Line: 1, Column: 3},
+		},
+		{
+			source:   "x := 1)",
+			expected: placer.PlaceError{Message: "found ')' with no open '('", Line: 1, Column: 7},
+		},
+		{
+			source:   "a {\n\tb { c }\n",
+			expected: placer.PlaceError{Message: "expected '}' to close '{' at line 1 col 3, found end of input", Line: 3, Column: 1},
+		},
+		{
+			source:   "if ok {\n\tf(1, 2\n}",
+			expected: placer.PlaceError{Message: "expected ')' to close '(' at line 2 col 3, found '}'", Line: 3, Column: 1},
+		},
+		{
+			source:   "if ok {\n\tprint 1)",
+			expected: placer.PlaceError{Message: "expected '}' to close '{' at line 1 col 7, found ')'", Line: 2, Column: 9},
+		},
+		{
+			source:   "x := [1, 2}",
+			expected: placer.PlaceError{Message: "expected ']' to close '[' at line 1 col 6, found '}'", Line: 1, Column: 11},
+		},
+		{
+			source:   "x := f([1, {a: 2}]",
+			expected: placer.PlaceError{Message: "expected ')' to close '(' at line 1 col 7, found end of input", Line: 1, Column: 19},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = placer.BraceMode
+			err := placeSource(t, p, testCase.source)
+
+			var placeErr *placer.PlaceError
+			if !errors.As(err, &placeErr) {
+				t.Fatalf("expected a *placer.PlaceError, got %v", err)
+			}
+
+			if *placeErr != testCase.expected {
+				t.Errorf("expected %+v, got %+v", testCase.expected, *placeErr)
+			}
+		})
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files with the current output")
+
+func TestPlacerCollectErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     placer.Mode
+		source   string
+		errors   []string
+		expected string
+	}{
+		{
+			name:   "brace mode",
+			mode:   placer.BraceMode,
+			source: "a := 1)\nb := 2\nc := }\nd := 4",
+			errors: []string{
+				"place error at line 1 col 7: found ')' with no open '('",
+				"place error at line 3 col 6: found '}' with no open '{'",
+			},
+			expected: "Root[Statement[a := 1] Statement[b := 2] Statement[c :=] Statement[d := 4]]",
+		},
+		{
+			name:   "indentation mode",
+			source: "\ta\nb\n\t\tc\n\td\ne\n",
+			errors: []string{
+				"place error at line 1 col 1: unexpected indent",
+				"place error at line 4 col 1: inconsistent dedent to level 1",
+			},
+			expected: "Root[Statement[b Block[Statement[c]]] Statement[e]]",
+		},
+		{
+			name:   "unclosed at the end",
+			mode:   placer.BraceMode,
+			source: "x := ]\ny := (1",
+			errors: []string{
+				"place error at line 1 col 6: found ']' with no open '['",
+				"place error at line 2 col 8: expected ')' to close '(' at line 2 col 6, found end of input",
+			},
+			expected: "Root[Statement[x :=] Statement[y := ( 1]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			p.CollectErrors = true
+			err := placeSource(t, p, testCase.source)
+
+			if expected := strings.Join(testCase.errors, "\n"); err == nil || err.Error() != expected {
+				t.Fatalf("expected errors %q, got %v", expected, err)
+			}
+			var placeErr *placer.PlaceError
+			if !errors.As(err, &placeErr) {
+				t.Errorf("expected a *placer.PlaceError in %v", err)
+			}
+
+			collected := p.Errors()
+			if len(collected) != len(testCase.errors) {
+				t.Fatalf("expected %d errors, got %v", len(testCase.errors), collected)
+			}
+			for i, message := range testCase.errors {
+				if collected[i].Error() != message {
+					t.Errorf("error %d: expected %q, got %q", i, message, collected[i].Error())
+				}
+			}
+
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlacerErrorsStopsAtFirstByDefault(t *testing.T) {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err := placeSource(t, p, "a := 1)\nc := }")
+
+	expected := "place error at line 1 col 7: found ')' with no open '('"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+	if collected := p.Errors(); len(collected) != 1 || collected[0] != err {
+		t.Errorf("expected only the returned error, got %v", collected)
+	}
+
+	if err := placeSource(t, p, "a := 1"); err != nil || len(p.Errors()) != 0 {
+		t.Errorf("expected no errors after a clean run, got %v and %v", err, p.Errors())
+	}
+}
+
+// nestedBlocks returns source nesting depth blocks, in braces or by indentation.
+func nestedBlocks(mode placer.Mode, depth int) string {
+	if mode == placer.BraceMode {
+		return strings.Repeat("a {", depth) + strings.Repeat("}", depth)
+	}
+
+	var source strings.Builder
+	for level := 0; level <= depth; level++ {
+		source.WriteString(strings.Repeat("\t", level) + "a\n")
+	}
+	return source.String()
+}
+
+func TestPlacerMaxDepth(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     placer.Mode
+		maxDepth int
+		depth    int
+		err      string
+	}{
+		{name: "braces at the default limit", mode: placer.BraceMode, maxDepth: placer.DefaultMaxDepth, depth: 256},
+		{name: "braces beyond the default limit", mode: placer.BraceMode, maxDepth: placer.DefaultMaxDepth, depth: 257, err: "place error at line 1 col 771: blocks nested more than 256 deep"},
+		{name: "braces beyond a lower limit", mode: placer.BraceMode, maxDepth: 2, depth: 3, err: "place error at line 1 col 9: blocks nested more than 2 deep"},
+		{name: "indentation at the limit", maxDepth: 2, depth: 2},
+		{name: "indentation beyond the limit", maxDepth: 2, depth: 3, err: "place error at line 4 col 1: blocks nested more than 2 deep"},
+		{name: "no limit", mode: placer.BraceMode, maxDepth: 0, depth: 1000},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			p.MaxDepth = testCase.maxDepth
+			err := placeSource(t, p, nestedBlocks(testCase.mode, testCase.depth))
+
+			if testCase.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error for depth %d", testCase.depth)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestPlacerMaxDepthBrackets(t *testing.T) {
+	testCases := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "parentheses at the limit", source: "x := " + strings.Repeat("(", 2) + "1" + strings.Repeat(")", 2)},
+		{name: "parentheses beyond the limit", source: "x := " + strings.Repeat("(", 3) + "1" + strings.Repeat(")", 3), err: "place error at line 1 col 8: brackets nested more than 2 deep"},
+		{name: "lists beyond the limit", source: "x := " + strings.Repeat("[", 3) + "1" + strings.Repeat("]", 3), err: "place error at line 1 col 8: brackets nested more than 2 deep"},
+		{name: "block inside parentheses", source: "x := f(g({a: 1}))", err: "place error at line 1 col 10: blocks nested more than 2 deep"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = placer.BraceMode
+			p.MaxDepth = 2
+			err := placeSource(t, p, testCase.source)
+
+			if testCase.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %v", testCase.err, err)
+			}
+		})
+	}
+}
+
+func TestPlacerMaxDepthByDefault(t *testing.T) {
+	if p := placer.NewPlacer(); p.MaxDepth != placer.DefaultMaxDepth {
+		t.Errorf("expected MaxDepth to default to %d, got %d", placer.DefaultMaxDepth, p.MaxDepth)
+	}
+}
+
+func TestPlacerMaxDepthStopsCollectingErrors(t *testing.T) {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	p.MaxDepth = 2
+	p.CollectErrors = true
+	err := placeSource(t, p, "x := )\n"+nestedBlocks(placer.BraceMode, 4)+"\ny := ]")
+
+	expected := []string{
+		"place error at line 1 col 6: found ')' with no open '('",
+		"place error at line 2 col 9: blocks nested more than 2 deep",
+	}
+	if want := strings.Join(expected, "\n"); err == nil || err.Error() != want {
+		t.Errorf("expected errors %q, got %v", want, err)
+	}
+	if collected := p.Errors(); len(collected) != len(expected) {
+		t.Errorf("expected placing to stop at the depth error, got %v", collected)
+	}
+}
+
+func TestPlacerToJSON(t *testing.T) {
+	p := placer.NewPlacer()
+	err := placeSource(t, p, "total := 1\nif total\n\tprint \"one\"\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := p.ToJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	golden := filepath.Join("testdata", "placer_tree.json")
+	if *updateGolden {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+	}
+
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+
+	if !bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(expected)) {
+		t.Errorf("JSON does not match %s:\n%s", golden, got)
+	}
+}
+
+func TestPlacerStatements(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		mode     placer.Mode
+		expected string
+	}{
+		{
+			name:     "three lines",
+			source:   "x := 1\ny := 2\nprint x\n",
+			expected: "Root[Statement[x := 1] Statement[y := 2] Statement[print x]]",
+		},
+		{
+			name:     "blank lines",
+			source:   "\n\nx := 1\n\n\t\n\ny := 2",
+			expected: "Root[Statement[x := 1] Statement[y := 2]]",
+		},
+		{
+			name:     "braces continue the statement",
+			source:   "if x {\n\ta\n\tb\n} else {\n\tc\n}\nd",
+			mode:     placer.BraceMode,
+			expected: "Root[Statement[if x Block[Statement[a] Statement[b]] else Block[Statement[c]]] Statement[d]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := placer.NewPlacer()
+			p.Mode = testCase.mode
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestNodeTree(t *testing.T) {
+	p := placer.NewPlacer()
+	err := placeSource(t, p, "a\n\tb \"two words\"\n\t\tc\nd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := strings.Join([]string{
+		"Root",
+		"  Statement",
+		`    Leaf "a"`,
+		"    Block",
+		"      Statement",
+		`        Leaf "b"`,
+		`        Leaf "two words"`,
+		"        Block",
+		"          Statement",
+		`            Leaf "c"`,
+		"  Statement",
+		`    Leaf "d"`,
+	}, "\n") + "\n"
+
+	if got := p.Root().Tree(); got != expected {
+		t.Errorf("expected tree:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestPlacerIgnoresWhitespace(t *testing.T) {
+	l := lexer.NewLexer("a  :=  1\n\t  b\n  \nc")
+	l.PreserveWhitespace = true
+	tokens, err := l.Lex()
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+
+	p := placer.NewPlacer()
+	err = p.PlaceTokens(tokens)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "Root[Statement[a := 1 Block[Statement[b]]] Statement[c]]"
+	if got := describe(p.Root()); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestPlacerSpacesPerIndent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "tabs",
+			source:   "if a\n\tb\n\tif c\n\t\td\ne",
+			expected: "Root[Statement[if a Block[Statement[b] Statement[if c Block[Statement[d]]]]] Statement[e]]",
+		},
+		{
+			name:     "spaces",
+			source:   "if a\n    b\n    if c\n        d\ne",
+			expected: "Root[Statement[if a Block[Statement[b] Statement[if c Block[Statement[d]]]]] Statement[e]]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.source)
+			l.PreserveWhitespace = true
+
+			p := placer.NewPlacer()
+			p.SpacesPerIndent = 4
+			p.RejectMixedIndent = true
+			err := placeLexed(t, p, l)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := describe(p.Root()); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlacerRejectMixedIndent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected placer.PlaceError
+	}{
+		{
+			name:     "tabs and spaces on one line",
+			source:   "if a\n\t    b",
+			expected: placer.PlaceError{Message: "indentation mixes tabs and spaces", Line: 2, Column: 1},
+		},
+		{
+			name:     "spaces in a tab block",
+			source:   "if a\n\tb\n    c",
+			expected: placer.PlaceError{Message: "line indented with spaces in a block indented with tabs", Line: 3, Column: 1},
+		},
+		{
+			name:     "tabs in a space block",
+			source:   "if a\n    b\n\tc",
+			expected: placer.PlaceError{Message: "line indented with tabs in a block indented with spaces", Line: 3, Column: 1},
+		},
+		{
+			name:     "partial indent",
+			source:   "if a\n      b",
+			expected: placer.PlaceError{Message: "indentation of 6 spaces is not a multiple of 4", Line: 2, Column: 1},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.source)
+			l.PreserveWhitespace = true
+
+			p := placer.NewPlacer()
+			p.SpacesPerIndent = 4
+			p.RejectMixedIndent = true
+			err := placeLexed(t, p, l)
+
+			var placeErr *placer.PlaceError
+			if !errors.As(err, &placeErr) {
+				t.Fatalf("expected a *placer.PlaceError, got %v", err)
+			}
+			if *placeErr != testCase.expected {
+				t.Errorf("expected %+v, got %+v", testCase.expected, *placeErr)
+			}
+		})
+	}
+}
+
+func TestPlacerMixedIndentAllowedByDefault(t *testing.T) {
+	l := lexer.NewLexer("if a\n\tb\n    c")
+	l.PreserveWhitespace = true
+
+	p := placer.NewPlacer()
+	p.SpacesPerIndent = 4
+	err := placeLexed(t, p, l)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "Root[Statement[if a Block[Statement[b] Statement[c]]]]"
+	if got := describe(p.Root()); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+// nodeCounter is a placer.Visitor that tallies the nodes it sees by type.
+type nodeCounter struct {
+	total  int
+	byType map[placer.NodeType]int
+}
+
+func (c *nodeCounter) VisitNode(node *placer.Node) error {
+	c.total++
+	c.byType[node.Type]++
+	return nil
+}
+
+func TestWalkCountsNodes(t *testing.T) {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err := placeSource(t, p, "if x {\n\tprint 1\n}\ny := 2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	counter := &nodeCounter{byType: map[placer.NodeType]int{}}
+	err = placer.Walk(p.Root(), counter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if counter.total != 12 {
+		t.Errorf("expected 12 nodes, got %d", counter.total)
+	}
+
+	expected := map[placer.NodeType]int{placer.Root: 1, placer.Statement: 3, placer.Block: 1, placer.Leaf: 7}
+	for nodeType, count := range expected {
+		if counter.byType[nodeType] != count {
+			t.Errorf("expected %d %v nodes, got %d", count, nodeType, counter.byType[nodeType])
+		}
+	}
+}
+
+func TestWalkStopsOnError(t *testing.T) {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err := placeSource(t, p, "a b\nc d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stop := errors.New("stop")
+	var visited []string
+	err = placer.Walk(p.Root(), placer.VisitorFunc(func(node *placer.Node) error {
+		visited = append(visited, node.Value)
+		if node.Value == "b" {
+			return stop
+		}
+		return nil
+	}))
+
+	if err != stop {
+		t.Errorf("expected the visitor's error, got %v", err)
+	}
+	if strings.Join(visited, ",") != ",,a,b" {
+		t.Errorf("expected the walk to stop at b, visited %q", visited)
+	}
+}
+
+// span renders a node's source span as "line:column-line:column".
+func span(node *placer.Node) string {
+	return fmt.Sprintf("%d:%d-%d:%d", node.Start.Line, node.Start.Column, node.End.Line, node.End.Column)
+}
+
+func TestNodeSpans(t *testing.T) {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err := placeSource(t, p, "if ok {\n\ttotal := (price + 2) * -qty[0]\n}\nx := 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	root := p.Root()
+	ifStatement := root.Children[0]
+	block := ifStatement.Children[2]
+	assignment := block.Children[0]
+
+	placed := []struct {
+		name     string
+		node     *placer.Node
+		expected string
+	}{
+		{name: "root", node: root, expected: "1:1-4:6"},
+		{name: "if statement", node: ifStatement, expected: "1:1-3:1"},
+		{name: "block", node: block, expected: "1:7-3:1"},
+		{name: "assignment", node: assignment, expected: "2:2-2:31"},
+		{name: "leaf", node: assignment.Children[0], expected: "2:2-2:2"},
+	}
+	for _, testCase := range placed {
+		if got := span(testCase.node); got != testCase.expected {
+			t.Errorf("expected %s to span %s, got %s", testCase.name, testCase.expected, got)
+		}
+	}
+
+	expression, err := placer.ParseExpression(assignment.Children[2:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	product := expression
+	sum := product.Children[0]
+	negation := product.Children[1]
+	index := negation.Children[0]
+
+	parsed := []struct {
+		name     string
+		node     *placer.Node
+		expected string
+	}{
+		{name: "product", node: product, expected: "2:11-2:31"},
+		{name: "grouped sum", node: sum, expected: "2:11-2:21"},
+		{name: "left operand", node: sum.Children[0], expected: "2:12-2:12"},
+		{name: "negation", node: negation, expected: "2:25-2:31"},
+		{name: "index", node: index, expected: "2:26-2:31"},
+	}
+	for _, testCase := range parsed {
+		if got := span(testCase.node); got != testCase.expected {
+			t.Errorf("expected %s to span %s, got %s", testCase.name, testCase.expected, got)
+		}
+	}
+}
+
+func TestParseConditional(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "a ? b : c", expected: "ConditionalExpr[a b c]"},
+		{source: "a == 1 ? b + 1 : c", expected: "ConditionalExpr[BinaryExpr[a 1] BinaryExpr[b 1] c]"},
+		{source: "a ? b : c ? d : e", expected: "ConditionalExpr[a b ConditionalExpr[c d e]]"},
+		{source: "a ? b ? c : d : e", expected: "ConditionalExpr[a ConditionalExpr[b c d] e]"},
+		{source: "a || b ? c : d", expected: "ConditionalExpr[BinaryExpr[a b] c d]"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			expression := parseSource(t, testCase.source)
+			if got := describe(expression); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseLambda(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "fn(x) -> x * 2", expected: "LambdaExpr[Parameters[x] BinaryExpr[x 2]]"},
+		{source: "fn(a, b) -> a ? b : 0", expected: "LambdaExpr[Parameters[a b] ConditionalExpr[a b 0]]"},
+		{source: "fn() -> null", expected: "LambdaExpr[Parameters[] null]"},
+		{source: "fn(a) -> fn(b) -> a + b", expected: "LambdaExpr[Parameters[a] LambdaExpr[Parameters[b] BinaryExpr[a b]]]"},
+		{source: "map(items, fn(x) -> x + 1)", expected: "CallExpr[map items LambdaExpr[Parameters[x] BinaryExpr[x 1]]]"},
+		{source: "(fn(x) -> x)(1)", expected: "CallExpr[LambdaExpr[Parameters[x] x] 1]"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			expression := parseSource(t, testCase.source)
+			if got := describe(expression); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseLambdaErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "fn x -> x", err: "place error at line 1 col 1: expected '(' after fn"},
+		{source: "fn(1) -> 1", err: "place error at line 1 col 4: expected a parameter name in fn"},
+		{source: "fn(x,) -> x", err: "place error at line 1 col 6: expected a parameter name in fn"},
+		{source: "fn(x y) -> x", err: "place error at line 1 col 3: missing ')' for '('"},
+		{source: "fn(x) x", err: "place error at line 1 col 7: expected '->' after the parameters of fn"},
+		{source: "fn(x) ->", err: "place error at line 1 col 7: expected an expression"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			p := placer.NewPlacer()
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = placer.ParseExpression(p.Root().Children[0].Children)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+// parseSource places a single line of source and parses it as an expression.
+func parseSource(t *testing.T, source string) *placer.Node {
+	t.Helper()
+
+	p := placer.NewPlacer()
+	err := placeSource(t, p, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expression, err := placer.ParseExpression(p.Root().Children[0].Children)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return expression
+}
+
+// parenthesize renders an expression tree with every operator application in
+// parentheses, e.g. "(1 + (2 * 3))", so the grouping the parser chose is explicit.
+func parenthesize(node *placer.Node) string {
+	switch node.Type {
+	case placer.BinaryExpr:
+		return "(" + parenthesize(node.Children[0]) + " " + node.Value + " " + parenthesize(node.Children[1]) + ")"
+	case placer.UnaryExpr:
+		return "(" + node.Value + parenthesize(node.Children[0]) + ")"
+	default:
+		return describe(node)
+	}
+}
+
+func TestParseExpressionPrecedence(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "1 + 2 * 3", expected: "(1 + (2 * 3))"},
+		{source: "1 * 2 + 3", expected: "((1 * 2) + 3)"},
+		{source: "(1 + 2) * 3", expected: "((1 + 2) * 3)"},
+		{source: "1 - 2 - 3", expected: "((1 - 2) - 3)"},
+		{source: "8 / 4 / 2", expected: "((8 / 4) / 2)"},
+		{source: "7 % 3 * 2", expected: "((7 % 3) * 2)"},
+		{source: "1 + 7 % 3", expected: "(1 + (7 % 3))"},
+		{source: "-2 * 3", expected: "((-2) * 3)"},
+		{source: "-(2 * 3)", expected: "(-(2 * 3))"},
+		{source: "a + 1 < b * 2", expected: "((a + 1) < (b * 2))"},
+		{source: "a < b == c > d", expected: "((a < b) == (c > d))"},
+		{source: "a == b && c != d || e", expected: "(((a == b) && (c != d)) || e)"},
+		{source: "a || b && c", expected: "(a || (b && c))"},
+		{source: "!a && b", expected: "((!a) && b)"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			if got := parenthesize(parseSource(t, testCase.source)); got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseExpressionMaxDepth(t *testing.T) {
+	testCases := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "parentheses at the limit", source: strings.Repeat("(", placer.DefaultMaxDepth-1) + "1" + strings.Repeat(")", placer.DefaultMaxDepth-1)},
+		{name: "parentheses beyond the limit", source: strings.Repeat("(", 10000) + "1" + strings.Repeat(")", 10000), err: "place error at line 1 col 257: expression nested more than 256 deep"},
+		{name: "calls beyond the limit", source: strings.Repeat("f(", 10000) + "1" + strings.Repeat(")", 10000), err: "place error at line 1 col 513: expression nested more than 256 deep"},
+		{name: "prefix operators beyond the limit", source: strings.Repeat("- ", 10000) + "1", err: "place error at line 1 col 511: expression nested more than 256 deep"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			// IndentMode does not track brackets, so nothing bounds them
+			// before the expression parser.
+			p := placer.NewPlacer()
+			err := placeSource(t, p, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = placer.ParseExpression(p.Root().Children[0].Children)
+			if testCase.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %v", testCase.err, err)
+			}
+		})
+	}
+}
+
+func TestParseExpressionLeavesPlacedNodes(t *testing.T) {
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	err := placeSource(t, p, "total := -price * f(qty, {n: rate})[0].amount ? 1 : fn(x) -> x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	statement := p.Root().Children[0]
+	nodes := statement.Children[2:]
+
+	for i := 0; i < 2; i++ {
+		expression, err := placer.ParseExpression(nodes)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expression.Parent != nil {
+			t.Errorf("expected the expression to have no parent, got %s", describe(expression.Parent))
+		}
+	}
+
+	for _, node := range statement.Children {
+		if node.Parent != statement {
+			t.Errorf("expected %s to keep its statement as parent", describe(node))
+		}
+	}
+	record := statement.Children[8]
+	for _, entry := range record.Children {
+		for _, child := range entry.Children {
+			if child.Parent != entry {
+				t.Errorf("expected %s to keep its record entry as parent", describe(child))
+			}
+		}
+	}
+}
+
+func TestParseExpressionParenthesesMatchPrecedence(t *testing.T) {
+	implicit := parenthesize(parseSource(t, "1 + 2 * 3"))
+	explicit := parenthesize(parseSource(t, "1 + (2 * 3)"))
+	if implicit != explicit {
+		t.Errorf("expected 1 + 2 * 3 to parse as 1 + (2 * 3), got %s and %s", implicit, explicit)
+	}
+}
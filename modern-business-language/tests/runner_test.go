@@ -0,0 +1,2998 @@
+// tests/runner_test.go
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Solifugus/mbl/pkg/lexer"
+	"github.com/Solifugus/mbl/pkg/placer"
+	"github.com/Solifugus/mbl/pkg/runner"
+)
+
+func runSource(t *testing.T, r *runner.Runner, source string) error {
+	t.Helper()
+
+	tokens, err := lexer.Tokenize(source)
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+	return r.Run(tokens)
+}
+
+func TestRunnerAssignment(t *testing.T) {
+	r := runner.NewRunner()
+	err := runSource(t, r, "x := 42\nname := \"Ada\"\nok := true\nnothing := null\ny := x\nx := 7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]runner.Value{
+		"x":       runner.IntegerValue(7),
+		"name":    runner.StringValue("Ada"),
+		"ok":      runner.BooleanValue(true),
+		"nothing": {},
+		"y":       runner.IntegerValue(42),
+	}
+	for name, want := range expected {
+		got, ok := r.Get(name)
+		if !ok {
+			t.Errorf("expected %s to be assigned", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: expected %+v, got %+v", name, want, got)
+		}
+	}
+
+	if _, ok := r.Get("missing"); ok {
+		t.Errorf("expected missing to be unassigned")
+	}
+}
+
+func TestRunnerSemicolons(t *testing.T) {
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+
+	err := runSource(t, r, "x := 1; y := 2\nif x < y { print x; print y }; print x + y;")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "1\n2\n3\n"; output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+}
+
+func TestRunnerSnakeCaseNames(t *testing.T) {
+	r := runner.NewRunner()
+	err := runSource(t, r, "unit_price := 4\nline_total := unit_price * 3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := r.Get("line_total"); got != runner.IntegerValue(12) {
+		t.Errorf("expected line_total to be 12, got %+v", got)
+	}
+}
+
+func TestRunnerUndefinedVariable(t *testing.T) {
+	err := runSource(t, runner.NewRunner(), "x := y")
+	if err == nil {
+		t.Fatal("expected an error reading an undefined variable")
+	}
+}
+
+func TestRunnerArithmetic(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected int64
+	}{
+		{source: "x := 2 + 3 * 4", expected: 14},
+		{source: "x := 2 * 3 + 4", expected: 10},
+		{source: "x := 10 - 4 - 3", expected: 3},
+		{source: "x := 64 / 4 / 2", expected: 8},
+		{source: "x := (2 + 3) * 4", expected: 20},
+		{source: "x := ((1 + 1) * (2 + 2)) / 4", expected: 2},
+		{source: "x := - (2 + 3) * 2", expected: -10},
+		{source: "y := 5\nx := y * y - 1", expected: 24},
+		{source: "x := 5-3", expected: 2},
+		{source: "y := 4\nx := y-1", expected: 3},
+		{source: "x := -3", expected: -3},
+		{source: "x := -3 * -2", expected: 6},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, _ := r.Get("x")
+			if want := runner.IntegerValue(testCase.expected); got != want {
+				t.Errorf("expected %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestRunnerModulo(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "print 7 % 3", expected: "1\n"},
+		{source: "print 6 % 3", expected: "0\n"},
+		{source: "print -7 % 3", expected: "2\n"},
+		{source: "print 7 % -3", expected: "-2\n"},
+		{source: "print -7 % -3", expected: "-1\n"},
+		{source: "print 1 + 7 % 3 * 2", expected: "3\n"},
+		{source: "print 5.5 % 2", expected: "1.5\n"},
+		{source: "print -5.5 % 2", expected: "0.5\n"},
+		{source: "print 10.25 % -0.5", expected: "-0.25\n"},
+		{source: "print 0.3 % 0.1", expected: "0\n"},
+		{source: "i := 9\nprint i % 3 == 0", expected: "true\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerArithmeticErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "x := 1 / 0", err: "runtime error at line 1 col 8: division by zero"},
+		{source: "x := 5 % 0", err: "runtime error at line 1 col 8: modulo by zero"},
+		{source: "x := 5 // 0", err: "runtime error at line 1 col 8: division by zero"},
+		{source: "x := 99999999999999999999", err: "runtime error at line 1 col 6: integer \"99999999999999999999\" is out of range"},
+		{source: "x := 5.5 % 0.0", err: "runtime error at line 1 col 10: modulo by zero"},
+		{source: `x := "a" % 2`, err: "runtime error at line 1 col 10: cannot apply % to string and integer"},
+		{source: "x := (1 + 2", err: "place error at line 1 col 12: expected ')' to close '(' at line 1 col 6, found end of input"},
+		{source: "x := 1 +", err: "place error at line 1 col 8: expected an expression"},
+		{source: "x := \"a\" * 2", err: "runtime error at line 1 col 10: cannot apply * to string and integer"},
+		{source: "x := 1e308 * 10", err: "runtime error at line 1 col 12: result of * is too large for a number"},
+		{source: "x := -1e308 - 1e308", err: "runtime error at line 1 col 13: result of - is too large for a number"},
+		{source: "x := 1e308 / 1e-10", err: "runtime error at line 1 col 12: result of / is too large for a number"},
+		{source: "x := 1e308k", err: "runtime error at line 1 col 6: invalid number \"1e308k\""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerNullArithmetic(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "x := null + 1", err: "runtime error at line 1 col 11: cannot apply + to null and integer"},
+		{source: "x := 2.50 * null", err: "runtime error at line 1 col 11: cannot apply * to decimal and null"},
+		{source: "x := null - null", err: "runtime error at line 1 col 11: cannot apply - to null and null"},
+		{source: `x := "Total: " + null`, err: "runtime error at line 1 col 16: cannot apply + to string and null"},
+		{source: "x := -null", err: "runtime error at line 1 col 6: cannot apply - to null"},
+		{source: "x := !null", err: "runtime error at line 1 col 6: cannot apply ! to null"},
+		{source: "x := null && true", err: "runtime error at line 1 col 11: && operand must be a boolean, got null"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerPrint(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: `print "hello"`, expected: "hello\n"},
+		{source: "print 1.5 * 2", expected: "3\n"},
+		{source: "print 0.25", expected: "0.25\n"},
+		{source: "print false\nprint null", expected: "false\nnull\n"},
+		{source: "x := 2\nprint (x + 1) * x", expected: "6\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerConst(t *testing.T) {
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+
+	err := runSource(t, r, "const TAX_RATE := 0.08\nconst LIMITS := {max: 3}\nLIMITS.max := 5\nfunction tax(amount) {\n\treturn amount * TAX_RATE\n}\nprint tax(100)\nprint LIMITS.max")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "8\n5\n"; output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+
+	if got, _ := r.Get("TAX_RATE"); !got.Equal(runner.DecimalValue(big.NewRat(8, 100))) {
+		t.Errorf("expected TAX_RATE to be 0.08, got %v", got)
+	}
+}
+
+func TestRunnerConstErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "const RATE := 1\nRATE := 2", err: "runtime error at line 2 col 1: cannot assign to constant RATE"},
+		{source: "const RATE := 1\nconst RATE := 2", err: "runtime error at line 2 col 7: cannot assign to constant RATE"},
+		{source: "const RATE := 1\nfunction change() {\n\tRATE := 2\n}\nchange()", err: "runtime error at line 3 col 2: cannot assign to constant RATE"},
+		{source: "const RATE := 1\nfunction RATE() {\n}", err: "runtime error at line 2 col 10: cannot assign to constant RATE"},
+		{source: "const := 1", err: "runtime error at line 1 col 1: expected a constant name after const"},
+		{source: "const RATE 1", err: "runtime error at line 1 col 7: expected := after const RATE"},
+		{source: "const RATE :=", err: "runtime error at line 1 col 12: expected a value after :="},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerInterpolation(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "x := 42\nprint \"total is {x}\"", expected: "total is 42\n"},
+		{source: "qty := 3\nprice := 2.50\nprint \"{qty} at {price} is {qty * price}\"", expected: "3 at 2.5 is 7.5\n"},
+		{source: "name := \"Ada\"\nprint \"{upper(name)}{name}\"", expected: "ADAAda\n"},
+		{source: "print \"{ {qty: 2}.qty }\"", expected: "2\n"},
+		{source: "print \"items: {[1, 2]} and \\{braces\\}\"", expected: "items: [1, 2] and {braces}\n"},
+		{source: "function greet(who) {\n\treturn \"hello {who}\"\n}\nprint greet(\"Bo\")", expected: "hello Bo\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerInterpolationErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "print \"{missing}\"", err: "runtime error at line 1 col 9: undefined variable \"missing\""},
+		{source: "print \"{1; 2}\"", err: "runtime error at line 1 col 9: expected a single expression in braces"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerInterpolationInLoopDoesNotGrowHeap(t *testing.T) {
+	r := runner.NewRunner()
+	r.SetOutput(io.Discard)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	err := runSource(t, r, "i := 0\nwhile i < 50000 {\n\ts := \"i={i}\"\n\ti := i + 1\n}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(r)
+
+	// Each evaluation places the segment anew; none of it may outlive the
+	// evaluation, though the runner holds on to its parsed statements.
+	const limit = 8 << 20
+	if grown := int64(after.HeapAlloc) - int64(before.HeapAlloc); grown > limit {
+		t.Errorf("expected the heap to grow by at most %d bytes, grew by %d", limit, grown)
+	}
+}
+
+func TestRunnerIf(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "true branch",
+			source:   "x := 7\nif x > 5 {\n\tprint \"big\"\n} else {\n\tprint \"small\"\n}",
+			expected: "big\n",
+		},
+		{
+			name:     "false branch",
+			source:   "x := 3\nif x > 5 {\n\tprint \"big\"\n} else {\n\tprint \"small\"\n}",
+			expected: "small\n",
+		},
+		{
+			name:     "missing else",
+			source:   "if false {\n\tprint \"never\"\n}\nprint \"after\"",
+			expected: "after\n",
+		},
+		{
+			name:     "else on its own line",
+			source:   "if 1 == 2 {\n\tprint \"equal\"\n}\nelse {\n\tprint \"different\"\n}",
+			expected: "different\n",
+		},
+		{
+			name:     "else if",
+			source:   "x := 5\nif x < 5 { print \"less\" } else if x == 5 { print \"five\" } else { print \"more\" }",
+			expected: "five\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerIfErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "if 1 { print 1 }", err: "runtime error at line 1 col 1: if condition must be a boolean, got integer"},
+		{source: "if { print 1 }", err: "runtime error at line 1 col 1: expected a condition after if"},
+		{source: "if true", err: "runtime error at line 1 col 1: expected a block after if"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerSkipsBlankLines(t *testing.T) {
+	tokens, err := lexer.Tokenize("x := 1\n\nif x == 2 {\n\tprint \"two\"\n}\n\n\nelse {\n\n\tprint \"not two\"\n}\n")
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+
+	p := placer.NewPlacer()
+	p.Mode = placer.BraceMode
+	p.PreserveBlankLines = true
+	if err := p.PlaceTokens(tokens); err != nil {
+		t.Fatalf("unexpected place error: %v", err)
+	}
+
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+	if err := r.Exec(p.Root()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "not two\n"; output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+}
+
+func TestRunnerSwitch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "matched case",
+			source:   "status := \"shipped\"\nswitch status {\ncase \"open\":\n\tprint \"waiting\"\ncase \"shipped\":\n\tprint \"on its way\"\n\tprint \"soon\"\ndefault:\n\tprint \"unknown\"\n}",
+			expected: "on its way\nsoon\n",
+		},
+		{
+			name:     "statement on the case line",
+			source:   "switch 2 {\ncase 1: print \"one\"\ncase 2: print \"two\"\n}",
+			expected: "two\n",
+		},
+		{
+			name:     "default path",
+			source:   "switch 9 {\ncase 1: print \"one\"\ndefault: print \"other\"\n}",
+			expected: "other\n",
+		},
+		{
+			name:     "default before the cases",
+			source:   "switch 1 {\ndefault: print \"other\"\ncase 1: print \"one\"\n}",
+			expected: "one\n",
+		},
+		{
+			name:     "no match and no default",
+			source:   "switch 9 {\ncase 1: print \"one\"\n}\nprint \"after\"",
+			expected: "after\n",
+		},
+		{
+			name:     "no fall through",
+			source:   "switch 1 {\ncase 1:\ncase 2: print \"two\"\n}\nprint \"after\"",
+			expected: "after\n",
+		},
+		{
+			name:     "values compare like ==",
+			source:   "switch 1.50 {\ncase 1.5: print \"equal\"\n}",
+			expected: "equal\n",
+		},
+		{
+			name:     "case values are evaluated until one matches",
+			source:   "function check(n) {\n\tprint n\n\treturn n\n}\nswitch 2 {\ncase check(1):\ncase check(2):\ncase check(3):\n}",
+			expected: "1\n2\n",
+		},
+		{
+			name:     "break leaves the enclosing loop",
+			source:   "for n in [1, 2, 3] {\n\tswitch n {\n\tcase 2: break\n\t}\n\tprint n\n}",
+			expected: "1\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerSwitchErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "switch 1", err: "runtime error at line 1 col 1: expected a block after switch"},
+		{source: "switch { case 1: print 1 }", err: "runtime error at line 1 col 1: expected a value after switch"},
+		{source: "switch 1 {\nprint 1\n}", err: "runtime error at line 2 col 1: expected case or default in switch, got \"print\""},
+		{source: "switch 1 {\ncase 1 print 1\n}", err: "runtime error at line 2 col 1: expected ':' after case"},
+		{source: "switch 1 {\ncase: print 1\n}", err: "runtime error at line 2 col 1: expected a value after case"},
+		{source: "switch 1 {\ndefault 2: print 1\n}", err: "runtime error at line 2 col 9: unexpected \"2\" after default"},
+		{source: "switch 1 {\ndefault:\ndefault:\n}", err: "runtime error at line 3 col 1: switch has more than one default"},
+		{source: "case 1: print 1", err: "runtime error at line 1 col 1: case outside of a switch"},
+		{source: "default: print 1", err: "runtime error at line 1 col 1: default outside of a switch"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerWhile(t *testing.T) {
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+
+	err := runSource(t, r, "i := 0\nwhile i < 3 {\n\tprint i\n\ti := i + 1\n}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "0\n1\n2\n"; output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+
+	if got, _ := r.Get("i"); got != runner.IntegerValue(3) {
+		t.Errorf("expected i to be 3, got %+v", got)
+	}
+}
+
+func TestRunnerRecordOrder(t *testing.T) {
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+
+	source := "totals := {}\ntotals.march := 30\ntotals[\"january\"] := 10\ntotals.february := 20\ntotals.march := 35\n" +
+		"for month, total in totals {\n\tprint month + \": \" + total\n\ttotals[month + \" copy\"] := total\n}\nprint totals"
+	if err := runSource(t, r, source); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "march: 35\njanuary: 10\nfebruary: 20\n" +
+		"{march: 35, january: 10, february: 20, march copy: 35, january copy: 10, february copy: 20}\n"
+	if output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+}
+
+func TestRecordValueKeys(t *testing.T) {
+	record := runner.NewRecord().Record
+	record.Set("zeta", runner.IntegerValue(1))
+	record.Set("alpha", runner.IntegerValue(2))
+	record.Set("zeta", runner.IntegerValue(3))
+	record.Fields["beta"] = runner.IntegerValue(4)
+	record.Fields["aardvark"] = runner.IntegerValue(5)
+
+	expected := []string{"zeta", "alpha", "aardvark", "beta"}
+	if got := record.Keys(); strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected keys %v, got %v", expected, got)
+	}
+}
+
+func TestRunnerForEach(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "list",
+			source:   "total := 0\nfor item in [3, 4, 5] {\n\tprint item\n\ttotal := total + item\n}\nprint total",
+			expected: "3\n4\n5\n12\n",
+		},
+		{
+			name:     "record keys and values",
+			source:   "prices := {pear: 3, apple: 1, fig: 2}\nfor name, price in prices {\n\tprint name + \"=\" + price\n}",
+			expected: "pear=3\napple=1\nfig=2\n",
+		},
+		{
+			name:     "record keys",
+			source:   "for key in {b: 1, a: 2} {\n\tprint key\n}",
+			expected: "b\na\n",
+		},
+		{
+			name:     "nested",
+			source:   "for row in [[1, 2], [3]] {\n\tfor cell in row {\n\t\tprint cell\n\t}\n}",
+			expected: "1\n2\n3\n",
+		},
+		{
+			name:     "empty list",
+			source:   "for item in [] {\n\tprint item\n}\nprint \"done\"",
+			expected: "done\n",
+		},
+		{
+			name:     "return from a function",
+			source:   "function first(items) {\n\tfor item in items {\n\t\treturn item\n\t}\n}\nprint first([7, 8])",
+			expected: "7\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerForEachScope(t *testing.T) {
+	r := runner.NewRunner()
+	err := runSource(t, r, "item := \"outer\"\nfor item in [1, 2] {\n\tlast := item\n}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := r.Get("item"); got != runner.StringValue("outer") {
+		t.Errorf("expected the loop variable to stay inside the loop, got %+v", got)
+	}
+	if got, _ := r.Get("last"); got != runner.IntegerValue(2) {
+		t.Errorf("expected assignments in the body to reach the program, got %+v", got)
+	}
+}
+
+func TestRunnerForEachErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "for item in 5 {\n}", err: "runtime error at line 1 col 10: cannot iterate over integer"},
+		{source: "for c in \"abc\" {\n}", err: "runtime error at line 1 col 7: cannot iterate over string"},
+		{source: "for i, item in [1] {\n}", err: "runtime error at line 1 col 1: for over a list takes one loop variable, got 2"},
+		{source: "for item [1] {\n}", err: "runtime error at line 1 col 1: expected 'in' after the loop variables of for"},
+		{source: "for in [1] {\n}", err: "runtime error at line 1 col 1: expected a loop variable after for"},
+		{source: "for item in [1]", err: "runtime error at line 1 col 1: expected a block after for"},
+		{source: "for item in {\n}", err: "runtime error at line 1 col 10: expected a list or record after in"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %v", testCase.err, err)
+			}
+		})
+	}
+}
+
+func TestRunnerBreakContinue(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "break stops a while loop early",
+			source:   "i := 0\nwhile true {\n\ti := i + 1\n\tif i == 3 {\n\t\tbreak\n\t}\n\tprint i\n}\nprint \"after \" + i",
+			expected: "1\n2\nafter 3\n",
+		},
+		{
+			name:     "continue skips the rest of a while iteration",
+			source:   "i := 0\nwhile i < 5 {\n\ti := i + 1\n\tif i == 2 || i == 4 {\n\t\tcontinue\n\t}\n\tprint i\n}",
+			expected: "1\n3\n5\n",
+		},
+		{
+			name:     "break stops a for loop early",
+			source:   "for item in [1, 2, 3, 4] {\n\tif item > 2 {\n\t\tbreak\n\t}\n\tprint item\n}",
+			expected: "1\n2\n",
+		},
+		{
+			name:     "continue skips the rest of a for iteration",
+			source:   "for item in [1, 2, 3] {\n\tif item == 2 {\n\t\tcontinue\n\t}\n\tprint item\n}",
+			expected: "1\n3\n",
+		},
+		{
+			name:     "break leaves only the innermost loop",
+			source:   "for a in [1, 2] {\n\tfor b in [1, 2, 3] {\n\t\tif b == 2 {\n\t\t\tbreak\n\t\t}\n\t\tprint a + \":\" + b\n\t}\n}",
+			expected: "1:1\n2:1\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerBreakContinueErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "break", err: "runtime error at line 1 col 1: break outside of a loop"},
+		{source: "if true {\n\tcontinue\n}", err: "runtime error at line 2 col 2: continue outside of a loop"},
+		{source: "function stop() {\n\tbreak\n}\nwhile true {\n\tstop()\n}", err: "runtime error at line 2 col 2: break outside of a loop"},
+		{source: "while true {\n\tbreak 2\n}", err: "runtime error at line 2 col 8: unexpected \"2\" after break"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %v", testCase.err, err)
+			}
+		})
+	}
+}
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	testCases := []struct {
+		name    string
+		source  string
+		line    int
+		column  int
+		message string
+	}{
+		{
+			name:    "type error",
+			source:  "total := 10\nlabel := \"items\"\nx := total * label",
+			line:    3,
+			column:  12,
+			message: "cannot apply * to integer and string",
+		},
+		{
+			name:    "division by zero",
+			source:  "rate := 0\n  x := 5 / rate",
+			line:    2,
+			column:  10,
+			message: "division by zero",
+		},
+		{
+			name:    "unknown identifier",
+			source:  "x := 1\nif x == 1 {\n\tprint missing\n}",
+			line:    3,
+			column:  8,
+			message: `undefined variable "missing"`,
+		},
+		{
+			name:    "inside a function",
+			source:  "function f() {\n\treturn nothing\n}\nf()",
+			line:    2,
+			column:  9,
+			message: `undefined variable "nothing"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+
+			var runtimeErr *runner.RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("expected a RuntimeError, got %v", err)
+			}
+			if runtimeErr.Line != testCase.line || runtimeErr.Column != testCase.column {
+				t.Errorf("expected position %d:%d, got %d:%d", testCase.line, testCase.column, runtimeErr.Line, runtimeErr.Column)
+			}
+			if runtimeErr.Message != testCase.message {
+				t.Errorf("expected message %q, got %q", testCase.message, runtimeErr.Message)
+			}
+		})
+	}
+}
+
+func TestRuntimeErrorCause(t *testing.T) {
+	failure := errors.New("ledger unavailable")
+
+	r := runner.NewRunner()
+	r.Register("balance", func(args []runner.Value) (runner.Value, error) {
+		return runner.Value{}, failure
+	})
+
+	err := runSource(t, r, "x := 1\ny := balance()")
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the builtin error to be wrapped, got %v", err)
+	}
+
+	var runtimeErr *runner.RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Line != 2 {
+		t.Errorf("expected a RuntimeError at line 2, got %v", err)
+	}
+	if expected := "runtime error at line 2 col 13: ledger unavailable"; err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestRunnerContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := runner.NewRunner()
+	ticks := 0
+	r.Register("tick", func(args []runner.Value) (runner.Value, error) {
+		ticks++
+		if ticks == 5 {
+			cancel()
+		}
+		return runner.Value{}, nil
+	})
+
+	tokens, err := lexer.Tokenize("while true {\n\ttick()\n}")
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+
+	err = r.RunContext(ctx, tokens)
+	if err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if ticks != 5 {
+		t.Errorf("expected the loop to stop after 5 ticks, got %d", ticks)
+	}
+}
+
+func TestRunnerContextCancellationInListFunctions(t *testing.T) {
+	sources := []string{
+		"x := map([1, 2, 3, 4, 5, 6, 7, 8], fn(i) -> tick())",
+		"x := filter([1, 2, 3, 4, 5, 6, 7, 8], fn(i) -> tick() == null)",
+		"x := reduce([1, 2, 3, 4, 5, 6, 7, 8], fn(total, i) -> tick(), 0)",
+	}
+
+	for _, source := range sources {
+		t.Run(source, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			r := runner.NewRunner()
+			ticks := 0
+			r.Register("tick", func(args []runner.Value) (runner.Value, error) {
+				ticks++
+				if ticks == 5 {
+					cancel()
+				}
+				return runner.Value{}, nil
+			})
+
+			tokens, err := lexer.Tokenize(source)
+			if err != nil {
+				t.Fatalf("unexpected lex error: %v", err)
+			}
+
+			err = r.RunContext(ctx, tokens)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected %v, got %v", context.Canceled, err)
+			}
+			if ticks != 5 {
+				t.Errorf("expected the function to stop after 5 ticks, got %d", ticks)
+			}
+		})
+	}
+}
+
+func TestRunnerContextTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	r := runner.NewRunner()
+	r.MaxIterations = 0
+
+	tokens, err := lexer.Tokenize("x := 0\nwhile true {\n\tx := x + 1\n}")
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+
+	err = r.RunContext(ctx, tokens)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// The runner is usable again once the cancelled run has returned.
+	err = runSource(t, r, "y := 1")
+	if err != nil {
+		t.Errorf("unexpected error after the timeout: %v", err)
+	}
+}
+
+func TestRunnerMaxIterations(t *testing.T) {
+	testCases := []struct {
+		name          string
+		maxIterations int
+		source        string
+		err           string
+	}{
+		{
+			name:          "runaway loop",
+			maxIterations: 100,
+			source:        "while true {\n\tx := 1\n}",
+			err:           "runtime error at line 1 col 1: while loop exceeded 100 iterations",
+		},
+		{
+			name:          "loop one over the limit",
+			maxIterations: 3,
+			source:        "i := 0\nwhile i < 4 { i := i + 1 }",
+			err:           "runtime error at line 2 col 1: while loop exceeded 3 iterations",
+		},
+		{
+			name:          "loop at the limit",
+			maxIterations: 3,
+			source:        "i := 0\nwhile i < 3 { i := i + 1 }",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := runner.NewRunner()
+			r.MaxIterations = testCase.maxIterations
+
+			err := runSource(t, r, testCase.source)
+			if testCase.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerSkipsDirectives(t *testing.T) {
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+
+	err := runSource(t, r, "@strict\n@version 2\nprint 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output.String() != "1\n" {
+		t.Errorf("expected output %q, got %q", "1\n", output.String())
+	}
+}
+
+func TestRunnerMaxCallDepth(t *testing.T) {
+	testCases := []struct {
+		name         string
+		maxCallDepth int
+		source       string
+		err          string
+	}{
+		{
+			name:         "runaway recursion",
+			maxCallDepth: runner.DefaultMaxCallDepth,
+			source:       "function f(n) { return f(n + 1) }\nx := f(0)",
+			err:          "runtime error at line 1 col 25: call depth exceeded 5000",
+		},
+		{
+			name:         "recursion one over the limit",
+			maxCallDepth: 3,
+			source:       "function down(n) { return n == 0 ? 0 : down(n - 1) }\nx := down(3)",
+			err:          "runtime error at line 1 col 44: call depth exceeded 3",
+		},
+		{
+			name:         "recursion at the limit",
+			maxCallDepth: 3,
+			source:       "function down(n) { return n == 0 ? 0 : down(n - 1) }\nx := down(2)",
+		},
+		{
+			name:         "recursion through map",
+			maxCallDepth: 10,
+			source:       "function nest(n) { return map([n], nest) }\nx := nest(1)",
+			err:          "runtime error at line 1 col 30: call depth exceeded 10",
+		},
+		{
+			name:         "recursive lambda",
+			maxCallDepth: 10,
+			source:       "loop := fn(n) -> loop(n)\nx := loop(1)",
+			err:          "runtime error at line 1 col 22: call depth exceeded 10",
+		},
+		{
+			name:         "no limit",
+			maxCallDepth: 0,
+			source:       "function down(n) { return n == 0 ? 0 : down(n - 1) }\nx := down(6000)",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := runner.NewRunner()
+			r.MaxCallDepth = testCase.maxCallDepth
+
+			err := runSource(t, r, testCase.source)
+			if testCase.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerFunctions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name: "recursive factorial",
+			source: "function factorial(n) {\n" +
+				"\tif n <= 1 {\n\t\treturn 1\n\t}\n" +
+				"\treturn n * factorial(n - 1)\n" +
+				"}\n" +
+				"print factorial(5)",
+			expected: "120\n",
+		},
+		{
+			name:     "positional arguments",
+			source:   "function minus(a, b) { return a - b }\nprint minus(10, 4)",
+			expected: "6\n",
+		},
+		{
+			name:     "no return yields null",
+			source:   "function nothing() { x := 1 }\nprint nothing()",
+			expected: "null\n",
+		},
+		{
+			name:     "locals do not leak",
+			source:   "x := 1\nfunction shadow() {\n\tx := 2\n\tprint x\n}\nshadow()\nprint x",
+			expected: "2\n1\n",
+		},
+		{
+			name:     "globals are visible",
+			source:   "rate := 3\nfunction scale(n) { return n * rate }\nprint scale(2)",
+			expected: "6\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerHoistsFunctions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "main calls a helper defined below",
+			source:   "function main() {\n\tprint helper(20)\n}\nmain()\nfunction helper(n) {\n\treturn n + 1\n}",
+			expected: "21\n",
+		},
+		{
+			name:     "call before any definition",
+			source:   "print twice(4)\nfunction twice(n) {\n\treturn n * 2\n}",
+			expected: "8\n",
+		},
+		{
+			name:     "mutual recursion",
+			source:   "print is_even(10)\nfunction is_even(n) {\n\tif n == 0 { return true }\n\treturn is_odd(n - 1)\n}\nfunction is_odd(n) {\n\tif n == 0 { return false }\n\treturn is_even(n - 1)\n}",
+			expected: "true\n",
+		},
+		{
+			name:     "reaching a hoisted definition keeps the same function",
+			source:   "early := later\nfunction later() {\n}\nprint early == later",
+			expected: "true\n",
+		},
+		{
+			name:     "a reassigned name is defined again",
+			source:   "f := 1\nprint f\nfunction f() {\n\treturn 2\n}\nprint f()",
+			expected: "1\n2\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerHoistsOnlyFunctions(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "print rate\nrate := 2", err: "runtime error at line 1 col 7: undefined variable \"rate\""},
+		{source: "print scale(3)\nrate := 2\nfunction scale(n) {\n\treturn n * rate\n}", err: "runtime error at line 4 col 13: undefined variable \"rate\""},
+		{source: "function outer() {\n\treturn inner()\n\tfunction inner() {\n\t}\n}\nouter()", err: "runtime error at line 2 col 9: undefined variable \"inner\""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerClosures(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name: "independent counters",
+			source: "function counter() {\n" +
+				"\tstate := { count: 0 }\n" +
+				"\tfunction next() {\n" +
+				"\t\tstate.count := state.count + 1\n" +
+				"\t\treturn state.count\n" +
+				"\t}\n" +
+				"\treturn next\n" +
+				"}\n" +
+				"a := counter()\n" +
+				"b := counter()\n" +
+				"print a()\nprint a()\nprint b()\nprint a()",
+			expected: "1\n2\n1\n3\n",
+		},
+		{
+			name: "captured parameter",
+			source: "function adder(n) {\n" +
+				"\tfunction add(x) { return x + n }\n" +
+				"\treturn add\n" +
+				"}\n" +
+				"addTwo := adder(2)\naddTen := adder(10)\n" +
+				"print addTwo(5)\nprint addTen(5)",
+			expected: "7\n15\n",
+		},
+		{
+			name: "captured scope is shared, not copied",
+			source: "function make() {\n" +
+				"\tlabel := \"before\"\n" +
+				"\tfunction show() { return label }\n" +
+				"\tlabel := \"after\"\n" +
+				"\treturn show\n" +
+				"}\n" +
+				"show := make()\nprint show()",
+			expected: "after\n",
+		},
+		{
+			name: "assignment in a closure updates the captured variable",
+			source: "function make() {\n" +
+				"\tcount := 0\n" +
+				"\tfunction bump() {\n" +
+				"\t\tcount := count + 1\n" +
+				"\t\treturn count\n" +
+				"\t}\n" +
+				"\treturn bump\n" +
+				"}\n" +
+				"bump := make()\nprint bump()\nprint bump()",
+			expected: "1\n2\n",
+		},
+		{
+			name: "captured variable is shared with the enclosing call",
+			source: "function make() {\n" +
+				"\tcount := 0\n" +
+				"\tfunction bump() { count := count + 1 }\n" +
+				"\tbump()\n\tbump()\n" +
+				"\treturn count\n" +
+				"}\n" +
+				"print make()",
+			expected: "2\n",
+		},
+		{
+			name: "parameter shadows a captured variable",
+			source: "function make() {\n" +
+				"\tn := 1\n" +
+				"\tfunction set(n) { n := n * 10 }\n" +
+				"\tset(5)\n" +
+				"\treturn n\n" +
+				"}\n" +
+				"print make()",
+			expected: "1\n",
+		},
+		{
+			name: "assignment in a closure leaves globals alone",
+			source: "total := 1\n" +
+				"function make() {\n" +
+				"\tfunction bump() { total := 99 }\n" +
+				"\treturn bump\n" +
+				"}\n" +
+				"bump := make()\nbump()\nprint total",
+			expected: "1\n",
+		},
+		{
+			name: "closures made in a loop",
+			source: "makers := [null, null, null]\ni := 0\n" +
+				"for n in [1, 2, 3] {\n" +
+				"\tfunction get() { return n }\n" +
+				"\tmakers[i] := get\n" +
+				"\ti := i + 1\n" +
+				"}\n" +
+				"for get in makers {\n" +
+				"\tprint get()\n" +
+				"}",
+			expected: "1\n2\n3\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerFunctionErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{
+			source: "function add(a, b) { return a + b }\nx := add(1)",
+			err:    "runtime error at line 2 col 9: function add expects 2 arguments, got 1",
+		},
+		{
+			source: "function one() { return 1 }\nx := one(1, 2)",
+			err:    "runtime error at line 2 col 9: function one expects 0 arguments, got 2",
+		},
+		{source: "return 1", err: "runtime error at line 1 col 1: return outside of a function"},
+		{source: "x := 1\ny := x(2)", err: "runtime error at line 2 col 7: cannot call integer"},
+		{source: "function f(a,) { }", err: "runtime error at line 1 col 14: expected a parameter name in function f"},
+		{source: "f := fn(a) -> a\nf(1 2)\nx := 1", err: "place error at line 2 col 2: missing ')' for '('"},
+		{source: "x := 1\nx + 1\ny := 2", err: "runtime error at line 2 col 1: unsupported statement"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerStrings(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected runner.Value
+	}{
+		{source: `x := "Hello, " + "world"`, expected: runner.StringValue("Hello, world")},
+		{source: `x := "Total: " + 5`, expected: runner.StringValue("Total: 5")},
+		{source: `x := 2.5 + " units"`, expected: runner.StringValue("2.5 units")},
+		{source: `x := "a" + true`, expected: runner.StringValue("atrue")},
+		{source: `x := "apple" < "banana"`, expected: runner.BooleanValue(true)},
+		{source: `x := "apple" > "banana"`, expected: runner.BooleanValue(false)},
+		{source: `x := "Zebra" < "apple"`, expected: runner.BooleanValue(true)},
+		{source: `x := "abc" <= "abc"`, expected: runner.BooleanValue(true)},
+		{source: `x := "abc" == "abc"`, expected: runner.BooleanValue(true)},
+		{source: `x := "abc" != "abd"`, expected: runner.BooleanValue(true)},
+		{source: `x := "1" == 1`, expected: runner.BooleanValue(true)},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got != testCase.expected {
+				t.Errorf("expected %+v, got %+v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerStringErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: `x := "a" - "b"`, err: "runtime error at line 1 col 10: cannot apply - to string and string"},
+		{source: `x := "a" < 1`, err: "runtime error at line 1 col 10: cannot compare string and integer"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerDecimals(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "print 0.1 + 0.2", expected: "0.3\n"},
+		{source: "print 0.1 + 0.2 == 0.3", expected: "true\n"},
+		{source: "print 0.1 + 0.2 == 0.4", expected: "false\n"},
+		{source: "print 1.5 == 2.5", expected: "false\n"},
+		{source: "print 1.5 != 2.5", expected: "true\n"},
+		{source: "print [1.5] == [2.5]", expected: "false\n"},
+		{source: "print {a: 1.5} == {a: 2.5}", expected: "false\n"},
+		{source: "switch 2.5 {\ncase 1.5: print \"one and a half\"\ncase 2.5: print \"two and a half\"\n}", expected: "two and a half\n"},
+		{source: "print 1.10 * 3", expected: "3.3\n"},
+		{source: "print 0.3 - 0.1 - 0.2", expected: "0\n"},
+		{source: "print 19.99 * 3 == 59.97", expected: "true\n"},
+		{source: "print 1.0 == 1", expected: "true\n"},
+		{source: "print 1.0 / 8", expected: "0.125\n"},
+		{source: "print 1.0 / 3", expected: "0.3333333333333333\n"},
+		{source: "print -2.50 + 1", expected: "-1.5\n"},
+		{source: "print 0.7 > 0.69", expected: "true\n"},
+		{source: "total := 0.0\ni := 0\nwhile i < 10 {\n\ttotal := total + 0.1\n\ti := i + 1\n}\nprint total == 1", expected: "true\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerDates(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "print #2024-01-31#", expected: "2024-01-31\n"},
+		{source: "due := #2024-02-29#\nprint due", expected: "2024-02-29\n"},
+		{source: "print #2024-01-31# == #2024-01-31#", expected: "true\n"},
+		{source: "print #2024-01-31# != #2024-02-01#", expected: "true\n"},
+		{source: "print #2024-01-31# < #2024-02-01#", expected: "true\n"},
+		{source: "print #2025-01-01# <= #2024-12-31#", expected: "false\n"},
+		{source: "print [#2024-01-31#]", expected: "[2024-01-31]\n"},
+		{source: "print to_string(#2024-01-31#)", expected: "2024-01-31\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerDateErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "x := #2024-01-31# + 1", err: "runtime error at line 1 col 19: cannot apply + to date and integer"},
+		{source: "x := #2024-01-31# < \"2024-02-01\"", err: "runtime error at line 1 col 19: cannot compare date and string"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerCurrency(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "print $1,234.56", expected: "$1234.56\n"},
+		{source: "print €5", expected: "€5\n"},
+		{source: "print typeof(£12)", expected: "decimal\n"},
+		{source: "print $5 + $6", expected: "11\n"},
+		{source: "print $0.10 + 0.20 == 0.3", expected: "true\n"},
+		{source: "print $5 == €5", expected: "true\n"},
+		{source: "print $19.99 > 19", expected: "true\n"},
+		{source: "print format_money($1234.5, \"$\")", expected: "$1,234.50\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			l := lexer.NewLexer(testCase.source)
+			l.CurrencyLiterals = true
+			tokens, err := l.Lex()
+			if err != nil {
+				t.Fatalf("unexpected lex error: %v", err)
+			}
+
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+			if err := r.Run(tokens); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerIntegers(t *testing.T) {
+	testCases := []struct {
+		source   string
+		kind     runner.Kind
+		expected string
+	}{
+		{source: "x := 7", kind: runner.Integer, expected: "7"},
+		{source: "x := 0xFF", kind: runner.Integer, expected: "255"},
+		{source: "x := 1_000_000", kind: runner.Integer, expected: "1000000"},
+		{source: "x := 1e3", kind: runner.Number, expected: "1000"},
+		{source: "x := 2 + 3 * 4", kind: runner.Integer, expected: "14"},
+		{source: "x := -3 * -2", kind: runner.Integer, expected: "6"},
+		// / is exact: an even division stays an integer, the rest are decimals.
+		{source: "x := 6 / 3", kind: runner.Integer, expected: "2"},
+		{source: "x := 5 / 2", kind: runner.Decimal, expected: "2.5"},
+		{source: "x := 1 / 3", kind: runner.Decimal, expected: "0.3333333333333333"},
+		// // divides down to a whole number.
+		{source: "x := 5 // 2", kind: runner.Integer, expected: "2"},
+		{source: "x := -7 // 2", kind: runner.Integer, expected: "-4"},
+		{source: "x := 7.5 // 2", kind: runner.Integer, expected: "3"},
+		{source: "x := 7e0 // 2", kind: runner.Integer, expected: "3"},
+		{source: "x := 7 % 3", kind: runner.Integer, expected: "1"},
+		// Integers mixed with decimals become decimals; with floats, floats.
+		{source: "x := 2 + 0.5", kind: runner.Decimal, expected: "2.5"},
+		{source: "x := 3 * 1.10", kind: runner.Decimal, expected: "3.3"},
+		{source: "x := 2 + 5e-1", kind: runner.Number, expected: "2.5"},
+		{source: "x := 2.5 + 5e-1", kind: runner.Decimal, expected: "3"},
+		// Results too large for an integer stay exact as decimals.
+		{source: "x := 9223372036854775807 + 1", kind: runner.Decimal, expected: "9223372036854775808"},
+		{source: "x := 4294967296 * 4294967296", kind: runner.Decimal, expected: "18446744073709551616"},
+		{source: "x := -9223372036854775807 - 2", kind: runner.Decimal, expected: "-9223372036854775809"},
+		{source: "x := length([1, 2, 3])", kind: runner.Integer, expected: "3"},
+		// Scale suffixes keep the literal exact.
+		{source: "x := 1k", kind: runner.Integer, expected: "1000"},
+		{source: "x := 2M", kind: runner.Integer, expected: "2000000"},
+		{source: "x := 1.5k", kind: runner.Decimal, expected: "1500"},
+		{source: "x := 50%", kind: runner.Decimal, expected: "0.5"},
+		{source: "x := 12.5%", kind: runner.Decimal, expected: "0.125"},
+		{source: "x := 200%", kind: runner.Integer, expected: "2"},
+		{source: "x := 2e3k", kind: runner.Number, expected: "2000000"},
+		{source: "x := 1_500k", kind: runner.Integer, expected: "1500000"},
+		{source: "x := 200 * 15%", kind: runner.Decimal, expected: "30"},
+		{source: "x := 10%3", kind: runner.Integer, expected: "1"},
+		{source: "x := 10%-3", kind: runner.Integer, expected: "-2"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, _ := r.Get("x")
+			if got.Kind != testCase.kind || got.String() != testCase.expected {
+				t.Errorf("expected %v %s, got %v %s", testCase.kind, testCase.expected, got.Kind, got)
+			}
+		})
+	}
+}
+
+func TestRunnerIntegerComparisons(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected bool
+	}{
+		{source: "x := 2 == 2.0", expected: true},
+		{source: "x := 2 == 2e0", expected: true},
+		{source: "x := 5 / 2 == 2.5", expected: true},
+		{source: "x := 5 // 2 == 2", expected: true},
+		{source: "x := 3 < 3.5", expected: true},
+		{source: "x := -1 < 0", expected: true},
+		{source: "x := 7 == (7 // 2) * 2 + 7 % 2", expected: true},
+		{source: "x := -7 == (-7 // 2) * 2 + -7 % 2", expected: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got != runner.BooleanValue(testCase.expected) {
+				t.Errorf("expected %v, got %+v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerDecimalKind(t *testing.T) {
+	r := runner.NewRunner()
+	err := runSource(t, r, "x := 0.1 + 0.2\ny := 2 + 3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	x, _ := r.Get("x")
+	if x.Kind != runner.Decimal || x.Dec.Cmp(big.NewRat(3, 10)) != 0 {
+		t.Errorf("expected the decimal 3/10, got %+v", x)
+	}
+
+	y, _ := r.Get("y")
+	if y != runner.IntegerValue(5) {
+		t.Errorf("expected the integer 5, got %+v", y)
+	}
+}
+
+func TestRunnerEval(t *testing.T) {
+	r := runner.NewRunner()
+
+	lines := []struct {
+		source   string
+		expected runner.Value
+	}{
+		{source: "x := 40", expected: runner.Value{}},
+		{source: "x + 2", expected: runner.IntegerValue(42)},
+		{source: "function twice(n) { return n * 2 }", expected: runner.Value{}},
+		{source: "y := twice(x)\ny", expected: runner.IntegerValue(80)},
+		{source: `"a" + "b"`, expected: runner.StringValue("ab")},
+	}
+
+	for _, line := range lines {
+		tokens, err := lexer.Tokenize(line.source)
+		if err != nil {
+			t.Fatalf("unexpected lex error: %v", err)
+		}
+
+		p := placer.NewPlacer()
+		p.Mode = placer.BraceMode
+		if err := p.PlaceTokens(tokens); err != nil {
+			t.Fatalf("unexpected place error: %v", err)
+		}
+
+		got, err := r.Eval(p.Root())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", line.source, err)
+		}
+		if got != line.expected {
+			t.Errorf("%s: expected %+v, got %+v", line.source, line.expected, got)
+		}
+	}
+}
+
+func TestRunnerUnknownTokenType(t *testing.T) {
+	tokens, err := lexer.Tokenize("due := 31")
+	if err != nil {
+		t.Fatalf("unexpected lex error: %v", err)
+	}
+	for i := range tokens {
+		if tokens[i].Type == lexer.Numeric {
+			tokens[i].Type = lexer.Keyword
+		}
+	}
+
+	err = runner.NewRunner().Run(tokens)
+	if err == nil {
+		t.Fatal("expected an error evaluating a keyword token")
+	}
+
+	if expected := "place error at line 1 col 8: unexpected \"31\" in expression"; err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err.Error())
+	}
+}
+
+func TestRunnerComparisons(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected bool
+	}{
+		{source: "x := 3 == 3", expected: true},
+		{source: "x := 3 == 4", expected: false},
+		{source: "x := 3 != 4", expected: true},
+		{source: "x := 3 != 3", expected: false},
+		{source: "x := 2 < 3", expected: true},
+		{source: "x := 3 < 3", expected: false},
+		{source: "x := 3 <= 3", expected: true},
+		{source: "x := 4 <= 3", expected: false},
+		{source: "x := 4 > 3", expected: true},
+		{source: "x := 3 > 3", expected: false},
+		{source: "x := 3 >= 3", expected: true},
+		{source: "x := 2 >= 3", expected: false},
+		{source: "x := 0.10 == 0.1", expected: true},
+		{source: "x := 2.5 < 3", expected: true},
+		{source: "x := 1 + 2 == 3", expected: true},
+		{source: "x := true == true", expected: true},
+		{source: "x := true != false", expected: true},
+		{source: "x := null == null", expected: true},
+		{source: "x := 0 == null", expected: false},
+		{source: "x := null != 0", expected: true},
+		{source: `x := "" == null`, expected: false},
+		{source: "x := false == null", expected: false},
+		{source: "x := [] == null", expected: false},
+		{source: "nothing := null\nx := nothing == null", expected: true},
+		{source: `x := " 12.50 " == 12.5`, expected: true},
+		{source: `x := " 12.50 " == 12.75`, expected: false},
+		{source: "x := 1.5 == 2.5", expected: false},
+		{source: "x := 1.5 != 2.5", expected: true},
+		{source: `x := 9 < "10"`, expected: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got != runner.BooleanValue(testCase.expected) {
+				t.Errorf("expected %v, got %+v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerComparisonErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: `x := "ten" == 10`, err: "runtime error at line 1 col 12: cannot compare string and integer"},
+		{source: "x := true == 1", err: "runtime error at line 1 col 11: cannot compare boolean and integer"},
+		{source: "x := true < false", err: "runtime error at line 1 col 11: cannot apply < to boolean and boolean"},
+		{source: "x := null >= 1", err: "runtime error at line 1 col 11: cannot apply >= to null and integer"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerLogic(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected bool
+	}{
+		{source: "x := true && true", expected: true},
+		{source: "x := true && false", expected: false},
+		{source: "x := false || true", expected: true},
+		{source: "x := false || false", expected: false},
+		{source: "x := !false", expected: true},
+		{source: "x := !(1 < 2)", expected: false},
+		{source: "x := 1 < 2 && 3 > 2", expected: true},
+		{source: "x := false && false || true", expected: true},
+		{source: "x := true || false && false", expected: true},
+		{source: "x := !true || !false", expected: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got != runner.BooleanValue(testCase.expected) {
+				t.Errorf("expected %v, got %+v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerShortCircuit(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "x := false && touch()", expected: ""},
+		{source: "x := true || touch()", expected: ""},
+		{source: "x := true && touch()", expected: "touched\n"},
+		{source: "x := false || touch()", expected: "touched\n"},
+		{source: "x := false && 1", expected: ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			source := "function touch() {\n\tprint \"touched\"\n\treturn true\n}\n" + testCase.source
+			err := runSource(t, r, source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerLogicErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "x := 1 && true", err: "runtime error at line 1 col 8: && operand must be a boolean, got integer"},
+		{source: `x := false || "yes"`, err: "runtime error at line 1 col 12: || operand must be a boolean, got string"},
+		{source: "x := !0", err: "runtime error at line 1 col 6: cannot apply ! to integer"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerConditional(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: `print true ? "yes" : "no"`, expected: "yes\n"},
+		{source: `print false ? "yes" : "no"`, expected: "no\n"},
+		{source: `qty := 3` + "\n" + `print qty == 1 ? "item" : "items"`, expected: "items\n"},
+		{source: "print 1 < 2 ? 10 + 1 : 20", expected: "11\n"},
+		{source: `n := 0` + "\n" + `print n < 0 ? "negative" : n == 0 ? "zero" : "positive"`, expected: "zero\n"},
+		{source: "print (true ? 2 : 3) * 4", expected: "8\n"},
+		{source: "print [false ? 1 : 2, true ? 3 : 4]", expected: "[2, 3]\n"},
+		{source: `print { label: true ? "paid" : "due" }.label`, expected: "paid\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerConditionalIsLazy(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: `x := true ? touch("then") : touch("else")`, expected: "then\n"},
+		{source: `x := false ? touch("then") : touch("else")`, expected: "else\n"},
+		{source: "x := true ? 1 : 1 / 0", expected: ""},
+		{source: "x := false ? missing : 2", expected: ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			source := "function touch(branch) {\n\tprint branch\n\treturn branch\n}\n" + testCase.source
+			err := runSource(t, r, source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerConditionalErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "x := 1 ? 2 : 3", err: "runtime error at line 1 col 8: ? condition must be a boolean, got integer"},
+		{source: "x := null ? 2 : 3", err: "runtime error at line 1 col 11: ? condition must be a boolean, got null"},
+		{source: "x := true ? 2", err: "place error at line 1 col 11: missing ':' for '?'"},
+		{source: "x := true ? : 3", err: "place error at line 1 col 13: unexpected \":\" in expression"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerLists(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "print [1, 2, 3]", expected: "[1, 2, 3]\n"},
+		{source: `print ["a", true, null, []]`, expected: `["a", true, null, []]` + "\n"},
+		{source: "items := [10, 20, 30]\nprint items[0] + items[2]", expected: "40\n"},
+		{source: "i := 1\nprint [10, 20, 30][i + 1]", expected: "30\n"},
+		{source: "grid := [[1, 2], [3, 4]]\nprint grid[1][0]", expected: "3\n"},
+		{source: "print length([1, 2, 3])", expected: "3\n"},
+		{source: "print length([])", expected: "0\n"},
+		{source: `print length("héllo")`, expected: "5\n"},
+		{source: "print [1, 2] == [1, 2]", expected: "true\n"},
+		{source: "a := [1, 2]\na[0] := a\nprint a", expected: "[[...], 2]\n"},
+		{source: "a := [1]\na[0] := a\nprint \"{a}\" + to_string(a)", expected: "[[...]][[...]]\n"},
+		{source: "a := [1]\na[0] := a\nb := [1]\nb[0] := b\nprint a == a\nprint a == b", expected: "true\ntrue\n"},
+		{source: "a := [1]\na[0] := a\nb := [2]\nb[0] := b\nprint a == [a, 1]\nprint a == [b]", expected: "false\ntrue\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerListErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "x := [1, 2, 3][3]", err: "runtime error at line 1 col 15: index 3 out of range for list of length 3"},
+		{source: "x := [1, 2, 3][-1]", err: "runtime error at line 1 col 15: index -1 out of range for list of length 3"},
+		{source: "x := [1, 2][0.5]", err: "runtime error at line 1 col 12: list index must be a whole number, got 0.5"},
+		{source: "x := 5\ny := x[0]", err: "runtime error at line 2 col 7: cannot index integer"},
+		{source: "x := length(1)", err: "runtime error at line 1 col 12: length expects a list or string, got integer"},
+		{source: "x := length()", err: "runtime error at line 1 col 12: length expects 1 arguments, got 0"},
+		{source: "x := typeof(1, 2)", err: "runtime error at line 1 col 12: typeof expects 1 arguments, got 2"},
+		{source: "x := [1, 2", err: "place error at line 1 col 11: expected ']' to close '[' at line 1 col 6, found end of input"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerRecords(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "literal",
+			source:   `customer := { name: "Ada", "credit limit": 500 }` + "\nprint customer",
+			expected: `{name: "Ada", credit limit: 500}` + "\n",
+		},
+		{
+			name:     "multi-line literal",
+			source:   "order := {\n\tid: 7,\n\ttotal: 19.99\n\tpaid: true\n}\nprint order.id\nprint order.total",
+			expected: "7\n19.99\n",
+		},
+		{
+			name:     "read existing keys",
+			source:   `rec := { name: "Ada" }` + "\nprint rec.name\nprint rec[\"name\"]",
+			expected: "Ada\nAda\n",
+		},
+		{
+			name:     "read missing keys",
+			source:   `rec := { name: "Ada" }` + "\nprint rec.email\nprint rec[\"phone\"]",
+			expected: "null\nnull\n",
+		},
+		{
+			name:     "mutation",
+			source:   "rec := { count: 1 }\nrec.count := rec.count + 1\nrec[\"status\"] := \"open\"\nprint rec",
+			expected: `{count: 2, status: "open"}` + "\n",
+		},
+		{
+			name:     "nested",
+			source:   "rec := { address: { city: \"Oslo\" } }\nrec.address.zip := \"0150\"\nprint rec.address",
+			expected: `{city: "Oslo", zip: "0150"}` + "\n",
+		},
+		{
+			name:     "shared by reference",
+			source:   "a := {}\nb := a\nb.x := 1\nprint a.x",
+			expected: "1\n",
+		},
+		{
+			name:     "list item assignment",
+			source:   "items := [1, 2, 3]\nitems[1] := 20\nprint items",
+			expected: "[1, 20, 3]\n",
+		},
+		{
+			name:     "contains itself",
+			source:   "r := {a: 1}\nr.self := r\nprint r\nprint \"{r}\" + to_string(r)\nprint r == r",
+			expected: "{a: 1, self: {...}}\n{a: 1, self: {...}}{a: 1, self: {...}}\ntrue\n",
+		},
+		{
+			name:     "contains itself through a list",
+			source:   "r := {a: 1}\nr.items := [r]\ns := {a: 1}\ns.items := [s]\nprint r\nprint r == s\nprint r == {a: 1, items: [s]}",
+			expected: "{a: 1, items: [{...}]}\ntrue\ntrue\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerRecordErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "x := 1\ny := x.name", err: "runtime error at line 2 col 8: cannot read field name of integer"},
+		{source: "x := 1\nx.name := 2", err: "runtime error at line 2 col 3: cannot set field name on integer"},
+		{source: "rec := {}\ny := rec[1]", err: "runtime error at line 2 col 9: record key must be a string, got integer"},
+		{source: "rec := { 1: 2 }", err: `place error at line 1 col 10: expected a record key, got "1"`},
+		{source: "rec := { a 2 }", err: `place error at line 1 col 12: expected ':' after record key "a"`},
+		{source: "items := [1]\nitems[1] := 2", err: "runtime error at line 2 col 6: index 1 out of range for list of length 1"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerStringBuiltins(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected runner.Value
+	}{
+		{source: `x := upper("Café Ünit")`, expected: runner.StringValue("CAFÉ ÜNIT")},
+		{source: `x := lower("ÉCOLE")`, expected: runner.StringValue("école")},
+		{source: `x := trim("  \t invoice 42 \n")`, expected: runner.StringValue("invoice 42")},
+		{source: `x := substring("héllo wörld", 6, 5)`, expected: runner.StringValue("wörld")},
+		{source: `x := substring("héllo", 1, 3)`, expected: runner.StringValue("éll")},
+		{source: `x := substring("héllo", 3, 99)`, expected: runner.StringValue("lo")},
+		{source: `x := substring("héllo", -2, 2)`, expected: runner.StringValue("hé")},
+		{source: `x := substring("héllo", 10, 2)`, expected: runner.StringValue("")},
+		{source: `x := substring("héllo", 2, -1)`, expected: runner.StringValue("")},
+		{source: `x := contains("quarterly report", "report")`, expected: runner.BooleanValue(true)},
+		{source: `x := contains("quarterly report", "Report")`, expected: runner.BooleanValue(false)},
+		{source: `x := length(substring("日本語テキスト", 0, 3))`, expected: runner.IntegerValue(3)},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got != testCase.expected {
+				t.Errorf("expected %+v, got %+v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerMathBuiltins(t *testing.T) {
+	testCases := []struct {
+		source   string
+		kind     runner.Kind
+		expected string
+	}{
+		{source: "x := abs(-4)", kind: runner.Integer, expected: "4"},
+		{source: "x := abs(-2e0)", kind: runner.Number, expected: "2"},
+		{source: "x := abs(-12.50)", kind: runner.Decimal, expected: "12.5"},
+		{source: "x := floor(2.7)", kind: runner.Integer, expected: "2"},
+		{source: "x := floor(-2.1)", kind: runner.Integer, expected: "-3"},
+		{source: "x := ceil(2.1)", kind: runner.Integer, expected: "3"},
+		{source: "x := ceil(-2.7)", kind: runner.Integer, expected: "-2"},
+		{source: "x := ceil(7 / 2)", kind: runner.Integer, expected: "4"},
+		// round takes halves away from zero, not to the nearest even digit.
+		{source: "x := round(2.5)", kind: runner.Integer, expected: "3"},
+		{source: "x := round(3.5)", kind: runner.Integer, expected: "4"},
+		{source: "x := round(-2.5)", kind: runner.Integer, expected: "-3"},
+		{source: "x := round(2.4)", kind: runner.Integer, expected: "2"},
+		{source: "x := round(5 / 2)", kind: runner.Integer, expected: "3"},
+		{source: "x := round(2.345, 2)", kind: runner.Decimal, expected: "2.35"},
+		{source: "x := round(-1.005, 2)", kind: runner.Decimal, expected: "-1.01"},
+		{source: "x := round(1234.5, -2)", kind: runner.Integer, expected: "1200"},
+		{source: "x := round(1.5, 1000)", kind: runner.Decimal, expected: "1.5"},
+		{source: "x := min(3, 1.5, 2)", kind: runner.Decimal, expected: "1.5"},
+		{source: "x := max(3, 1.5, 2)", kind: runner.Integer, expected: "3"},
+		{source: "x := pow(2, 10)", kind: runner.Integer, expected: "1024"},
+		{source: "x := pow(2, -1)", kind: runner.Decimal, expected: "0.5"},
+		{source: "x := pow(4, 0.5)", kind: runner.Number, expected: "2"},
+		{source: "x := pow(4, 5e-1)", kind: runner.Number, expected: "2"},
+		{source: "x := floor(7e0 / 2)", kind: runner.Integer, expected: "3"},
+		{source: "x := pow(1.1, 2)", kind: runner.Decimal, expected: "1.21"},
+		{source: "x := pow(2.0, -2)", kind: runner.Decimal, expected: "0.25"},
+		{source: "m := -9223372036854775807 - 1\nx := pow(2, m)", kind: runner.Number, expected: "0"},
+		{source: "x := pow(1, 9223372036854775807)", kind: runner.Number, expected: "1"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, _ := r.Get("x")
+			if got.Kind != testCase.kind || got.String() != testCase.expected {
+				t.Errorf("expected %v %s, got %v %s", testCase.kind, testCase.expected, got.Kind, got)
+			}
+		})
+	}
+}
+
+func TestRunnerMathBuiltinErrors(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: `x := abs("5")`, expected: "runtime error at line 1 col 9: abs expects a number, got string"},
+		{source: "x := round(1.5, 0.5)", expected: "runtime error at line 1 col 11: round expects a whole number of digits, got 0.5"},
+		{source: "x := round(1.5, 100000000)", expected: "runtime error at line 1 col 11: round expects at most 1000 digits, got 100000000"},
+		{source: "x := round(1.5, -1001)", expected: "runtime error at line 1 col 11: round expects at most 1000 digits, got -1001"},
+		{source: "x := round()", expected: "runtime error at line 1 col 11: round expects 1 or 2 arguments, got 0"},
+		{source: "x := min()", expected: "runtime error at line 1 col 9: min expects at least 1 argument, got 0"},
+		{source: "x := max(1, true)", expected: "runtime error at line 1 col 9: max expects a number, got boolean"},
+		{source: "x := pow(0.0, -1)", expected: "runtime error at line 1 col 9: pow of zero to a negative exponent"},
+		{source: "x := pow(10.5, 400.5)", expected: "runtime error at line 1 col 9: pow of 10.5 to 400.5 is not a finite number"},
+		{source: "x := pow(-8, 0.5)", expected: "runtime error at line 1 col 9: pow of -8 to 0.5 is not a finite number"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil || err.Error() != testCase.expected {
+				t.Errorf("expected error %q, got %v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestRunnerFormatMoney(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{source: `x := format_money(1234.5, "$")`, expected: "$1,234.50"},
+		{source: `x := format_money(0, "$")`, expected: "$0.00"},
+		{source: `x := format_money(0.004, "$")`, expected: "$0.00"},
+		{source: `x := format_money(0.05, "€")`, expected: "€0.05"},
+		{source: `x := format_money(-5, "$")`, expected: "-$5.00"},
+		{source: `x := format_money(-1234567.891, "£")`, expected: "-£1,234,567.89"},
+		{source: `x := format_money(999.995, "$")`, expected: "$1,000.00"},
+		{source: `x := format_money(123456789012345678.25, "$")`, expected: "$123,456,789,012,345,678.25"},
+		{source: `x := format_money(0.1 + 0.2, "$")`, expected: "$0.30"},
+		{source: `x := format_money(100, "")`, expected: "100.00"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got != runner.StringValue(testCase.expected) {
+				t.Errorf("expected %q, got %+v", testCase.expected, got)
+			}
+		})
+	}
+
+	err := runSource(t, runner.NewRunner(), `x := format_money("12", "$")`)
+	if err == nil || err.Error() != "runtime error at line 1 col 18: format_money expects a number, got string" {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}
+
+func TestRunnerReadline(t *testing.T) {
+	r := runner.NewRunner()
+	r.SetInput(strings.NewReader("Ada\nGrace\r\n\nlast"))
+
+	err := runSource(t, r, "a := readline()\nb := readline()\nc := readline()\nd := readline()\ne := readline()")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]runner.Value{
+		"a": runner.StringValue("Ada"),
+		"b": runner.StringValue("Grace"),
+		"c": runner.StringValue(""),
+		"d": runner.StringValue("last"),
+		"e": {},
+	}
+	for name, value := range expected {
+		if got, _ := r.Get(name); got != value {
+			t.Errorf("expected %s to be %+v, got %+v", name, value, got)
+		}
+	}
+}
+
+func TestRunnerReadlineLoop(t *testing.T) {
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+	r.SetInput(strings.NewReader("3\n4\n"))
+
+	err := runSource(t, r, "line := readline()\nwhile line != null {\n\tprint \"got \" + line\n\tline := readline()\n}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "got 3\ngot 4\n"; output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+
+	err = runSource(t, r, "x := readline(1)")
+	if err == nil || err.Error() != "runtime error at line 1 col 14: readline expects 0 arguments, got 1" {
+		t.Errorf("expected an argument count error, got %v", err)
+	}
+}
+
+func TestRunnerFilesOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "report.txt")
+
+	r := runner.NewRunner()
+	err := runSource(t, r, `write_file("`+report+`", "Total: " + 42)`+"\n"+`x := read_file("`+report+`")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := r.Get("x"); got != runner.StringValue("Total: 42") {
+		t.Errorf("expected the written contents back, got %+v", got)
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil || string(data) != "Total: 42" {
+		t.Errorf("expected the file on disk to hold the report, got %q (%v)", data, err)
+	}
+
+	err = runSource(t, r, `x := read_file("`+filepath.Join(dir, "missing.txt")+`")`)
+	var runtimeErr *runner.RuntimeError
+	if !errors.As(err, &runtimeErr) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a RuntimeError wrapping fs.ErrNotExist, got %v", err)
+	}
+}
+
+// memoryFS is a runner.FileSystem that keeps files in a map.
+type memoryFS map[string]string
+
+func (m memoryFS) ReadFile(name string) ([]byte, error) {
+	content, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", name, fs.ErrNotExist)
+	}
+	return []byte(content), nil
+}
+
+func (m memoryFS) WriteFile(name string, data []byte) error {
+	if strings.HasPrefix(name, "/readonly/") {
+		return fmt.Errorf("write %s: %w", name, fs.ErrPermission)
+	}
+	m[name] = string(data)
+	return nil
+}
+
+func TestRunnerFilesInMemory(t *testing.T) {
+	files := memoryFS{"in.csv": "a,b"}
+	r := runner.NewRunner()
+	r.SetFileSystem(files)
+
+	err := runSource(t, r, `write_file("out.csv", upper(read_file("in.csv")))`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files["out.csv"] != "A,B" {
+		t.Errorf("expected out.csv to hold %q, got %q", "A,B", files["out.csv"])
+	}
+
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: `x := read_file("nope.csv")`, err: "runtime error at line 1 col 15: open nope.csv: file does not exist"},
+		{source: `write_file("/readonly/out.csv", "x")`, err: "runtime error at line 1 col 11: write /readonly/out.csv: permission denied"},
+		{source: `write_file("out.csv", 5)`, err: "runtime error at line 1 col 11: write_file expects a string, got integer"},
+		{source: `x := read_file()`, err: "runtime error at line 1 col 15: read_file expects 1 arguments, got 0"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, r, testCase.source)
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %v", testCase.err, err)
+			}
+		})
+	}
+}
+
+func TestRunnerTypeof(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{expression: "42", expected: "integer"},
+		{expression: "1e3", expected: "number"},
+		{expression: "19.99", expected: "decimal"},
+		{expression: "7 / 2", expected: "decimal"},
+		{expression: "\"text\"", expected: "string"},
+		{expression: "'c'", expected: "string"},
+		{expression: "false", expected: "boolean"},
+		{expression: "null", expected: "null"},
+		{expression: "[1, 2]", expected: "list"},
+		{expression: "{a: 1}", expected: "record"},
+		{expression: "#2024-01-31#", expected: "date"},
+		{expression: "length", expected: "function"},
+		{expression: "helper", expected: "function"},
+		{expression: "typeof(1)", expected: "string"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, "function helper() {\n}\nx := typeof("+testCase.expression+")")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got != runner.StringValue(testCase.expected) {
+				t.Errorf("expected %q, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerTypeofBranching(t *testing.T) {
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+
+	source := "for item in [3, \"n/a\", null] {\n\tswitch typeof(item) {\n\tcase \"integer\": print item * 2\n\tcase \"null\": print \"missing\"\n\tdefault: print \"skipped\"\n\t}\n}"
+	if err := runSource(t, r, source); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "6\nskipped\nmissing\n"; output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+}
+
+func TestRunnerAssert(t *testing.T) {
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+
+	err := runSource(t, r, "total := 5\nassert(total > 0, \"total must be positive\")\nassert(true)\nprint \"checked\"")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "checked\n"; output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+}
+
+func TestRunnerAssertFailures(t *testing.T) {
+	testCases := []struct {
+		source  string
+		err     string
+		message string
+	}{
+		{
+			source:  "total := -5\nassert(total >= 0, \"total cannot be negative\")",
+			err:     "runtime error at line 2 col 7: assertion failed: total cannot be negative",
+			message: "total cannot be negative",
+		},
+		{
+			source: "assert(1 == 2)",
+			err:    "runtime error at line 1 col 7: assertion failed",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+
+			var runtimeErr *runner.RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Errorf("expected a RuntimeError, got %T", err)
+			}
+
+			var assertionErr *runner.AssertionError
+			if !errors.As(err, &assertionErr) || assertionErr.Message != testCase.message {
+				t.Errorf("expected an AssertionError with message %q, got %v", testCase.message, err)
+			}
+		})
+	}
+}
+
+func TestRunnerAssertErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "assert()", err: "runtime error at line 1 col 7: assert expects 1 or 2 arguments, got 0"},
+		{source: `assert(1, "one")`, err: "runtime error at line 1 col 7: assert expects a boolean condition, got integer"},
+		{source: "assert(false, 42)", err: "runtime error at line 1 col 7: assert expects a string, got integer"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+
+			var assertionErr *runner.AssertionError
+			if errors.As(err, &assertionErr) {
+				t.Errorf("expected misuse of assert not to be an assertion failure, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRunnerSurvivesCyclicValues(t *testing.T) {
+	source := "a := [1]\na[0] := a\nr := {items: a}\nr.self := r\na[0] := r\n" +
+		"print a\nprint r\nprint \"{a} {r}\"\nprint a == a\nprint r == r\nprint to_string(r) == to_string(a[0])"
+
+	var output bytes.Buffer
+	r := runner.NewRunner()
+	r.SetOutput(&output)
+
+	// A stack overflow cannot be recovered, so this test crashing outright is
+	// the failure it guards against.
+	err := runSource(t, r, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "[{items: [...], self: {...}}]\n{items: [{...}], self: {...}}\n" +
+		"[{items: [...], self: {...}}] {items: [{...}], self: {...}}\ntrue\ntrue\ntrue\n"
+	if output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+}
+
+func TestRunnerRecoversFromPanics(t *testing.T) {
+	testCases := []struct {
+		name   string
+		source string
+		eval   bool
+		err    string
+	}{
+		{name: "statement", source: "x := explode()", err: "runtime error at line 1 col 1: internal error: runtime error: index out of range [0] with length 0"},
+		{name: "nested statement", source: "if true {\n  print explode()\n}", err: "runtime error at line 2 col 3: internal error: runtime error: index out of range [0] with length 0"},
+		{name: "function body", source: "function f() { return explode() }\ny := f()", err: "runtime error at line 1 col 16: internal error: runtime error: index out of range [0] with length 0"},
+		{name: "evaluated expression", source: "1 + explode()", eval: true, err: "runtime error at line 1 col 1: internal error: runtime error: index out of range [0] with length 0"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := runner.NewRunner()
+			r.SetOutput(&bytes.Buffer{})
+			r.Register("explode", func(args []runner.Value) (runner.Value, error) {
+				var values []runner.Value
+				return values[len(args)], nil // Out of range, as a buggy built-in might index
+			})
+
+			var err error
+			if testCase.eval {
+				tokens, lexErr := lexer.Tokenize(testCase.source)
+				if lexErr != nil {
+					t.Fatalf("unexpected lex error: %v", lexErr)
+				}
+				p := placer.NewPlacer()
+				p.Mode = placer.BraceMode
+				if placeErr := p.PlaceTokens(tokens); placeErr != nil {
+					t.Fatalf("unexpected place error: %v", placeErr)
+				}
+				_, err = r.Eval(p.Root())
+			} else {
+				err = runSource(t, r, testCase.source)
+			}
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+
+			var panicErr *runner.PanicError
+			if !errors.As(err, &panicErr) {
+				t.Fatalf("expected a PanicError, got %T: %v", err, err)
+			}
+			if !strings.Contains(panicErr.Stack, "goroutine") {
+				t.Errorf("expected the PanicError to hold a stack trace, got %q", panicErr.Stack)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+// listFunctionsPrelude defines the functions the map, filter and reduce tests pass around.
+const listFunctionsPrelude = `function double(n) { return n * 2 }
+function even(n) { return n % 2 == 0 }
+function add(total, n) { return total + n }
+function adder(step) {
+	function next(n) { return n + step }
+	return next
+}
+`
+
+func TestRunnerMap(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{expression: "map([1, 2, 3], double)", expected: "[2, 4, 6]"},
+		{expression: "map([], double)", expected: "[]"},
+		{expression: "map([\"a\", \"bc\"], length)", expected: "[1, 2]"},
+		{expression: "map([1, 2], adder(10))", expected: "[11, 12]"},
+		{expression: "map(map([1], double), double)", expected: "[4]"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, listFunctionsPrelude+"x := "+testCase.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got.String() != testCase.expected {
+				t.Errorf("expected %s, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerFilter(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{expression: "filter([1, 2, 3, 4], even)", expected: "[2, 4]"},
+		{expression: "filter([1, 3], even)", expected: "[]"},
+		{expression: "filter([], even)", expected: "[]"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, listFunctionsPrelude+"x := "+testCase.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got.String() != testCase.expected {
+				t.Errorf("expected %s, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerReduce(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{expression: "reduce([1, 2, 3], add, 0)", expected: "6"},
+		{expression: "reduce([], add, 0)", expected: "0"},
+		{expression: "reduce([\"b\", \"c\"], add, \"a\")", expected: "abc"},
+		{expression: "reduce(map([1, 2], double), add, 0)", expected: "6"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, listFunctionsPrelude+"x := "+testCase.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := r.Get("x"); got.String() != testCase.expected {
+				t.Errorf("expected %s, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunnerListFunctionErrors(t *testing.T) {
+	testCases := []struct {
+		expression string
+		err        string
+	}{
+		{expression: "map([1])", err: "runtime error at line 8 col 9: map expects 2 arguments, got 1"},
+		{expression: "map(1, double)", err: "runtime error at line 8 col 9: map expects a list, got integer"},
+		{expression: "map([1], 2)", err: "runtime error at line 8 col 9: map expects a function, got integer"},
+		{expression: "map([1], add)", err: "runtime error at line 8 col 9: function add expects 2 arguments, got 1"},
+		{expression: "map([1], length)", err: "runtime error at line 8 col 9: length expects a list or string, got integer"},
+		{expression: "map([\"a\"], double)", err: "runtime error at line 1 col 31: cannot apply * to string and integer"},
+		{expression: "filter([1], \"even\")", err: "runtime error at line 8 col 12: filter expects a function, got string"},
+		{expression: "filter([1], double)", err: "runtime error at line 8 col 12: filter expects the function to return a boolean, got integer"},
+		{expression: "reduce([1], add)", err: "runtime error at line 8 col 12: reduce expects 3 arguments, got 2"},
+		{expression: "reduce({a: 1}, add, 0)", err: "runtime error at line 8 col 12: reduce expects a list, got record"},
+		{expression: "reduce([1], null, 0)", err: "runtime error at line 8 col 12: reduce expects a function, got null"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), listFunctionsPrelude+"x := "+testCase.expression)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.expression)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerLambda(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{name: "called through a variable", source: "double := fn(x) -> x * 2\nprint double(21)", expected: "42\n"},
+		{name: "called directly", source: "print (fn(a, b) -> a + b)(1, 2)", expected: "3\n"},
+		{name: "no parameters", source: "print (fn() -> \"none\")()", expected: "none\n"},
+		{name: "passed to map", source: "print map([1, 2, 3], fn(x) -> x * 2)", expected: "[2, 4, 6]\n"},
+		{name: "passed to filter", source: "print filter([1, 2, 3, 4], fn(x) -> x > 2)", expected: "[3, 4]\n"},
+		{name: "passed to reduce", source: "print reduce([1, 2, 3], fn(total, x) -> total + x, 0)", expected: "6\n"},
+		{name: "conditional body", source: "size := fn(x) -> x > 9 ? \"big\" : \"small\"\nprint size(10)\nprint size(1)", expected: "big\nsmall\n"},
+		{name: "printed", source: "print fn(x) -> x", expected: "function lambda\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerLambdaClosures(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "outer variable",
+			source:   "rate := 3\nscale := fn(x) -> x * rate\nprint scale(2)",
+			expected: "6\n",
+		},
+		{
+			name:     "outer variable changed after capture",
+			source:   "rate := 3\nscale := fn(x) -> x * rate\nrate := 4\nprint scale(2)",
+			expected: "8\n",
+		},
+		{
+			name:     "parameter of the enclosing function",
+			source:   "function multiplier(n) {\n\treturn fn(x) -> x * n\n}\ntens := multiplier(10)\nthrees := multiplier(3)\nprint map([1, 2], tens)\nprint threes(2)",
+			expected: "[10, 20]\n6\n",
+		},
+		{
+			name:     "nested lambdas",
+			source:   "add := fn(a) -> fn(b) -> a + b\nprint add(1)(2)",
+			expected: "3\n",
+		},
+		{
+			name:     "parameter shadows an outer variable",
+			source:   "x := 100\nid := fn(x) -> x\nprint id(1)\nprint x",
+			expected: "1\n100\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var output bytes.Buffer
+			r := runner.NewRunner()
+			r.SetOutput(&output)
+			err := runSource(t, r, testCase.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != testCase.expected {
+				t.Errorf("expected output %q, got %q", testCase.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestRunnerLambdaErrors(t *testing.T) {
+	testCases := []struct {
+		source string
+		err    string
+	}{
+		{source: "f := fn(x) -> x\nf(1, 2)", err: "runtime error at line 2 col 2: function lambda expects 1 arguments, got 2"},
+		{source: "y := map([1], fn(a, b) -> a)", err: "runtime error at line 1 col 9: function lambda expects 2 arguments, got 1"},
+		{source: "f := fn(x) -> x / 0\ny := f(1)", err: "runtime error at line 1 col 17: division by zero"},
+		{source: "f := fn(x) -> missing\ny := f(1)", err: "runtime error at line 1 col 15: undefined variable \"missing\""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.source, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), testCase.source)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.source)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerConversions(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{expression: "to_number(\"42\")", expected: "integer 42"},
+		{expression: "to_number(\" -1_250.50 \")", expected: "decimal -1250.5"},
+		{expression: "to_number(\"+7\")", expected: "integer 7"},
+		{expression: "to_number(\"5%\")", expected: "decimal 0.05"},
+		{expression: "to_number(\"2k\")", expected: "integer 2000"},
+		{expression: "to_number(\"1e3\")", expected: "number 1000"},
+		{expression: "to_number(\"0x1F\")", expected: "integer 31"},
+		{expression: "to_number(3.5)", expected: "decimal 3.5"},
+		{expression: "to_number(to_string(19.90))", expected: "decimal 19.9"},
+		{expression: "to_string(42)", expected: "string 42"},
+		{expression: "to_string(19.90)", expected: "string 19.9"},
+		{expression: "to_string(\"text\")", expected: "string text"},
+		{expression: "to_string(true)", expected: "string true"},
+		{expression: "to_string(null)", expected: "string null"},
+		{expression: "to_string([1, \"a\"])", expected: "string [1, \"a\"]"},
+		{expression: "to_string({a: 1})", expected: "string {a: 1}"},
+		{expression: "to_boolean(true)", expected: "boolean true"},
+		{expression: "to_boolean(null)", expected: "boolean false"},
+		{expression: "to_boolean(0)", expected: "boolean false"},
+		{expression: "to_boolean(-3)", expected: "boolean true"},
+		{expression: "to_boolean(0.0)", expected: "boolean false"},
+		{expression: "to_boolean(1e-9)", expected: "boolean true"},
+		{expression: "to_boolean(\" Yes \")", expected: "boolean true"},
+		{expression: "to_boolean(\"TRUE\")", expected: "boolean true"},
+		{expression: "to_boolean(\"1\")", expected: "boolean true"},
+		{expression: "to_boolean(\"no\")", expected: "boolean false"},
+		{expression: "to_boolean(\"False\")", expected: "boolean false"},
+		{expression: "to_boolean(\"0\")", expected: "boolean false"},
+		{expression: "to_boolean(\"\")", expected: "boolean false"},
+		{expression: "to_boolean([])", expected: "boolean false"},
+		{expression: "to_boolean([0])", expected: "boolean true"},
+		{expression: "to_boolean({})", expected: "boolean false"},
+		{expression: "to_boolean({a: null})", expected: "boolean true"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			r := runner.NewRunner()
+			err := runSource(t, r, "x := "+testCase.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, _ := r.Get("x")
+			if described := got.Kind.String() + " " + got.String(); described != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, described)
+			}
+		})
+	}
+}
+
+func TestRunnerConversionErrors(t *testing.T) {
+	testCases := []struct {
+		expression string
+		err        string
+	}{
+		{expression: "to_number(\"abc\")", err: "runtime error at line 1 col 15: to_number cannot convert \"abc\" to a number"},
+		{expression: "to_number(\"\")", err: "runtime error at line 1 col 15: to_number cannot convert \"\" to a number"},
+		{expression: "to_number(\"1 2\")", err: "runtime error at line 1 col 15: to_number cannot convert \"1 2\" to a number"},
+		{expression: "to_number(\"--1\")", err: "runtime error at line 1 col 15: to_number cannot convert \"--1\" to a number"},
+		{expression: "to_number(\"12abc\")", err: "runtime error at line 1 col 15: to_number cannot convert \"12abc\" to a number"},
+		{expression: "to_number(true)", err: "runtime error at line 1 col 15: to_number cannot convert boolean to a number"},
+		{expression: "to_number(null)", err: "runtime error at line 1 col 15: to_number cannot convert null to a number"},
+		{expression: "to_number(\"1\", \"2\")", err: "runtime error at line 1 col 15: to_number expects 1 arguments, got 2"},
+		{expression: "to_string()", err: "runtime error at line 1 col 15: to_string expects 1 arguments, got 0"},
+		{expression: "to_boolean(\"maybe\")", err: "runtime error at line 1 col 16: to_boolean cannot convert \"maybe\" to a boolean"},
+		{expression: "to_boolean(length)", err: "runtime error at line 1 col 16: to_boolean cannot convert function to a boolean"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			err := runSource(t, runner.NewRunner(), "x := "+testCase.expression)
+			if err == nil {
+				t.Fatalf("expected an error for %q", testCase.expression)
+			}
+			if err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunnerBuiltins(t *testing.T) {
+	r := runner.NewRunner()
+	names := r.Builtins()
+
+	for _, name := range []string{"abs", "ceil", "contains", "floor", "format_money", "length", "lower", "max", "map", "filter", "reduce", "min", "pow", "read_file", "readline", "round", "substring", "to_boolean", "to_number", "to_string", "trim", "upper", "write_file"} {
+		found := false
+		for _, builtin := range names {
+			found = found || builtin == name
+		}
+		if !found {
+			t.Errorf("expected %s to be registered, got %v", name, names)
+		}
+	}
+
+	r.Register("double", func(args []runner.Value) (runner.Value, error) {
+		return runner.IntegerValue(args[0].Int * 2), nil
+	})
+
+	err := runSource(t, r, "x := double(21)\nupper := 1\ny := upper")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := r.Get("x"); got != runner.IntegerValue(42) {
+		t.Errorf("expected the registered builtin to run, got %+v", got)
+	}
+	if got, _ := r.Get("y"); got != runner.IntegerValue(1) {
+		t.Errorf("expected variables to shadow builtins, got %+v", got)
+	}
+
+	err = runSource(t, runner.NewRunner(), "x := upper(1)")
+	if err == nil || err.Error() != "runtime error at line 1 col 11: upper expects a string, got integer" {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}